@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// registeredAnalyses are the reports run with `fulcrum analyze <name>`.
+var registeredAnalyses = map[string]Command{}
+
+func RegisterAnalysis(analysis Command) {
+	registeredAnalyses[analysis.Name] = analysis
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "analyze",
+		Description: "Compute pipeline reports from lever or a local export, e.g. analyze stage-durations",
+		Run:         runAnalyze,
+	})
+}
+
+func runAnalyze(args []string) error {
+	names := make([]string, 0, len(registeredAnalyses))
+	for name := range registeredAnalyses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return fmt.Errorf("analyze needs a report, one of %s", strings.Join(names, ", "))
+	}
+
+	analysis, ok := registeredAnalyses[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown report %q, expected one of %s", args[0], strings.Join(names, ", "))
+	}
+	return analysis.Run(args[1:])
+}
+
+// Percentile interpolates the pth percentile of sorted values.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// ParsePercentiles parses a comma separated list such as 75,90.
+func ParsePercentiles(value string) ([]float64, error) {
+	var percentiles []float64
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+
+		var p float64
+		if _, err := fmt.Sscanf(field, "%g", &p); err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("percentile %q must be a number from 0 to 100", field)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ErasureRecord is written for every candidate an erasure was requested for
+// so there is a trail of what was asked of lever and what it confirmed.
+type ErasureRecord struct {
+	CandidateID string     `json:"candidateId"`
+	RequestedAt time.Time  `json:"requestedAt"`
+	Status      int        `json:"status"`
+	Confirmed   bool       `json:"confirmed"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// AnonymizeCandidates requests deletion of every candidate listed in the
+// input csv and then confirms lever no longer returns them. An erasure lever
+// fails or doesn't confirm stops the run so it is retried on resume.
+func AnonymizeCandidates(endpoint Endpoint, input string, state *Checkpoint) error {
+	if !endpoint.HasQueryParam("perform_as") {
+		logrus.Fatal("Lever requires --performAs to record who requested the erasure.")
+	}
+
+	// The input is mapped like any other upload's with --upload-map
+	r, firstRow, f, err := OpenUploadRows(endpoint, input)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer f.Close()
+
+	for row := firstRow; ; row++ {
+		record, err := r.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			logrus.Fatalf("row %d: %v", row, err)
+		}
+
+		candidateID := strings.TrimSpace(record[0])
+
+		if !shard.Contains(candidateID) {
+			continue
+		}
+
+		if checkReached := state.ReachedCheckpoint(candidateID); !checkReached {
+			continue
+		}
+
+		endpoint.Arguments = []interface{}{candidateID}
+		erasure := requestErasure(endpoint.Method, endpoint.URLString(), candidateID)
+
+		audit.Write(AuditEntry{Event: "erasure", URL: endpoint.URLString(), Status: erasure.Status, Error: erasure.Error})
+		Output(erasure, enc)
+
+		// Not checkpointing the candidate means the erasure is retried on resume
+		if erasure.Error != "" {
+			return fmt.Errorf("erasing candidate %s: %s", candidateID, erasure.Error)
+		}
+
+		state.UpdateLastID(candidateID)
+		state.CheckPoint()
+	}
+
+	// The next erasure request is likely a different input, don't skip its rows
+	state.Remove()
+	return nil
+}
+
+// planErasure plans deleting a candidate lever still has.
+func planErasure(endpoint Endpoint, row []string) ([]Mutation, error) {
+	candidate, err := FetchCandidate(row[0])
+	if err != nil || candidate == nil {
+		return nil, err
+	}
+	return []Mutation{NewMutation(endpoint, "erase", row[0], nil, nil, nil)}, nil
+}
+
+func requestErasure(method, url, candidateID string) ErasureRecord {
+	erasure := ErasureRecord{CandidateID: candidateID, RequestedAt: time.Now().UTC()}
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		erasure.Error = err.Error()
+		return erasure
+	}
+	req = WithRequestFields(req, logrus.Fields{"candidateId": candidateID})
+
+	resp, body, err := SendLeverRequest(req)
+	if err != nil {
+		erasure.Error = err.Error()
+		return erasure
+	}
+	erasure.Status = resp.StatusCode
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		erasure.Error = NewLeverError(resp, body).Error()
+		return erasure
+	}
+
+	// Only consider the erasure confirmed once lever stops returning the
+	// candidate.
+	lookup := Endpoint{Method: "GET", SprintfPath: "/candidates/%s", Arguments: []interface{}{candidateID}}
+	req, err = http.NewRequest(lookup.Method, lookup.URLString(), nil)
+	if err != nil {
+		erasure.Error = err.Error()
+		return erasure
+	}
+	req = WithRequestFields(req, logrus.Fields{"candidateId": candidateID})
+
+	resp, _, err = SendLeverRequest(req)
+	if err != nil {
+		erasure.Error = err.Error()
+		return erasure
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		erasure.Confirmed = true
+		confirmedAt := time.Now().UTC()
+		erasure.ConfirmedAt = &confirmedAt
+	} else {
+		erasure.Error = fmt.Sprintf("candidate still returned by lever with status %d", resp.StatusCode)
+	}
+	return erasure
+}
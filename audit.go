@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// AuditEntry is a single line in the audit log. Every run writes a
+// run_start entry with the resolved config, one request entry per api call
+// and a run_end entry.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	URL       string    `json:"url,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	LatencyMs int64     `json:"latencyMs,omitempty"`
+	Retries   int       `json:"retries"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Config    *Config   `json:"config,omitempty"`
+}
+
+// AuditLog appends AuditEntry records to a JSONL file. A nil AuditLog is
+// valid and discards everything so callers don't need to check if auditing
+// is enabled.
+type AuditLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+var audit *AuditLog
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: f, encoder: json.NewEncoder(f)}, nil
+}
+
+func (a *AuditLog) Write(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	if err := a.encoder.Encode(entry); err != nil {
+		logrus.Error("Unable to write audit log: ", err)
+	}
+}
+
+// RunStart records the config the run resolved to with the api token redacted.
+func (a *AuditLog) RunStart(config *Config) {
+	redacted := *config
+	if redacted.LeverToken != "" {
+		redacted.LeverToken = "REDACTED"
+	}
+	if redacted.SMTPPassword != "" {
+		redacted.SMTPPassword = "REDACTED"
+	}
+	// Webhook urls carry their own secret
+	if redacted.NotifySlack != "" {
+		redacted.NotifySlack = "REDACTED"
+	}
+	if redacted.PagerDutyKey != "" {
+		redacted.PagerDutyKey = "REDACTED"
+	}
+	if redacted.SentryDSN != "" {
+		redacted.SentryDSN = "REDACTED"
+	}
+	a.Write(AuditEntry{Event: "run_start", Config: &redacted})
+}
+
+func (a *AuditLog) RunEnd(err error) {
+	entry := AuditEntry{Event: "run_end"}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	a.Write(entry)
+}
+
+func (a *AuditLog) Close() {
+	if a == nil {
+		return
+	}
+	a.file.Close()
+}
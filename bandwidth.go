@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthLimiter caps the bytes per second read by all file downloads
+// together, on top of and independent from the api request rate limit.
+type BandwidthLimiter struct {
+	Rate int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// bandwidth is nil unless --max-bandwidth is used, a nil limiter doesn't
+// limit.
+var bandwidth *BandwidthLimiter
+
+// ParseBandwidth parses rates such as 10MB/s, the /s is optional.
+func ParseBandwidth(value string) (*BandwidthLimiter, error) {
+	rate, err := ParseByteSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if err != nil || rate <= 0 {
+		return nil, fmt.Errorf("unable to parse bandwidth %q, expected a rate like 10MB/s", value)
+	}
+	return &BandwidthLimiter{Rate: rate}, nil
+}
+
+// Reader limits reads from r.
+func (b *BandwidthLimiter) Reader(r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiter: b}
+}
+
+// wait books n bytes against the rate and sleeps until they're due.
+func (b *BandwidthLimiter) wait(n int) {
+	b.mu.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	due := b.next
+	b.next = b.next.Add(time.Duration(int64(n) * int64(time.Second) / b.Rate))
+	b.mu.Unlock()
+
+	time.Sleep(time.Until(due))
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *BandwidthLimiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// Small reads keep the pace smooth rather than a burst then a long pause
+	if max := int(l.limiter.Rate / 10); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+
+	n, err := l.r.Read(p)
+	if n > 0 {
+		l.limiter.wait(n)
+	}
+	return n, err
+}
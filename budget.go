@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// exitOverBudget is the exit code of a run stopped by --run-deadline or
+// --retry-budget, so schedulers can tell it from other failures.
+const exitOverBudget = 3
+
+// RunBudget stops an unhealthy run from retrying forever. The run ends at
+// Deadline or once MaxRetries retries have been used, zero values are
+// unlimited.
+type RunBudget struct {
+	mu         sync.Mutex
+	Deadline   time.Time
+	MaxRetries int
+	retries    int
+	exceeded   string
+}
+
+// runBudget is nil unless --run-deadline or --retry-budget is used, every
+// method is safe to call on a nil budget.
+var runBudget *RunBudget
+
+// Allow returns an error once the run is over budget.
+func (b *RunBudget) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.exceeded == "" && !b.Deadline.IsZero() && time.Now().After(b.Deadline) {
+		b.exceeded = "run deadline passed"
+	}
+
+	if b.exceeded != "" {
+		return fmt.Errorf("stopping the run: %s", b.exceeded)
+	}
+	return nil
+}
+
+// Retry uses up a retry that will wait before being sent. It reports false,
+// and the budget is exceeded, when the retry is not allowed.
+func (b *RunBudget) Retry(wait time.Duration) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.MaxRetries > 0 && b.retries >= b.MaxRetries:
+		b.exceeded = fmt.Sprintf("retry budget of %d used up", b.MaxRetries)
+	case !b.Deadline.IsZero() && time.Now().Add(wait).After(b.Deadline):
+		b.exceeded = "run deadline would pass before the next retry"
+	default:
+		b.retries++
+	}
+	return b.exceeded == ""
+}
+
+func (b *RunBudget) Exceeded() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exceeded != ""
+}
+
+// FailureReport is written when a run is stopped for going over budget.
+type FailureReport struct {
+	Endpoint    string          `json:"endpoint"`
+	Reason      string          `json:"reason"`
+	Error       string          `json:"error,omitempty"`
+	FailedAt    time.Time       `json:"failedAt"`
+	Deadline    *time.Time      `json:"deadline,omitempty"`
+	RetriesUsed int             `json:"retriesUsed"`
+	RetryBudget int             `json:"retryBudget,omitempty"`
+	Requests    int             `json:"requests"`
+	Records     int             `json:"records"`
+	Checkpoint  *checkpointFile `json:"checkpoint,omitempty"`
+}
+
+// ExitOverBudget checkpoints the progress made so far, writes the failure
+// report next to the checkpoint and exits with exitOverBudget.
+func ExitOverBudget(endpoint string, cause error, state *Checkpoint) {
+	// The output is finished off, closing a json array and the last part,
+	// before the checkpoint says its records were written
+	if err := CloseOutput(); err != nil {
+		logrus.Fatal("Unable to close output, not checkpointing: ", err)
+	}
+	state.CheckPoint()
+
+	runBudget.mu.Lock()
+	report := FailureReport{
+		Endpoint:    endpoint,
+		Reason:      runBudget.exceeded,
+		FailedAt:    time.Now().UTC(),
+		RetriesUsed: runBudget.retries,
+		RetryBudget: runBudget.MaxRetries,
+		Checkpoint:  state.Saved(),
+	}
+	if !runBudget.Deadline.IsZero() {
+		deadline := runBudget.Deadline.UTC()
+		report.Deadline = &deadline
+	}
+	runBudget.mu.Unlock()
+
+	if cause != nil {
+		report.Error = cause.Error()
+	}
+
+	stats.mu.Lock()
+	report.Requests, report.Records = stats.Requests, stats.Records
+	stats.mu.Unlock()
+
+	path := StatePath(state.Key + "_failure_report.json")
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(path, append(data, '\n'), 0600)
+	}
+	if err != nil {
+		logrus.Error("Unable to write failure report: ", err)
+	}
+
+	if err := NotifyRunEnd(fmt.Errorf("%s: %v", report.Reason, cause)); err != nil {
+		logrus.Warn(err)
+	}
+	CloseStateStores()
+
+	logrus.WithFields(logrus.Fields{"reason": report.Reason, "report": path}).Error("Run stopped over budget, rerun to resume from the checkpoint")
+	logrus.Exit(exitOverBudget)
+}
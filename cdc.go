@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ChangeEvent is written by --cdc in place of a record, describing how it
+// changed since the version seen by the previous run or poll.
+type ChangeEvent struct {
+	Event      string                 `json:"event"`
+	Resource   string                 `json:"resource"`
+	ID         string                 `json:"id"`
+	DetectedAt time.Time              `json:"detectedAt"`
+	Changes    map[string]FieldChange `json:"changes,omitempty"`
+	After      interface{}            `json:"after"`
+}
+
+// FieldChange is the before and after value of a top level field.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// VersionStore keeps the last written version of each record in the run's
+// state store, keyed by record id. Versions are staged as records are written
+// and saved with the checkpoint that covers them, so a crash never leaves
+// versions of records that didn't make it into the output.
+type VersionStore struct {
+	store StateStore
+
+	mu     sync.Mutex
+	staged map[string][]byte
+}
+
+// versions is nil unless --cdc is used.
+var versions *VersionStore
+
+func (s *VersionStore) Get(id string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	data, ok := s.staged[id]
+	s.mu.Unlock()
+
+	var err error
+	if !ok {
+		if data, err = s.store.Get(versionBucket, id); data == nil || err != nil {
+			return nil, err
+		}
+	}
+
+	var record map[string]interface{}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+// Stage remembers the version of a record that has been written, until Save.
+func (s *VersionStore) Stage(id string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.staged == nil {
+		s.staged = map[string][]byte{}
+	}
+	s.staged[id] = data
+	return nil
+}
+
+// Save writes puts to store in one transaction with the versions staged
+// since the last save. Without --cdc, or for another store, the puts are
+// written on their own.
+func (s *VersionStore) Save(store StateStore, puts ...StatePut) error {
+	if s == nil || s.store != store {
+		return store.Batch(puts)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, data := range s.staged {
+		puts = append(puts, StatePut{Bucket: versionBucket, Key: id, Value: data})
+	}
+
+	if err := s.store.Batch(puts); err != nil {
+		return err
+	}
+	s.staged = nil
+	return nil
+}
+
+// Change compares a record with its stored version and returns the change
+// event to write, or nil if nothing changed. record is what would otherwise
+// be written, original the lever struct it came from. Storing the new
+// version is left to the caller once the event is written.
+func (s *VersionStore) Change(resource string, original, record interface{}) (*ChangeEvent, error) {
+	id := recordID(original)
+	if id == "" {
+		return &ChangeEvent{Event: "updated", Resource: resource, DetectedAt: time.Now().UTC(), After: record}, nil
+	}
+
+	// Round trip through json so stored and current versions compare alike
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var after map[string]interface{}
+	if err := json.Unmarshal(data, &after); err != nil {
+		return nil, err
+	}
+
+	before, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &ChangeEvent{Event: "created", Resource: resource, ID: id, DetectedAt: time.Now().UTC(), After: record}
+	if before != nil {
+		event.Event = "updated"
+		event.Changes = diffFields(before, after)
+		if len(event.Changes) == 0 {
+			return nil, nil
+		}
+
+		if change, ok := event.Changes["archivedAt"]; ok && isUnset(change.Before) && !isUnset(change.After) {
+			event.Event = "archived"
+		}
+	}
+
+	return event, nil
+}
+
+func diffFields(before, after map[string]interface{}) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changes[key] = FieldChange{Before: before[key], After: value}
+		}
+	}
+
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			changes[key] = FieldChange{Before: value}
+		}
+	}
+	return changes
+}
+
+func isUnset(value interface{}) bool {
+	return value == nil || value == float64(0) || value == ""
+}
+
+func recordID(obj interface{}) string {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+
+	if id := v.FieldByName("ID"); id.IsValid() && id.Kind() == reflect.String {
+		return id.String()
+	}
+	return ""
+}
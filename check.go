@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "check",
+		Description: "Validate the api token, its endpoint access and connectivity to lever",
+		Run:         runCheck,
+	})
+}
+
+func runCheck(args []string) error {
+	flags := NewCommandFlags("check")
+	flags.Parse(args)
+	RequireToken()
+
+	host := strings.SplitN(baseURI, "/", 2)[0]
+
+	if err := checkConnectivity(host); err != nil {
+		return err
+	}
+
+	// Lever api keys are not tied to a user or expose the account they
+	// belong to, so the best we can do is report which endpoints it can read.
+	users := Endpoint{Method: "GET", SprintfPath: "/users", QueryParams: []QueryParam{{Field: "limit", Value: "1"}}}
+	status, _, err := probe(&users)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case http.StatusOK:
+		fmt.Println("token: valid")
+	case http.StatusUnauthorized:
+		return &LeverError{StatusCode: status, URL: users.URLString(), Message: "token rejected"}
+	case http.StatusForbidden:
+		fmt.Println("token: valid, but cannot read users")
+	default:
+		return fmt.Errorf("unexpected %d checking token against %s", status, users.URLString())
+	}
+
+	candidateID := ""
+	candidates := Endpoint{Method: "GET", SprintfPath: "/candidates", QueryParams: []QueryParam{{Field: "limit", Value: "1"}}}
+	if status, page, err := probe(&candidates); err == nil && status == http.StatusOK {
+		var found []Candidate
+		if json.Unmarshal(page.Data, &found) == nil && len(found) > 0 {
+			candidateID = found[0].ID
+		}
+	}
+
+	names := make([]string, 0, len(registeredEndpoints))
+	for name := range registeredEndpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	denied := 0
+	for _, name := range names {
+		endpoint := registeredEndpoints[name]
+		if endpoint.Method != "GET" {
+			continue
+		}
+
+		if strings.Contains(endpoint.SprintfPath, "%s") {
+			if candidateID == "" {
+				fmt.Printf("scope %-28s skipped, no candidate to test with\n", name)
+				continue
+			}
+			endpoint.Arguments = []interface{}{candidateID}
+		}
+		endpoint.QueryParams = []QueryParam{{Field: "limit", Value: "1"}}
+
+		status, _, err := probe(&endpoint)
+		switch {
+		case err != nil:
+			return err
+		case status == http.StatusOK:
+			fmt.Printf("scope %-28s ok\n", name)
+		default:
+			denied++
+			fmt.Printf("scope %-28s denied (%d)\n", name, status)
+		}
+	}
+
+	if denied > 0 {
+		return fmt.Errorf("token cannot read %d endpoint(s), enable them for the key under Settings > Integrations and API", denied)
+	}
+	return nil
+}
+
+// checkConnectivity makes sure we can resolve and reach lever, going through
+// any proxy configured in the environment.
+func checkConnectivity(host string) error {
+	req, err := http.NewRequest("GET", "https://"+host, nil)
+	if err != nil {
+		return err
+	}
+
+	target := host + ":443"
+	proxy, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		return fmt.Errorf("invalid proxy configuration: %v", err)
+	}
+
+	if proxy != nil {
+		fmt.Println("proxy:", proxy.Host)
+		target = proxy.Host
+		if proxy.Port() == "" {
+			target = net.JoinHostPort(proxy.Hostname(), "80")
+		}
+	} else if _, err := net.LookupHost(host); err != nil {
+		return fmt.Errorf("unable to resolve %s, check DNS or set HTTPS_PROXY: %v", host, err)
+	}
+
+	conn, err := net.DialTimeout("tcp", target, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s, check outbound firewall rules or HTTPS_PROXY: %v", target, err)
+	}
+	conn.Close()
+
+	fmt.Println("connectivity: ok")
+	return nil
+}
+
+func probe(endpoint *Endpoint) (int, *LeverData, error) {
+	req, err := http.NewRequest("GET", endpoint.URLString(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resp, body, err := SendLeverRequest(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("request to %s failed: %v", endpoint.URLString(), err)
+	}
+
+	var page LeverData
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(body, &page); err != nil {
+			return resp.StatusCode, nil, err
+		}
+	}
+	return resp.StatusCode, &page, nil
+}
@@ -1,23 +1,67 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 )
 
 type Checkpoint struct {
+	// Key names the checkpoint and its state store. FilePath and LegacyPath
+	// are where checkpoints were kept before the state store, they are still
+	// resumed from.
+	Key                  string
 	FilePath             string
+	LegacyPath           string
 	LastSeenID           string
 	HasReachedCheckpoint bool
+	Cursor               string
+	CursorID             string
+	LastRow              int
+	Source               string
+	loaded               bool
+	store                StateStore
+	failures             map[string]bool
+
+	// NoSave keeps progress from being saved, for output that only reaches
+	// the sink at the end of the run.
+	NoSave bool
+}
+
+// checkpointFile is the on disk representation of a checkpoint. Older
+// checkpoints only contained the last candidate id as plain text.
+type checkpointFile struct {
+	LastID   string `json:"lastId"`
+	Cursor   string `json:"cursor,omitempty"`
+	CursorID string `json:"cursorId,omitempty"`
+	LastRow  int    `json:"lastRow,omitempty"`
+	Source   string `json:"source,omitempty"`
 }
 
 func NewCheckpoint(prefix string) *Checkpoint {
-	fp := fmt.Sprintf("/tmp/%s_candidate_id", prefix)
-	return &Checkpoint{FilePath: fp, HasReachedCheckpoint: false}
+	fp := StatePath(prefix + "_candidate_id")
+
+	// Checkpoints used to be kept in /tmp, still resume from them
+	legacy := filepath.Join(os.TempDir(), prefix+"_candidate_id")
+	return &Checkpoint{Key: prefix, FilePath: fp, LegacyPath: legacy, HasReachedCheckpoint: false}
+}
+
+// Store opens the checkpoint's state store on first use, after the run lock
+// is held.
+func (cp *Checkpoint) Store() (StateStore, error) {
+	if cp.store == nil {
+		store, err := OpenStateStore(cp.Key)
+		if err != nil {
+			return nil, err
+		}
+		cp.store = store
+	}
+	return cp.store, nil
 }
 
 func (cp *Checkpoint) ReachedCheckpoint(id string) bool {
@@ -36,30 +80,219 @@ func (cp *Checkpoint) ReachedCheckpoint(id string) bool {
 }
 
 func (cp *Checkpoint) LastProcessedID() string {
-	if strings.Compare(cp.LastSeenID, "") != 0 {
-		return cp.LastSeenID
-	}
+	cp.load()
+	return cp.LastSeenID
+}
 
-	var lastID []byte
-	var err error
-	if lastID, err = ioutil.ReadFile(cp.FilePath); err != nil {
-		logrus.Error(err)
+// ResumeCursor returns the saved page cursor if it belongs to id. Top level
+// downloads use an empty id.
+func (cp *Checkpoint) ResumeCursor(id string) string {
+	cp.load()
+	if cp.Cursor != "" && cp.CursorID == id {
+		logrus.Info("Resuming from saved cursor ", cp.Cursor)
+		return cp.Cursor
 	}
-
-	cp.LastSeenID = string(lastID)
-	return cp.LastSeenID
+	return ""
 }
 
 func (cp *Checkpoint) UpdateLastID(id string) {
 	cp.LastSeenID = id
 }
 
+// ResumeRow returns the last input row an upload finished, rows up to and
+// including it have been written to lever.
+func (cp *Checkpoint) ResumeRow() int {
+	cp.load()
+	return cp.LastRow
+}
+
+func (cp *Checkpoint) UpdateLastRow(row int) {
+	cp.LastRow = row
+}
+
+// ResumeSource returns what the saved rows were read from, so rows of a
+// different input are never skipped.
+func (cp *Checkpoint) ResumeSource() string {
+	cp.load()
+	return cp.Source
+}
+
+func (cp *Checkpoint) UpdateSource(source string) {
+	cp.Source = source
+}
+
+// UpdateCursor records the next page token for id so a crash mid pagination
+// can pick up at the same page.
+func (cp *Checkpoint) UpdateCursor(id, cursor string) {
+	cp.CursorID = id
+	cp.Cursor = cursor
+}
+
 func (cp *Checkpoint) CheckPoint() {
-	if err := ioutil.WriteFile(cp.FilePath, []byte(cp.LastProcessedID()), 0644); err != nil {
+	cp.load()
+
+	// Never record progress for records still sitting in an output buffer
+	FlushOutput()
+	if cp.NoSave {
+		return
+	}
+
+	data, err := json.Marshal(checkpointFile{
+		LastID:   cp.LastSeenID,
+		Cursor:   cp.Cursor,
+		CursorID: cp.CursorID,
+		LastRow:  cp.LastRow,
+		Source:   cp.Source,
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	store, err := cp.Store()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := versions.Save(store, StatePut{Bucket: checkpointBucket, Key: cp.Key, Value: data}); err != nil {
 		logrus.Fatal(err)
 	}
 }
 
+// Saved returns the checkpoint as last written to disk, nil when there is
+// none.
+func (cp *Checkpoint) Saved() *checkpointFile {
+	data, err := cp.read()
+	if err != nil {
+		return nil
+	}
+
+	var saved checkpointFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		saved = checkpointFile{LastID: strings.TrimSpace(string(data))}
+	}
+	return &saved
+}
+
 func (cp *Checkpoint) Remove() {
+	if store, err := cp.Store(); err == nil {
+		if err := store.Delete(checkpointBucket, cp.Key); err != nil {
+			logrus.Error(err)
+		}
+	}
+
 	os.Remove(cp.FilePath)
+	if cp.LegacyPath != "" {
+		os.Remove(cp.LegacyPath)
+	}
+}
+
+// read returns the saved checkpoint, falling back to the files checkpoints
+// were kept in before.
+func (cp *Checkpoint) read() ([]byte, error) {
+	store, err := cp.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := store.Get(checkpointBucket, cp.Key)
+	if data != nil || err != nil {
+		return data, err
+	}
+
+	data, err = ioutil.ReadFile(cp.FilePath)
+	if os.IsNotExist(err) && cp.LegacyPath != "" {
+		return ioutil.ReadFile(cp.LegacyPath)
+	}
+	return data, err
+}
+
+func (cp *Checkpoint) load() {
+	if cp.loaded {
+		return
+	}
+	cp.loaded = true
+
+	data, err := cp.read()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Error(err)
+		}
+		return
+	}
+
+	var saved checkpointFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		saved = checkpointFile{LastID: strings.TrimSpace(string(data))}
+	}
+
+	if cp.LastSeenID == "" {
+		cp.LastSeenID = saved.LastID
+	}
+	cp.Cursor = saved.Cursor
+	cp.CursorID = saved.CursorID
+	if cp.LastRow == 0 {
+		cp.LastRow = saved.LastRow
+	}
+	if cp.Source == "" {
+		cp.Source = saved.Source
+	}
+}
+
+// failure is an id queued by a failed run.
+type failure struct {
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// QueueFailure remembers an id the run failed on so it shows up in
+// `fulcrum state` until a later run gets through it.
+func (cp *Checkpoint) QueueFailure(id string, cause error) {
+	store, err := cp.Store()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	data, _ := json.Marshal(failure{Error: cause.Error(), FailedAt: time.Now().UTC()})
+	if err := store.Put(failureBucket, id, data); err != nil {
+		logrus.Error("Unable to queue failure: ", err)
+	}
+}
+
+// ClearFailure removes id from the failure queue once it has succeeded.
+func (cp *Checkpoint) ClearFailure(id string) {
+	store, err := cp.Store()
+	if err != nil {
+		return
+	}
+
+	// Only touch the database for ids that are actually queued
+	if cp.failures == nil {
+		cp.failures = map[string]bool{}
+		store.ForEach(failureBucket, func(key string, _ []byte) error {
+			cp.failures[key] = true
+			return nil
+		})
+	}
+
+	if cp.failures[id] {
+		delete(cp.failures, id)
+		if err := store.Delete(failureBucket, id); err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+// RecordRun adds a finished run to the run history.
+func (cp *Checkpoint) RecordRun(summary RunSummary) error {
+	store, err := cp.Store()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return store.Put(runBucket, summary.StartedAt.Format(time.RFC3339Nano), data)
 }
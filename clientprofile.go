@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ClientProfile is how one kind of request is sent: how long an attempt may
+// take, how often and how patiently it is retried and how many may be in
+// flight at once. Zero Timeout and Concurrency mean no limit.
+type ClientProfile struct {
+	Timeout      time.Duration
+	MaxRetries   int
+	RetryBackoff time.Duration
+	Concurrency  int
+
+	once  sync.Once
+	slots chan struct{}
+}
+
+// clientProfiles are the built in profiles. List calls fail fast and retry
+// often, file downloads get long to finish a large body and are kept to a
+// couple at a time.
+var clientProfiles = map[string]*ClientProfile{
+	"default":  {MaxRetries: 3, RetryBackoff: time.Second},
+	"list":     {Timeout: time.Minute, MaxRetries: 5, RetryBackoff: 500 * time.Millisecond},
+	"download": {Timeout: 15 * time.Minute, MaxRetries: 2, RetryBackoff: 5 * time.Second, Concurrency: 2},
+}
+
+type clientProfileKey struct{}
+
+// WithClientProfile sends a request with the named profile.
+func WithClientProfile(req *http.Request, name string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), clientProfileKey{}, name))
+}
+
+// fileDownload reports if a request fetches a binary file, which
+// --max-bandwidth applies to.
+func fileDownload(req *http.Request) bool {
+	name, _ := req.Context().Value(clientProfileKey{}).(string)
+	return name == "download"
+}
+
+// RequestProfile is the profile a request is sent with, default unless one
+// was named.
+func RequestProfile(req *http.Request) *ClientProfile {
+	if name, ok := req.Context().Value(clientProfileKey{}).(string); ok {
+		if profile, ok := clientProfiles[name]; ok {
+			return profile
+		}
+	}
+	return clientProfiles["default"]
+}
+
+// EndpointProfile names the profile for an endpoint's list calls.
+func EndpointProfile(endpoint *Endpoint) string {
+	if endpoint.Profile != "" {
+		return endpoint.Profile
+	}
+	return "list"
+}
+
+// acquire waits for one of the profile's concurrency slots, the returned
+// func gives it back.
+func (p *ClientProfile) acquire() func() {
+	if p.Concurrency <= 0 {
+		return func() {}
+	}
+
+	p.once.Do(func() { p.slots = make(chan struct{}, p.Concurrency) })
+	p.slots <- struct{}{}
+	return func() { <-p.slots }
+}
+
+// clientProfileOverride is a profile in a --client-profiles file, only the
+// settings given replace those of the profile it overrides.
+type clientProfileOverride struct {
+	Timeout      *time.Duration `yaml:"timeout"`
+	MaxRetries   *int           `yaml:"maxRetries"`
+	RetryBackoff *time.Duration `yaml:"retryBackoff"`
+	Concurrency  *int           `yaml:"concurrency"`
+}
+
+// LoadClientProfiles reads overrides for the built in profiles and for
+// single endpoints, e.g.
+//
+//	download:
+//	  timeout: 30m
+//	  concurrency: 4
+//	downloadCandidates:
+//	  timeout: 2m
+//	  maxRetries: 8
+//
+// An endpoint gets its own profile starting from the one it used before.
+func LoadClientProfiles(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var overrides map[string]clientProfileOverride
+	if err := yaml.UnmarshalStrict(data, &overrides); err != nil {
+		return fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	// Profiles first so endpoints start from the overridden profile
+	sort.Slice(names, func(i, j int) bool {
+		_, iProfile := clientProfiles[names[i]]
+		_, jProfile := clientProfiles[names[j]]
+		if iProfile != jProfile {
+			return iProfile
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		base, ok := clientProfiles[name]
+		endpoint, isEndpoint := registeredEndpoints[name]
+		if isEndpoint {
+			base = clientProfiles[EndpointProfile(&endpoint)]
+		} else if !ok {
+			return fmt.Errorf("%s: %q is neither a client profile nor an endpoint", path, name)
+		}
+
+		profile, err := overrides[name].apply(base)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+		clientProfiles[name] = profile
+
+		if isEndpoint {
+			endpoint.Profile = name
+			registeredEndpoints[name] = endpoint
+		}
+	}
+	return nil
+}
+
+func (o clientProfileOverride) apply(base *ClientProfile) (*ClientProfile, error) {
+	profile := &ClientProfile{
+		Timeout:      base.Timeout,
+		MaxRetries:   base.MaxRetries,
+		RetryBackoff: base.RetryBackoff,
+		Concurrency:  base.Concurrency,
+	}
+
+	if o.Timeout != nil {
+		profile.Timeout = *o.Timeout
+	}
+	if o.MaxRetries != nil {
+		profile.MaxRetries = *o.MaxRetries
+	}
+	if o.RetryBackoff != nil {
+		profile.RetryBackoff = *o.RetryBackoff
+	}
+	if o.Concurrency != nil {
+		profile.Concurrency = *o.Concurrency
+	}
+
+	if profile.Timeout < 0 || profile.MaxRetries < 0 || profile.RetryBackoff < 0 || profile.Concurrency < 0 {
+		return nil, fmt.Errorf("settings can't be negative")
+	}
+	return profile, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "cohort",
+		Description: "Download the candidates who applied to a posting with their interviews and feedback into a directory",
+		Run:         runCohort,
+	})
+}
+
+func runCohort(args []string) error {
+	flags := NewCommandFlags("cohort")
+	posting := flags.String("posting", "", "Id of the posting whose applicants to export")
+	dir := flags.String("dir", "", "Directory to write candidates.json, interviews.json and feedback.json to")
+	retries := flags.Int("retries", 2, "How many times to retry an endpoint that fails")
+	retryDelay := flags.Duration("retry-delay", 30*time.Second, "How long to wait before retrying a failed endpoint")
+	concurrency := flags.Int("concurrency", defaultExportConcurrency, "How many endpoints to export at once once the candidates are downloaded")
+	flags.Parse(args)
+	RequireToken()
+
+	if *posting == "" || filepath.Base(*posting) != *posting {
+		return fmt.Errorf("cohort needs the --posting id to export")
+	}
+
+	if *dir == "" {
+		return fmt.Errorf("cohort needs a --dir to write to")
+	}
+
+	return ExportGraph(CohortGraph(*posting), *dir, "cohort_"+*posting+"_", *retries, *retryDelay, *concurrency)
+}
+
+// CohortGraph downloads the candidates with an application to the posting,
+// which lever's posting_id filter selects, then the interviews and feedback
+// of just those candidates.
+func CohortGraph(posting string) []ExportNode {
+	return []ExportNode{
+		{Endpoint: "downloadCandidates", QueryParams: []QueryParam{{Field: "posting_id", Value: posting}}},
+		{Endpoint: "downloadInterviews", DependsOn: []string{"downloadCandidates"}},
+		{Endpoint: "downloadFeedback", DependsOn: []string{"downloadCandidates"}},
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Command is a subcommand run as `fulcrum <name> [flags]` instead of the
+// default endpoint download.
+type Command struct {
+	Name        string
+	Description string
+	Run         func(args []string) error
+}
+
+var registeredCommands = map[string]Command{}
+
+func RegisterCommand(command Command) {
+	registeredCommands[command.Name] = command
+}
+
+// NewCommandFlags creates the flag set for a subcommand with the token flags
+// every command needs to talk to lever.
+func NewCommandFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&apiToken, "token", "", "Lever api token")
+	flags.StringVar(tokenFile, "token-file", "", "Read the api token from this file instead of --token, rereading it when it changes")
+	flags.StringVar(tokenCommand, "token-command", "", "Shell command printing the api token, e.g. from a secret manager, rerun every --token-refresh")
+	flags.DurationVar(tokenRefresh, "token-refresh", time.Minute, "How often to check --token-file or rerun --token-command for a rotated token")
+	flags.StringVar(&apiVersion, "api-version", "", "Request this lever api version through the Accept header")
+	flags.StringVar(&stateDir, "state-dir", stateDir, "Directory for checkpoints and run locks")
+	return flags
+}
+
+// stopTokenSource stops rereading the token once the command is done.
+var stopTokenSource = func() {}
+
+// RequireToken reads the token from --token-file or --token-command, which
+// goes on while the command runs, and exits when a subcommand was not given
+// an api token.
+func RequireToken() {
+	if tokenSource == nil {
+		stopTokenSource = StartTokenSource(*tokenFile, *tokenCommand, *tokenRefresh)
+	}
+	if apiToken == "" {
+		logrus.Fatal("No api token given use --token= to specify one.")
+	}
+}
+
+// RunCommand runs the subcommand named by the first argument. It returns
+// false when the arguments don't start with a registered command.
+func RunCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	command, ok := registeredCommands[args[0]]
+	if !ok {
+		return false
+	}
+
+	logrus.RegisterExitHandler(func() { CloseOutput() })
+
+	err := command.Run(args[1:])
+	stopTokenSource()
+	if err != nil {
+		LogFatal(err)
+	}
+
+	if err := CloseOutput(); err != nil {
+		logrus.Fatal(err)
+	}
+	CloseStateStores()
+	return true
+}
+
+func printCommands() {
+	names := make([]string, 0, len(registeredCommands))
+	for name := range registeredCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", name, registeredCommands[name].Description)
+	}
+}
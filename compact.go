@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "compact",
+		Description: "Merge a snapshot with the incremental exports after it into a fresh snapshot holding the latest version of each record",
+		Run:         runCompact,
+	})
+}
+
+// Compaction holds the latest version of each record, in the order ids were
+// first seen. A record {"id": ..., "deleted": true} is a tombstone removing
+// the id, and --cdc change events are applied as their after record.
+type Compaction struct {
+	IDField string
+
+	order    []string
+	listed   map[string]bool
+	records  map[string]json.RawMessage
+	versions map[string]int64
+	deleted  map[string]bool
+}
+
+func NewCompaction(idField string) *Compaction {
+	return &Compaction{
+		IDField:  idField,
+		listed:   map[string]bool{},
+		records:  map[string]json.RawMessage{},
+		versions: map[string]int64{},
+		deleted:  map[string]bool{},
+	}
+}
+
+func runCompact(args []string) error {
+	flags := NewCommandFlags("compact")
+	dir := flags.String("dir", "", "Directory holding the snapshot and incremental ndjson exports, searched recursively")
+	out := flags.String("out", "", "Where to write the compacted snapshot, defaults to <dir>/snapshot.json")
+	base := flags.String("base", "", "Snapshot to start from, defaults to the --out file when it exists")
+	idField := flags.String("id-field", "id", "Field identifying a record")
+	removeMerged := flags.Bool("remove-merged", false, "Delete the incremental files once they are in the snapshot")
+	flags.Parse(args)
+
+	if *dir == "" {
+		return fmt.Errorf("compact needs a --dir of exports")
+	}
+
+	if *out == "" {
+		*out = filepath.Join(*dir, "snapshot.json")
+	}
+
+	if *base == "" {
+		if _, err := os.Stat(*out); err == nil {
+			*base = *out
+		}
+	}
+
+	incrementals, err := incrementalFiles(*dir, *out, *base)
+	if err != nil {
+		return err
+	}
+
+	compaction := NewCompaction(*idField)
+	files := incrementals
+	if *base != "" {
+		files = append([]string{*base}, incrementals...)
+	}
+
+	for _, path := range files {
+		if err := compaction.Merge(path); err != nil {
+			return err
+		}
+	}
+
+	written, err := compaction.Write(*out)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"files":      len(files),
+		"records":    written,
+		"tombstones": len(compaction.deleted),
+	}).Info("Compacted into ", *out)
+
+	if *removeMerged {
+		for _, path := range incrementals {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// incrementalFiles lists the json exports under dir in path order, which is
+// the order datalake partitions and rotated parts were written in.
+func incrementalFiles(dir string, exclude ...string) ([]string, error) {
+	skip := map[string]bool{}
+	for _, path := range exclude {
+		if path != "" {
+			skip[filepath.Clean(path)] = true
+		}
+	}
+
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := info.Name()
+		if info.IsDir() || skip[filepath.Clean(path)] || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			return nil
+		}
+
+		if filepath.Ext(name) == ".json" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// Merge applies the records of an ndjson file. A record replaces the one
+// held for its id unless the held one has a later updatedAt.
+func (c *Compaction) Merge(path string) error {
+	return ReadRecords(path, func(raw json.RawMessage) error {
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		// Change events written by --cdc carry the record as after
+		if _, ok := record["event"]; ok && record["after"] != nil {
+			raw = record["after"]
+			if err := json.Unmarshal(raw, &record); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+
+		var id string
+		if err := json.Unmarshal(record[c.IDField], &id); err != nil || id == "" {
+			return fmt.Errorf("%s: record has no %s", path, c.IDField)
+		}
+
+		var deleted bool
+		json.Unmarshal(record["deleted"], &deleted)
+		if deleted {
+			delete(c.records, id)
+			delete(c.versions, id)
+			c.deleted[id] = true
+			return nil
+		}
+
+		var updatedAt int64
+		json.Unmarshal(record["updatedAt"], &updatedAt)
+		if _, ok := c.records[id]; ok && updatedAt < c.versions[id] {
+			return nil
+		}
+
+		if !c.listed[id] {
+			c.listed[id] = true
+			c.order = append(c.order, id)
+		}
+		delete(c.deleted, id)
+		c.records[id] = append(json.RawMessage{}, raw...)
+		c.versions[id] = updatedAt
+		return nil
+	})
+}
+
+// Write writes the snapshot to a temporary file first so an interrupted
+// compaction never leaves a partial snapshot behind.
+func (c *Compaction) Write(path string) (int, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	written := 0
+	for _, id := range c.order {
+		record, ok := c.records[id]
+		if !ok {
+			continue
+		}
+
+		w.Write(record)
+		if err := w.WriteByte('\n'); err != nil {
+			f.Close()
+			return 0, err
+		}
+		written++
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return 0, err
+	}
+
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return written, os.Rename(tmp, path)
+}
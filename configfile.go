@@ -0,0 +1,269 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// secretFlags are masked when printing the effective configuration.
+var secretFlags = map[string]bool{
+	"token":         true,
+	"notify-slack":  true,
+	"pagerduty-key": true,
+	"sentry-dsn":    true,
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "validate-config",
+		Description: "Check a --config file and print the effective configuration it makes with the flag defaults",
+		Run:         runValidateConfig,
+	})
+}
+
+// ApplyConfigFile sets the flags named by the keys of a yaml file, e.g.
+//
+//	endpoint: candidates
+//	createdAtStart: 1717200000000
+//	token: ${LEVER_TOKEN}
+//	filter: ['tags contains "university"']
+//
+// Flags given on the command line win over the file and ${VAR} in values is
+// replaced from the environment, so credentials needn't be in the file.
+func ApplyConfigFile(flags *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q, settings are named after the flags", path, name)
+		}
+		if given[name] || name == "config" {
+			continue
+		}
+
+		list, ok := values[name].([]interface{})
+		if !ok {
+			list = []interface{}{values[name]}
+		}
+
+		for _, value := range list {
+			expanded, err := expandEnv(fmt.Sprint(value))
+			if err != nil {
+				return fmt.Errorf("%s: %s: %v", path, name, err)
+			}
+			if err := flags.Set(name, expanded); err != nil {
+				return fmt.Errorf("%s: %s: %v", path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envReference matches ${VAR}, and $$ which stands for a literal $.
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} with the environment variable, failing when it
+// isn't set. $$ is a literal $, as is a $ not followed by {NAME}, so a
+// filter regex or a password can still use one.
+func expandEnv(value string) (string, error) {
+	var missing []string
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+
+		name := ref[2 : len(ref)-1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+func runValidateConfig(args []string) error {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("validate-config needs the config file to check, e.g. fulcrum validate-config fulcrum.yaml")
+	}
+
+	if err := ApplyConfigFile(flag.CommandLine, flags.Arg(0)); err != nil {
+		return err
+	}
+	config := flagConfig()
+
+	problems := ValidateConfig(config)
+	PrintEffectiveConfig(flag.CommandLine)
+
+	for _, problem := range problems {
+		logrus.Error(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problem(s)", flags.Arg(0), len(problems))
+	}
+	return nil
+}
+
+// ValidateConfig lists what would stop a run with this configuration before
+// it reaches lever.
+func ValidateConfig(config *Config) []string {
+	problems := []string{}
+
+	if config.Profile != "" {
+		if config.Profile != legalHoldProfile {
+			problems = append(problems, fmt.Sprintf("profile %q is not known, the only profile is %s", config.Profile, legalHoldProfile))
+		}
+		if config.Candidate == "" {
+			problems = append(problems, "--profile needs a --candidate to export")
+		}
+	} else if config.Endpoint == "" {
+		problems = append(problems, "no endpoint set")
+	} else if _, ok := registeredEndpoints[config.Endpoint]; !ok {
+		problems = append(problems, fmt.Sprintf("endpoint %q is not registered", config.Endpoint))
+	}
+
+	for name, value := range map[string]string{"createdAtStart": config.CreatedAtStart, "archivedAtStart": config.ArchivedAtStart} {
+		if value == "" {
+			continue
+		}
+		if ms, err := strconv.ParseInt(value, 10, 64); err != nil || ms <= 0 {
+			problems = append(problems, fmt.Sprintf("%s %q is not an epoch millisecond timestamp", name, value))
+		}
+	}
+
+	window := *config
+	if err := ResolveTimeWindow(&window, time.Now()); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	// A --token-command is only run by the export, it may reach out to a
+	// secret manager
+	if config.TokenFile != "" {
+		if _, err := os.Stat(config.TokenFile); err != nil {
+			problems = append(problems, fmt.Sprintf("token file: %v", err))
+		}
+	} else if config.TokenCommand == "" && (config.LeverToken == "" || config.LeverToken == "REQUIRED") {
+		problems = append(problems, "no api token set")
+	}
+
+	if config.NotifySlack != "" {
+		if u, err := url.Parse(config.NotifySlack); err != nil || u.Scheme != "https" || u.Host == "" {
+			problems = append(problems, "notify-slack is not an https webhook url")
+		}
+	}
+
+	if len(config.NotifyEmail) > 0 && config.SMTPUser != "" && config.SMTPPassword == "" {
+		problems = append(problems, "notify-email with smtp-user needs FULCRUM_SMTP_PASSWORD set")
+	}
+
+	if config.SentryDSN != "" {
+		if _, err := NewSentryNotifier(config.SentryDSN); err != nil {
+			problems = append(problems, "sentry-dsn: "+err.Error())
+		}
+	}
+
+	if config.SLA > 0 && config.PagerDutyKey == "" {
+		problems = append(problems, "sla needs a pagerduty-key to alert with")
+	}
+
+	if _, err := logrus.ParseLevel(config.LogLevel); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if config.Shard != "" {
+		if _, err := ParseShard(config.Shard); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if config.MaxAge != "" {
+		if _, err := ParseAge(config.MaxAge); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if config.Session != "" {
+		if _, err := ParseSession(config.Session); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if config.ClientProfiles != "" {
+		if err := LoadClientProfiles(config.ClientProfiles); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	for _, expr := range config.Filters {
+		if _, err := ParseFilter(expr); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// PrintEffectiveConfig writes every flag with the value a run would use as
+// yaml, masking credentials.
+func PrintEffectiveConfig(flags *flag.FlagSet) {
+	effective := yaml.MapSlice{}
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+
+		var value interface{} = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if _, isDuration := getter.Get().(time.Duration); !isDuration {
+				value = getter.Get()
+			}
+		}
+		if secretFlags[f.Name] && f.Value.String() != "" && f.Value.String() != "REQUIRED" {
+			value = "********"
+		}
+		effective = append(effective, yaml.MapItem{Key: f.Name, Value: value})
+	})
+
+	out, err := yaml.Marshal(effective)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	fmt.Print(string(out))
+}
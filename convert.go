@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Converter reshapes fulcrum exports into another system's import format.
+type Converter struct {
+	Name        string
+	Description string
+	Convert     func(options ConvertOptions, report *MappingReport) error
+}
+
+// ConvertOptions points a converter at the newline delimited json exports
+// produced by the download endpoints.
+type ConvertOptions struct {
+	Candidates   string
+	Applications string
+	Feedback     string
+	Offers       string
+	Mapping      string
+	OutDir       string
+}
+
+// MappingReport records how many rows were converted and which lever fields
+// carried data the target format has no place for.
+type MappingReport struct {
+	Target    string                    `json:"target"`
+	Converted map[string]int            `json:"converted"`
+	Unmapped  map[string]map[string]int `json:"unmapped"`
+}
+
+var registeredConverters = map[string]Converter{}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "convert",
+		Description: "Convert exported records into another ATS or HRIS import format",
+		Run:         runConvert,
+	})
+}
+
+func runConvert(args []string) error {
+	flags := NewCommandFlags("convert")
+	to := flags.String("to", "", "Format to convert to: "+strings.Join(converterNames(), ", "))
+	options := ConvertOptions{}
+	flags.StringVar(&options.Candidates, "candidates", "", "Candidates export to convert")
+	flags.StringVar(&options.Applications, "applications", "", "Applications export to convert")
+	flags.StringVar(&options.Feedback, "feedback", "", "Feedback export to convert")
+	flags.StringVar(&options.Offers, "offers", "", "Offers export to convert")
+	flags.StringVar(&options.Mapping, "mapping", "", "Mapping file for converters that need one")
+	flags.StringVar(&options.OutDir, "out", ".", "Directory to write converted files to")
+	AddCSVFlags(flags)
+	flags.Parse(args)
+
+	converter, ok := registeredConverters[*to]
+	if !ok {
+		return fmt.Errorf("unknown --to %q, expected one of %s", *to, strings.Join(converterNames(), ", "))
+	}
+
+	if options.Candidates == "" && options.Applications == "" && options.Feedback == "" && options.Offers == "" {
+		return errors.New("convert needs at least one of --candidates, --applications, --feedback or --offers")
+	}
+
+	if err := os.MkdirAll(options.OutDir, 0755); err != nil {
+		return err
+	}
+
+	report := &MappingReport{Target: converter.Name, Converted: map[string]int{}, Unmapped: map[string]map[string]int{}}
+	if err := converter.Convert(options, report); err != nil {
+		return err
+	}
+
+	reportPath := filepath.Join(options.OutDir, "mapping_report.json")
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(reportPath, data, 0644); err != nil {
+		return err
+	}
+
+	for resource, fields := range report.Unmapped {
+		for field, count := range fields {
+			logrus.Warnf("%s.%s had data in %d record(s) that %s has no field for", resource, field, count, converter.Name)
+		}
+	}
+	logrus.Infof("Converted %v, mapping report written to %s", report.Converted, reportPath)
+	return nil
+}
+
+func converterNames() []string {
+	names := make([]string, 0, len(registeredConverters))
+	for name := range registeredConverters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Track counts a converted record and any of its fields with a value that is
+// not in mapped.
+func (report *MappingReport) Track(resource string, record json.RawMessage, mapped map[string]bool) {
+	report.Converted[resource]++
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(record, &fields); err != nil {
+		return
+	}
+
+	for field, value := range fields {
+		if mapped[field] || isEmpty(value) {
+			continue
+		}
+
+		if report.Unmapped[resource] == nil {
+			report.Unmapped[resource] = map[string]int{}
+		}
+		report.Unmapped[resource][field]++
+	}
+}
+
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		for _, nested := range v {
+			if !isEmpty(nested) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// CSVFile is a csv output file with a header row.
+type CSVFile struct {
+	file   *os.File
+	writer *CSVWriter
+}
+
+func CreateCSV(path string, header []string) (*CSVFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &CSVFile{file: f, writer: csvDialect.NewWriter(f)}
+	return out, out.Write(header)
+}
+
+func (out *CSVFile) Write(row []string) error {
+	return out.writer.Write(row)
+}
+
+func (out *CSVFile) Close() error {
+	out.writer.Flush()
+	if err := out.writer.Error(); err != nil {
+		out.file.Close()
+		return err
+	}
+	return out.file.Close()
+}
+
+// EpochTime converts lever's epoch milliseconds, where 0 means unset.
+func EpochTime(ms int) (time.Time, bool) {
+	if ms == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC(), true
+}
+
+// FormatEpoch formats lever's epoch milliseconds, returning an empty string
+// when unset.
+func FormatEpoch(ms int, layout string) string {
+	t, ok := EpochTime(ms)
+	if !ok {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+// SplitName splits a full name into first and last name on the first space.
+func SplitName(name string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(name), " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
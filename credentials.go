@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// TokenSource reads the api token from a file, e.g. one a secret manager
+// agent keeps up to date, or from the output of a command, and picks up a
+// rotated token while the run goes on so a watch or a long export needn't
+// restart.
+type TokenSource struct {
+	Path    string
+	Command string
+
+	mu      sync.RWMutex
+	token   string
+	modTime time.Time
+}
+
+// tokenSource is nil unless --token-file or --token-command is given, the
+// token is then apiToken.
+var tokenSource *TokenSource
+
+// StartTokenSource reads the token from --token-file or --token-command,
+// when one is given, and keeps rereading it until stop is called.
+func StartTokenSource(path, command string, refresh time.Duration) (stop func()) {
+	if path == "" && command == "" {
+		return func() {}
+	}
+
+	if path != "" && command != "" {
+		logrus.Fatal("--token-file and --token-command can't be combined.")
+	}
+	if apiToken != "" && apiToken != "REQUIRED" {
+		logrus.Fatal("--token can't be combined with --token-file or --token-command.")
+	}
+
+	tokenSource = &TokenSource{Path: path, Command: command}
+	if _, err := tokenSource.Reload(); err != nil {
+		logrus.Fatal(err)
+	}
+	apiToken = tokenSource.Token()
+	return tokenSource.Watch(refresh)
+}
+
+// Token is the api token to send with a request.
+func (s *TokenSource) Token() string {
+	if s == nil {
+		return apiToken
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.token
+}
+
+// Reload reads the token again, reporting whether it changed. A file is
+// only read again once its modification time changes. A failed or empty
+// read keeps the current token.
+func (s *TokenSource) Reload() (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+
+	var data []byte
+	var modTime time.Time
+	if s.Path != "" {
+		info, err := os.Stat(s.Path)
+		if err != nil {
+			return false, err
+		}
+
+		s.mu.RLock()
+		unchanged := info.ModTime().Equal(s.modTime)
+		s.mu.RUnlock()
+		if unchanged {
+			return false, nil
+		}
+
+		modTime = info.ModTime()
+		if data, err = ioutil.ReadFile(s.Path); err != nil {
+			return false, err
+		}
+	} else {
+		var stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", s.Command)
+		cmd.Stderr = &stderr
+
+		var err error
+		if data, err = cmd.Output(); err != nil {
+			return false, fmt.Errorf("--token-command failed: %v %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return false, fmt.Errorf("no api token in %s", s.describe())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modTime = modTime
+	if token == s.token {
+		return false, nil
+	}
+	s.token = token
+	return true, nil
+}
+
+// Watch reloads the token every interval until stopped, logging when it
+// rotates.
+func (s *TokenSource) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.reloadAndLog()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Rotated reloads the token after lever rejected it, reporting whether a
+// new one was found to retry with.
+func (s *TokenSource) Rotated() bool {
+	return s.reloadAndLog()
+}
+
+func (s *TokenSource) reloadAndLog() bool {
+	changed, err := s.Reload()
+	if err != nil {
+		logrus.WithField("source", s.describe()).Warn("Unable to reload the api token, keeping the current one: ", err)
+	} else if changed {
+		logrus.WithField("source", s.describe()).Info("Api token rotated")
+	}
+	return changed
+}
+
+func (s *TokenSource) describe() string {
+	if s.Path != "" {
+		return s.Path
+	}
+	return "--token-command"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVDialect controls how csv input is read and csv output is written, for
+// tooling that expects something other than comma separated, minimally
+// quoted, LF terminated files.
+type CSVDialect struct {
+	Comma    rune
+	QuoteAll bool
+	UseCRLF  bool
+}
+
+var csvDialect = CSVDialect{Comma: ','}
+
+// AddCSVFlags registers the dialect flags on a flag set.
+func AddCSVFlags(flags *flag.FlagSet) {
+	flags.Var(delimiterFlag{&csvDialect.Comma}, "csv-delimiter", "CSV delimiter: comma, tab, semicolon, pipe or a single character")
+	flags.Var(quoteFlag{&csvDialect.QuoteAll}, "csv-quote", "CSV quoting: minimal or all")
+	flags.Var(lineEndingFlag{&csvDialect.UseCRLF}, "csv-line-ending", "CSV line endings: lf or crlf")
+}
+
+func (d CSVDialect) NewReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = d.Comma
+	return reader
+}
+
+func (d CSVDialect) NewWriter(w io.Writer) *CSVWriter {
+	writer := csv.NewWriter(w)
+	writer.Comma = d.Comma
+	writer.UseCRLF = d.UseCRLF
+	return &CSVWriter{dialect: d, csv: writer, buf: bufio.NewWriter(w)}
+}
+
+// CSVWriter writes rows in a dialect. encoding/csv only quotes fields when
+// needed so quoting every field is done here.
+type CSVWriter struct {
+	dialect CSVDialect
+	csv     *csv.Writer
+	buf     *bufio.Writer
+	err     error
+}
+
+func (w *CSVWriter) Write(row []string) error {
+	if !w.dialect.QuoteAll {
+		return w.csv.Write(row)
+	}
+
+	for i, field := range row {
+		if i > 0 {
+			w.buf.WriteRune(w.dialect.Comma)
+		}
+		w.buf.WriteString(`"` + strings.Replace(field, `"`, `""`, -1) + `"`)
+	}
+
+	if w.dialect.UseCRLF {
+		_, w.err = w.buf.WriteString("\r\n")
+	} else {
+		w.err = w.buf.WriteByte('\n')
+	}
+	return w.err
+}
+
+func (w *CSVWriter) Flush() {
+	w.csv.Flush()
+	if err := w.buf.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *CSVWriter) Error() error {
+	if err := w.csv.Error(); err != nil {
+		return err
+	}
+	return w.err
+}
+
+type delimiterFlag struct{ comma *rune }
+
+func (f delimiterFlag) String() string {
+	if f.comma == nil {
+		return ""
+	}
+	return string(*f.comma)
+}
+
+func (f delimiterFlag) Set(value string) error {
+	switch strings.ToLower(value) {
+	case "comma":
+		*f.comma = ','
+	case "tab", `\t`:
+		*f.comma = '\t'
+	case "semicolon":
+		*f.comma = ';'
+	case "pipe":
+		*f.comma = '|'
+	default:
+		r, size := utf8.DecodeRuneInString(value)
+		if size != len(value) || r == '"' || r == '\r' || r == '\n' {
+			return fmt.Errorf("csv delimiter must be a single character other than a quote or newline")
+		}
+		*f.comma = r
+	}
+	return nil
+}
+
+type quoteFlag struct{ all *bool }
+
+func (f quoteFlag) String() string {
+	if f.all != nil && *f.all {
+		return "all"
+	}
+	return "minimal"
+}
+
+func (f quoteFlag) Set(value string) error {
+	switch value {
+	case "minimal":
+		*f.all = false
+	case "all":
+		*f.all = true
+	default:
+		return fmt.Errorf("csv quoting must be minimal or all")
+	}
+	return nil
+}
+
+type lineEndingFlag struct{ crlf *bool }
+
+func (f lineEndingFlag) String() string {
+	if f.crlf != nil && *f.crlf {
+		return "crlf"
+	}
+	return "lf"
+}
+
+func (f lineEndingFlag) Set(value string) error {
+	switch strings.ToLower(value) {
+	case "lf":
+		*f.crlf = false
+	case "crlf":
+		*f.crlf = true
+	default:
+		return fmt.Errorf("csv line ending must be lf or crlf")
+	}
+	return nil
+}
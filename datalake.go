@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DataLakePartition lays output out the way Hive and Spark expect, as
+// <root>/resource=<type>/ingest_date=<date>/part-NNNNN.json with a _SUCCESS
+// marker once the run completes. fulcrum has no parquet writer so parts are
+// written in the configured json --format rather than as part-*.parquet.
+type DataLakePartition struct {
+	Dir string
+
+	existing int
+}
+
+// NewDataLakePartition creates the partition directory for today's ingest.
+func NewDataLakePartition(root, resource string, ingestDate time.Time) (*DataLakePartition, error) {
+	dir := filepath.Join(root, "resource="+resource, "ingest_date="+ingestDate.Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DataLakePartition{Dir: dir}, nil
+}
+
+// Start readies the partition for this run once its run lock is held. Parts
+// left by an earlier run of the same day are kept, and numbering continues
+// after them, only when resume is set because that run is being resumed from
+// its checkpoint. Otherwise they are removed so the partition never holds
+// two runs' records.
+func (p *DataLakePartition) Start(resume bool) error {
+	// The partition is incomplete until this run finishes
+	if err := os.Remove(filepath.Join(p.Dir, "_SUCCESS")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	existing, err := filepath.Glob(filepath.Join(p.Dir, "part-*"))
+	if err != nil {
+		return err
+	}
+
+	if resume {
+		p.existing = len(existing)
+		return nil
+	}
+
+	for _, path := range existing {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	p.existing = 0
+	return nil
+}
+
+// Sink returns a rotating sink writing numbered part files into the partition.
+func (p *DataLakePartition) Sink(maxBytes int64, maxRecords int) *RotatingFile {
+	sink := NewRotatingFile(filepath.Join(p.Dir, "part.json"), maxBytes, maxRecords)
+	sink.PartName = func(part int) string {
+		return filepath.Join(p.Dir, fmt.Sprintf("part-%05d.json", p.existing+part))
+	}
+	return sink
+}
+
+// MarkSuccess writes the _SUCCESS marker Spark uses to tell the partition is
+// complete.
+func (p *DataLakePartition) MarkSuccess() error {
+	return ioutil.WriteFile(filepath.Join(p.Dir, "_SUCCESS"), nil, 0644)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DBTSources is the sources.yml dbt reads to know about the tables fulcrum
+// loads.
+type DBTSources struct {
+	Version int         `yaml:"version"`
+	Sources []DBTSource `yaml:"sources"`
+}
+
+type DBTSource struct {
+	Name        string     `yaml:"name"`
+	Description string     `yaml:"description,omitempty"`
+	Database    string     `yaml:"database,omitempty"`
+	Schema      string     `yaml:"schema,omitempty"`
+	Loader      string     `yaml:"loader"`
+	Tables      []DBTTable `yaml:"tables"`
+}
+
+type DBTTable struct {
+	Name          string        `yaml:"name"`
+	Description   string        `yaml:"description,omitempty"`
+	LoadedAtField string        `yaml:"loaded_at_field,omitempty"`
+	Freshness     *DBTFreshness `yaml:"freshness"`
+	Columns       []DBTColumn   `yaml:"columns"`
+}
+
+type DBTFreshness struct {
+	WarnAfter  DBTPeriod `yaml:"warn_after"`
+	ErrorAfter DBTPeriod `yaml:"error_after"`
+}
+
+type DBTPeriod struct {
+	Count  int    `yaml:"count"`
+	Period string `yaml:"period"`
+}
+
+type DBTColumn struct {
+	Name        string `yaml:"name"`
+	DataType    string `yaml:"data_type,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "dbt-sources",
+		Description: "Print a dbt sources.yml describing the tables fulcrum loads",
+		Run:         runDBTSources,
+	})
+}
+
+func runDBTSources(args []string) error {
+	flags := NewCommandFlags("dbt-sources")
+	dialectName := flags.String("dialect", "bigquery", "Warehouse the tables are loaded into: bigquery, snowflake or postgres")
+	sourceName := flags.String("source", "lever", "Name of the dbt source")
+	database := flags.String("database", "", "Database or project the tables are loaded into")
+	schema := flags.String("schema", "", "Schema or dataset the tables are loaded into")
+	cadence := flags.Duration("cadence", 24*time.Hour, "How often fulcrum runs, tables warn when a run is missed and error after two")
+	loadedAt := flags.String("loaded-at-field", "", "Column or expression dbt checks freshness against, defaults to createdAt where a table has one")
+	flags.Parse(args)
+
+	dialect, ok := dialects[*dialectName]
+	if !ok {
+		return fmt.Errorf("unknown dialect %q, expected bigquery, snowflake or postgres", *dialectName)
+	}
+
+	if *cadence <= 0 {
+		return fmt.Errorf("--cadence must be positive")
+	}
+
+	names := flags.Args()
+	if len(names) == 0 {
+		for name, endpoint := range registeredEndpoints {
+			if _, ok := recordTypes[endpoint.Type]; ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	source := DBTSource{
+		Name:        *sourceName,
+		Description: "Lever data exported by fulcrum",
+		Database:    *database,
+		Schema:      *schema,
+		Loader:      "fulcrum",
+	}
+
+	for _, name := range names {
+		endpoint, ok := registeredEndpoints[name]
+		if !ok {
+			return fmt.Errorf("unknown endpoint %q", name)
+		}
+
+		record, ok := recordTypes[endpoint.Type]
+		if !ok {
+			return fmt.Errorf("no record type known for endpoint %q", name)
+		}
+
+		table := DBTTable{
+			Name:        ResourceName(record),
+			Description: fmt.Sprintf("%s (fulcrum --endpoint %s)", endpoint.Description, name),
+		}
+
+		for _, column := range Columns(dialect, reflect.TypeOf(record), map[reflect.Type]bool{}) {
+			table.Columns = append(table.Columns, DBTColumn{Name: column.Name, DataType: column.Type, Description: column.Description})
+			if column.Name == "createdAt" && *loadedAt == "" {
+				table.LoadedAtField = dialect.EpochMillis(column.Name)
+			}
+		}
+
+		if *loadedAt != "" {
+			table.LoadedAtField = *loadedAt
+		}
+
+		// Without a loaded at field dbt can't check the table, reference data
+		// such as stages has no timestamps at all.
+		if table.LoadedAtField != "" {
+			table.Freshness = &DBTFreshness{
+				WarnAfter:  freshnessPeriod(2 * *cadence),
+				ErrorAfter: freshnessPeriod(3 * *cadence),
+			}
+		}
+		source.Tables = append(source.Tables, table)
+	}
+
+	out, err := yaml.Marshal(DBTSources{Version: 2, Sources: []DBTSource{source}})
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// freshnessPeriod rounds a duration up to the coarsest whole dbt period.
+func freshnessPeriod(d time.Duration) DBTPeriod {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return DBTPeriod{Count: int(d / (24 * time.Hour)), Period: "day"}
+	case d >= time.Hour:
+		return DBTPeriod{Count: int((d + time.Hour - 1) / time.Hour), Period: "hour"}
+	}
+	return DBTPeriod{Count: int((d + time.Minute - 1) / time.Minute), Period: "minute"}
+}
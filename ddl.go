@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordTypes is the record written for each endpoint type.
+var recordTypes = map[string]interface{}{
+	"users":           User{},
+	"interviews":      Interview{},
+	"feedback":        Feedback{},
+	"candidates":      Candidate{},
+	"archivedReasons": ArchiveReason{},
+	"postings":        Posting{},
+	"anonymize":       ErasureRecord{},
+	"stages":          Stage{},
+	"resumes":         Resume{},
+	"surveys":         Survey{},
+	"referrals":       Referral{},
+	"offers":          Offer{},
+	"applications":    Application{},
+	"files":           CandidateFile{},
+}
+
+// Dialect spells column types and identifiers for one warehouse.
+type Dialect struct {
+	Quote     func(name string) string
+	String    string
+	Int       string
+	Float     string
+	Bool      string
+	Timestamp string
+	// JSON is used for maps and values with no fixed type.
+	JSON string
+	// Object and Array type nested fields, given the already typed fields or
+	// element. Dialects without typed nesting ignore the arguments.
+	Object func(fields []Column) string
+	Array  func(elem string, nested bool) string
+	// Comment adds a column description to the column definition, dialects
+	// returning false write it as a separate statement instead.
+	Comment func(description string) (string, bool)
+	// EpochMillis converts an epoch milliseconds column to a timestamp.
+	EpochMillis func(column string) string
+}
+
+// Column is a table column derived from a json field of a record.
+type Column struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+var dialects = map[string]Dialect{
+	"bigquery": {
+		Quote:     backtickQuote,
+		String:    "STRING",
+		Int:       "INT64",
+		Float:     "FLOAT64",
+		Bool:      "BOOL",
+		Timestamp: "TIMESTAMP",
+		JSON:      "JSON",
+		Object: func(fields []Column) string {
+			defs := []string{}
+			for _, field := range fields {
+				defs = append(defs, "`"+field.Name+"` "+field.Type)
+			}
+			return "STRUCT<" + strings.Join(defs, ", ") + ">"
+		},
+		Array: func(elem string, nested bool) string {
+			// BigQuery has no arrays of arrays
+			if nested {
+				return "JSON"
+			}
+			return "ARRAY<" + elem + ">"
+		},
+		Comment: func(description string) (string, bool) {
+			return fmt.Sprintf("OPTIONS(description=%q)", description), true
+		},
+		EpochMillis: func(column string) string { return "TIMESTAMP_MILLIS(" + backtickQuote(column) + ")" },
+	},
+	"snowflake": {
+		Quote:     doubleQuote,
+		String:    "VARCHAR",
+		Int:       "NUMBER(19,0)",
+		Float:     "FLOAT",
+		Bool:      "BOOLEAN",
+		Timestamp: "TIMESTAMP_TZ",
+		JSON:      "VARIANT",
+		Object:    func([]Column) string { return "OBJECT" },
+		Array:     func(string, bool) string { return "ARRAY" },
+		Comment: func(description string) (string, bool) {
+			return "COMMENT '" + strings.Replace(description, "'", "''", -1) + "'", true
+		},
+		EpochMillis: func(column string) string { return "TO_TIMESTAMP_LTZ(" + doubleQuote(column) + ", 3)" },
+	},
+	"postgres": {
+		Quote:     doubleQuote,
+		String:    "TEXT",
+		Int:       "BIGINT",
+		Float:     "DOUBLE PRECISION",
+		Bool:      "BOOLEAN",
+		Timestamp: "TIMESTAMPTZ",
+		JSON:      "JSONB",
+		Object:    func([]Column) string { return "JSONB" },
+		Array: func(elem string, nested bool) string {
+			switch elem {
+			case "TEXT", "BIGINT", "DOUBLE PRECISION", "BOOLEAN":
+				return elem + "[]"
+			}
+			return "JSONB"
+		},
+		Comment:     func(string) (string, bool) { return "", false },
+		EpochMillis: func(column string) string { return "to_timestamp(" + doubleQuote(column) + " / 1000.0)" },
+	},
+}
+
+// backtickQuote quotes a bigquery identifier, escaping any backtick in it.
+func backtickQuote(name string) string {
+	return "`" + strings.Replace(name, "`", "\\`", -1) + "`"
+}
+
+// doubleQuote quotes a standard sql identifier, doubling any double quote in
+// it.
+func doubleQuote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "ddl",
+		Description: "Print CREATE TABLE statements for an endpoint's records, --dialect bigquery, snowflake or postgres",
+		Run:         runDDL,
+	})
+}
+
+func runDDL(args []string) error {
+	flags := NewCommandFlags("ddl")
+	dialectName := flags.String("dialect", "bigquery", "Warehouse to write DDL for: bigquery, snowflake or postgres")
+	dataset := flags.String("dataset", "", "Dataset or schema to qualify the table name with")
+	flags.Parse(args)
+
+	dialect, ok := dialects[*dialectName]
+	if !ok {
+		return fmt.Errorf("unknown dialect %q, expected bigquery, snowflake or postgres", *dialectName)
+	}
+
+	if flags.NArg() == 0 {
+		names := make([]string, 0, len(registeredEndpoints))
+		for name := range registeredEndpoints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("ddl needs an endpoint, one of %s", strings.Join(names, ", "))
+	}
+
+	for _, name := range flags.Args() {
+		endpoint, ok := registeredEndpoints[name]
+		if !ok {
+			return fmt.Errorf("unknown endpoint %q", name)
+		}
+
+		record, ok := recordTypes[endpoint.Type]
+		if !ok {
+			return fmt.Errorf("no record type known for endpoint %q", name)
+		}
+
+		table := ResourceName(record)
+		if *dataset != "" {
+			table = dialect.Quote(*dataset) + "." + dialect.Quote(table)
+		} else {
+			table = dialect.Quote(table)
+		}
+		fmt.Println(CreateTable(dialect, table, reflect.TypeOf(record)))
+	}
+	return nil
+}
+
+// CreateTable builds the CREATE TABLE statement for a record type. Columns
+// keep lever's json field names, timestamps stay the epoch milliseconds
+// fulcrum writes and nested objects become the dialect's nested types.
+func CreateTable(dialect Dialect, table string, t reflect.Type) string {
+	columns := Columns(dialect, t, map[reflect.Type]bool{})
+
+	defs := []string{}
+	comments := []string{}
+	for _, column := range columns {
+		def := "  " + dialect.Quote(column.Name) + " " + column.Type
+		if column.Description != "" {
+			if inline, ok := dialect.Comment(column.Description); ok {
+				def += " " + inline
+			} else {
+				comments = append(comments, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS '%s';",
+					table, dialect.Quote(column.Name), strings.Replace(column.Description, "'", "''", -1)))
+			}
+		}
+		defs = append(defs, def)
+	}
+
+	statement := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n);", table, strings.Join(defs, ",\n"))
+	for _, comment := range comments {
+		statement += "\n" + comment
+	}
+	return statement + "\n"
+}
+
+// Columns lists the json fields of a struct as columns.
+func Columns(dialect Dialect, t reflect.Type, seen map[reflect.Type]bool) []Column {
+	seen[t] = true
+	defer delete(seen, t)
+
+	columns := []Column{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		column := Column{Name: name, Type: columnType(dialect, field.Type, seen)}
+		if isTimestampField(name) && isInt(field.Type) {
+			column.Description = "epoch milliseconds"
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func columnType(dialect Dialect, t reflect.Type, seen map[reflect.Type]bool) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return dialect.Timestamp
+	case t == rawMessageType:
+		return dialect.JSON
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return dialect.String
+	case reflect.Bool:
+		return dialect.Bool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return dialect.Int
+	case reflect.Float32, reflect.Float64:
+		return dialect.Float
+	case reflect.Slice, reflect.Array:
+		elem := t.Elem()
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		nested := elem.Kind() == reflect.Slice || elem.Kind() == reflect.Array
+		return dialect.Array(columnType(dialect, elem, seen), nested)
+	case reflect.Struct:
+		if seen[t] {
+			return dialect.JSON
+		}
+		return dialect.Object(Columns(dialect, t, seen))
+	}
+	return dialect.JSON
+}
+
+func isInt(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// DuplicateGroup is a set of candidates that are likely the same person.
+// PrimaryID is the oldest, the one the others would be merged into.
+type DuplicateGroup struct {
+	PrimaryID    string   `json:"primaryId"`
+	CandidateIDs []string `json:"candidateIds"`
+	Names        []string `json:"names"`
+	MatchedOn    []string `json:"matchedOn"`
+	Keys         []string `json:"keys"`
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "duplicates",
+		Description: "Report likely duplicate candidates in a candidates export, matching on normalized email or name and phone",
+		Run:         runDuplicates,
+	})
+}
+
+func runDuplicates(args []string) error {
+	flags := NewCommandFlags("duplicates")
+	input := flags.String("candidates", "", "Candidates export to look for duplicates in")
+	flags.Parse(args)
+
+	if *input == "" {
+		return errors.New("duplicates needs a --candidates export")
+	}
+
+	var candidates []Candidate
+	err := ReadRecords(*input, func(record json.RawMessage) error {
+		var candidate Candidate
+		if err := json.Unmarshal(record, &candidate); err != nil {
+			return err
+		}
+		candidates = append(candidates, candidate)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	groups := FindDuplicates(candidates)
+	duplicates := 0
+	for _, group := range groups {
+		Output(group, enc)
+		duplicates += len(group.CandidateIDs) - 1
+	}
+
+	logrus.WithFields(logrus.Fields{"candidates": len(candidates), "groups": len(groups), "duplicates": duplicates}).Info("Found likely duplicate candidates")
+	return nil
+}
+
+// FindDuplicates groups candidates sharing a normalized email, or the same
+// normalized name and phone number. Matches chain, so a shares an email
+// with b and b a phone with c puts all three in one group.
+func FindDuplicates(candidates []Candidate) []DuplicateGroup {
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	type match struct {
+		kind, key string
+	}
+	seen := map[match]int{}
+	matched := map[int][]match{}
+	link := func(i int, m match) {
+		first, ok := seen[m]
+		if !ok {
+			seen[m] = i
+			return
+		}
+
+		matched[i] = append(matched[i], m)
+		matched[first] = append(matched[first], m)
+		parent[find(i)] = find(first)
+	}
+
+	for i, candidate := range candidates {
+		for _, email := range candidate.Emails {
+			if key := NormalizeEmail(email); key != "" {
+				link(i, match{"email", key})
+			}
+		}
+
+		name := NormalizeName(candidate.Name)
+		if name == "" {
+			continue
+		}
+		for _, phone := range candidate.Phones {
+			if key := NormalizePhone(phone.Value); key != "" {
+				link(i, match{"name+phone", name + " " + key})
+			}
+		}
+	}
+
+	members := map[int][]int{}
+	for i := range candidates {
+		members[find(i)] = append(members[find(i)], i)
+	}
+
+	var groups []DuplicateGroup
+	for _, indexes := range members {
+		if len(indexes) < 2 {
+			continue
+		}
+
+		sort.Slice(indexes, func(a, b int) bool {
+			return candidates[indexes[a]].CreatedAt < candidates[indexes[b]].CreatedAt
+		})
+
+		group := DuplicateGroup{PrimaryID: candidates[indexes[0]].ID}
+		kinds, keys := map[string]bool{}, map[string]bool{}
+		for _, i := range indexes {
+			group.CandidateIDs = append(group.CandidateIDs, candidates[i].ID)
+			group.Names = append(group.Names, candidates[i].Name)
+			for _, m := range matched[i] {
+				kinds[m.kind] = true
+				keys[m.key] = true
+			}
+		}
+		group.MatchedOn = sortedKeys(kinds)
+		group.Keys = sortedKeys(keys)
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(a, b int) bool { return groups[a].PrimaryID < groups[b].PrimaryID })
+	return groups
+}
+
+// NormalizeEmail lowercases an email and drops any +tag. Gmail ignores dots
+// in the local part so they are dropped for gmail addresses too.
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return ""
+	}
+
+	local, domain := email[:at], email[at+1:]
+	if plus := strings.Index(local, "+"); plus > 0 {
+		local = local[:plus]
+	}
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		local = strings.Replace(local, ".", "", -1)
+		domain = "gmail.com"
+	}
+	return local + "@" + domain
+}
+
+// NormalizeName lowercases a name keeping only letters and digits, with a
+// single space between words.
+func NormalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// NormalizePhone keeps the last ten digits of a phone number so the same
+// number with and without a country code matches.
+func NormalizePhone(phone string) string {
+	var digits []rune
+	for _, r := range phone {
+		if unicode.IsDigit(r) {
+			digits = append(digits, r)
+		}
+	}
+
+	// Too short to tell people apart
+	if len(digits) < 7 {
+		return ""
+	}
+
+	if len(digits) > 10 {
+		digits = digits[len(digits)-10:]
+	}
+	return string(digits)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// EmailNotifier sends the run summary over SMTP with the summary attached
+// as json. Auth is skipped when no username is given.
+type EmailNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e *EmailNotifier) Notify(summary RunSummary) error {
+	attachment, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("fulcrum %s %s.\n\nRecords: %d\nRequests: %d\nErrors: %d\nDuration: %s\n",
+		summary.Endpoint, summary.Status, summary.Records, summary.Requests, summary.Errors, summary.Duration)
+	if summary.Error != "" {
+		text += fmt.Sprintf("\nError: %s\n", summary.Error)
+	}
+
+	if cp := summary.Checkpoint; cp != nil {
+		text += fmt.Sprintf("Checkpoint: lastId=%s lastRow=%d cursor=%s\n", cp.LastID, cp.LastRow, cp.Cursor)
+	} else {
+		text += "Checkpoint: none\n"
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	part.Write([]byte(text))
+
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"application/json"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {`attachment; filename="fulcrum-summary.json"`},
+	})
+	if err != nil {
+		return err
+	}
+	part.Write([]byte(wrapBase64(attachment)))
+
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: fulcrum %s %s\r\n", summary.Endpoint, summary.Status)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, err := net.SplitHostPort(e.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	return smtp.SendMail(e.Addr, auth, e.From, e.To, msg.Bytes())
+}
+
+// wrapBase64 encodes data in the 76 character lines mail expects.
+func wrapBase64(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var lines []string
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded)
+	return strings.Join(lines, "\r\n")
+}
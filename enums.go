@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Posting states, see --state.
+const (
+	PostingStatePublished = "published"
+	PostingStateInternal  = "internal"
+	PostingStateClosed    = "closed"
+	PostingStateDraft     = "draft"
+	PostingStatePending   = "pending"
+	PostingStateRejected  = "rejected"
+)
+
+// Posting distribution channels, see --distributionChannel.
+const (
+	DistributionChannelPublic   = "public"
+	DistributionChannelInternal = "internal"
+)
+
+// Candidate origins, how the candidate came to be in lever.
+const (
+	OriginAgency     = "agency"
+	OriginApplied    = "applied"
+	OriginInternal   = "internal"
+	OriginReferred   = "referred"
+	OriginSourced    = "sourced"
+	OriginUniversity = "university"
+)
+
+// Archive reason types, whether archiving with the reason counts as a hire.
+const (
+	ArchiveReasonTypeHired    = "hired"
+	ArchiveReasonTypeNonHired = "non-hired"
+)
+
+var (
+	postingStates = map[string]bool{
+		PostingStatePublished: true,
+		PostingStateInternal:  true,
+		PostingStateClosed:    true,
+		PostingStateDraft:     true,
+		PostingStatePending:   true,
+		PostingStateRejected:  true,
+	}
+	distributionChannels = map[string]bool{
+		DistributionChannelPublic:   true,
+		DistributionChannelInternal: true,
+	}
+	candidateOrigins = map[string]bool{
+		OriginAgency:     true,
+		OriginApplied:    true,
+		OriginInternal:   true,
+		OriginReferred:   true,
+		OriginSourced:    true,
+		OriginUniversity: true,
+	}
+	archiveReasonTypes = map[string]bool{
+		ArchiveReasonTypeHired:    true,
+		ArchiveReasonTypeNonHired: true,
+	}
+)
+
+var (
+	unknownEnumsMu sync.Mutex
+	unknownEnums   = map[string]bool{}
+)
+
+// CheckEnums warns about enum-like fields of a decoded record holding values
+// fulcrum doesn't know, which usually means lever added one. Records are
+// still written as they are.
+func CheckEnums(obj interface{}) {
+	switch record := obj.(type) {
+	case Posting:
+		checkEnum("posting state", record.State, postingStates)
+		for _, channel := range record.DistributionChannels {
+			checkEnum("distribution channel", channel, distributionChannels)
+		}
+	case Candidate:
+		checkEnum("candidate origin", record.Origin, candidateOrigins)
+	case ArchiveReason:
+		checkEnum("archive reason type", record.Type, archiveReasonTypes)
+	}
+}
+
+// checkEnum warns once per run about each unknown value of a field, empty
+// values aren't checked.
+func checkEnum(field, value string, known map[string]bool) {
+	if value == "" || known[value] {
+		return
+	}
+
+	unknownEnumsMu.Lock()
+	defer unknownEnumsMu.Unlock()
+	if unknownEnums[field+"\x00"+value] {
+		return
+	}
+	unknownEnums[field+"\x00"+value] = true
+	logrus.WithField("value", value).Warnf("Unknown %s, passing it through as is", field)
+}
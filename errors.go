@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Error classes so callers can decide how to handle a LeverError without
+// switching on status codes.
+const (
+	ErrorClassAuth        = "auth"
+	ErrorClassScope       = "scope"
+	ErrorClassNotFound    = "not_found"
+	ErrorClassRateLimited = "rate_limited"
+	ErrorClassServer      = "server"
+	ErrorClassClient      = "client"
+)
+
+// LeverError is returned for any non 2xx response from lever. Code and
+// Message come from lever's json error body when there is one.
+type LeverError struct {
+	StatusCode int    `json:"-"`
+	URL        string `json:"-"`
+	RequestID  string `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+
+	// request holds the fields attached with WithRequestFields
+	request logrus.Fields
+}
+
+// NewLeverError builds an error from a failed response, falling back to the
+// raw body when it isn't lever's usual json error.
+func NewLeverError(resp *http.Response, body []byte) *LeverError {
+	leverErr := &LeverError{
+		StatusCode: resp.StatusCode,
+		URL:        resp.Request.URL.String(),
+		RequestID:  resp.Header.Get("X-Request-Id"),
+	}
+
+	if fields, ok := resp.Request.Context().Value(requestFieldsKey{}).(logrus.Fields); ok {
+		leverErr.request = fields
+	}
+
+	if err := json.Unmarshal(body, leverErr); err != nil || leverErr.Message == "" {
+		leverErr.Message = strings.TrimSpace(string(body))
+	}
+	return leverErr
+}
+
+func (e *LeverError) Error() string {
+	msg := fmt.Sprintf("lever returned %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	msg += " (" + e.URL
+	if e.RequestID != "" {
+		msg += ", request id " + e.RequestID
+	}
+	msg += ")"
+
+	if guidance := e.Guidance(); guidance != "" {
+		msg += "\n" + guidance
+	}
+	return msg
+}
+
+// Class groups the error by how it should be handled.
+func (e *LeverError) Class() string {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrorClassAuth
+	case e.StatusCode == http.StatusForbidden:
+		return ErrorClassScope
+	case e.StatusCode == http.StatusNotFound:
+		return ErrorClassNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case e.StatusCode >= 500:
+		return ErrorClassServer
+	}
+	return ErrorClassClient
+}
+
+// Temporary is true for errors that may succeed if the request is retried.
+func (e *LeverError) Temporary() bool {
+	class := e.Class()
+	return class == ErrorClassRateLimited || class == ErrorClassServer
+}
+
+// InvalidToken is true when lever rejected the token itself.
+func (e *LeverError) InvalidToken() bool {
+	return e.Class() == ErrorClassAuth
+}
+
+// MissingScope is true when the token is valid but not allowed to use the
+// requested endpoint.
+func (e *LeverError) MissingScope() bool {
+	return e.Class() == ErrorClassScope
+}
+
+// Fields returns the error as structured log fields.
+func (e *LeverError) Fields() logrus.Fields {
+	fields := logrus.Fields{
+		"status":     e.StatusCode,
+		"errorClass": e.Class(),
+		"code":       e.Code,
+		"url":        e.URL,
+		"requestId":  e.RequestID,
+	}
+
+	for k, v := range e.request {
+		fields[k] = v
+	}
+	return fields
+}
+
+// Guidance explains how to fix auth errors.
+func (e *LeverError) Guidance() string {
+	switch {
+	case e.InvalidToken():
+		return "The api token is invalid or has been revoked. Generate a new key under Settings > Integrations and API and pass it with --token."
+	case e.MissingScope():
+		return fmt.Sprintf("The api token is not allowed to access %s. Edit the key under Settings > Integrations and API and enable access to this endpoint, or run `fulcrum check` to list what the key can read.", e.Resource())
+	}
+	return ""
+}
+
+// Resource returns the lever resource the request was for, e.g. candidates
+// or candidates/interviews for a nested resource.
+func (e *LeverError) Resource() string {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return e.URL
+	}
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(u.Path, "/v1"), "/"), "/")
+
+	// Skip the ids in paths like /candidates/<id>/interviews
+	var resource []string
+	for i, segment := range segments {
+		if i%2 == 0 {
+			resource = append(resource, segment)
+		}
+	}
+	return strings.Join(resource, "/")
+}
+
+// LogFatal exits logging err, with structured fields when it came from lever.
+func LogFatal(err error) {
+	if leverErr, ok := err.(*LeverError); ok {
+		logrus.WithFields(leverErr.Fields()).Fatal(leverErr)
+	}
+	logrus.Fatal(err)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ExportNode is an endpoint export-all downloads once every endpoint it
+// depends on has finished.
+type ExportNode struct {
+	Endpoint  string
+	DependsOn []string
+	// QueryParams narrow what the endpoint downloads.
+	QueryParams []QueryParam
+}
+
+// exportGraph downloads reference data first, then candidates, then what
+// hangs off each candidate using the candidate ids collected in memory.
+var exportGraph = []ExportNode{
+	{Endpoint: "downloadStages"},
+	{Endpoint: "downloadUsers"},
+	{Endpoint: "downloadPostings"},
+	{Endpoint: "downloadArchivedReasons"},
+	{Endpoint: "downloadCandidates", DependsOn: []string{"downloadStages", "downloadUsers", "downloadPostings", "downloadArchivedReasons"}},
+	{Endpoint: "downloadApplications", DependsOn: []string{"downloadCandidates"}},
+	{Endpoint: "downloadInterviews", DependsOn: []string{"downloadCandidates"}},
+	{Endpoint: "downloadFeedback", DependsOn: []string{"downloadCandidates"}},
+	{Endpoint: "downloadOffers", DependsOn: []string{"downloadCandidates"}},
+	{Endpoint: "downloadReferrals", DependsOn: []string{"downloadCandidates"}},
+	{Endpoint: "downloadResumes", DependsOn: []string{"downloadCandidates"}},
+	{Endpoint: "downloadSurveys", DependsOn: []string{"downloadCandidates"}},
+}
+
+// collectCandidates makes Output remember the id of every candidate written
+// so per candidate endpoints can use them as their input.
+var (
+	candidatesMu      sync.Mutex
+	collectCandidates = false
+)
+
+// defaultExportConcurrency is how many endpoints an export runs at once
+// unless told otherwise. They share the rate limit so more only helps
+// while some are waiting on slow pages.
+const defaultExportConcurrency = 4
+
+func collectCandidate(id string) {
+	candidatesMu.Lock()
+	defer candidatesMu.Unlock()
+	if collectCandidates {
+		candidateIDs = append(candidateIDs, id)
+	}
+}
+
+// NodeStatus is how a node of export-all went.
+type NodeStatus struct {
+	Endpoint string
+	Status   string
+	Attempts int
+	Records  int
+	Duration time.Duration
+	Error    error
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "export-all",
+		Description: "Download every endpoint into a directory, concurrently where dependencies allow, reusing the candidate list for per candidate endpoints",
+		Run:         runExportAll,
+	})
+}
+
+func runExportAll(args []string) error {
+	flags := NewCommandFlags("export-all")
+	dir := flags.String("dir", "", "Directory to write one <type>.json file per endpoint to")
+	retries := flags.Int("retries", 2, "How many times to retry an endpoint that fails")
+	retryDelay := flags.Duration("retry-delay", 30*time.Second, "How long to wait before retrying a failed endpoint")
+	concurrency := flags.Int("concurrency", defaultExportConcurrency, "How many endpoints to export at once, each still waits for those it depends on")
+	allowSurveys := flags.Bool("allow-surveys", false, "Also download survey responses, which may contain sensitive free text")
+	flags.Parse(args)
+	RequireToken()
+
+	if *dir == "" {
+		return fmt.Errorf("export-all needs a --dir to write to")
+	}
+
+	nodes := exportGraph
+	if !*allowSurveys {
+		nodes = nil
+		for _, node := range exportGraph {
+			if node.Endpoint != "downloadSurveys" {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	return ExportGraph(nodes, *dir, "", *retries, *retryDelay, *concurrency)
+}
+
+// ExportGraph downloads the nodes into dir, running up to concurrency of
+// them at once as soon as the nodes they depend on are done and skipping the
+// dependents of any that fail. Each node has its own file and checkpoint,
+// checkpoints are named with the prefix so differently narrowed exports
+// don't share them. A checkpoint only carries a node's retries, every export
+// starts its files and candidate list over.
+func ExportGraph(nodes []ExportNode, dir, prefix string, retries int, retryDelay time.Duration, concurrency int) error {
+	order, err := ExportOrder(nodes)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	statuses := map[string]*NodeStatus{}
+	done := map[string]chan struct{}{}
+	for _, node := range order {
+		statuses[node.Endpoint] = &NodeStatus{Endpoint: node.Endpoint, Status: "succeeded"}
+		done[node.Endpoint] = make(chan struct{})
+	}
+
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, node := range order {
+		wg.Add(1)
+		go func(node ExportNode) {
+			defer wg.Done()
+			defer close(done[node.Endpoint])
+
+			status := statuses[node.Endpoint]
+			for _, dep := range node.DependsOn {
+				<-done[dep]
+				if statuses[dep].Status != "succeeded" {
+					status.Status = "skipped"
+					status.Error = fmt.Errorf("%s did not succeed", dep)
+				}
+			}
+
+			if status.Status == "skipped" {
+				logrus.WithField("endpoint", node.Endpoint).Warn("Skipping export, ", status.Error)
+				return
+			}
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			runExportNode(node, dir, prefix, retries, retryDelay, status)
+			if status.Error != nil {
+				status.Status = "failed"
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, node := range order {
+		status := statuses[node.Endpoint]
+		if status.Status == "failed" {
+			failed = append(failed, node.Endpoint)
+		}
+		entry := logrus.WithFields(logrus.Fields{
+			"endpoint": status.Endpoint,
+			"status":   status.Status,
+			"attempts": status.Attempts,
+			"records":  status.Records,
+			"duration": status.Duration.Round(time.Second).String(),
+		})
+
+		if status.Error != nil {
+			entry.WithField("error", status.Error).Warn("Export ", status.Status)
+		} else {
+			entry.Info("Export ", status.Status)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("export failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runExportNode downloads one endpoint into dir, retrying from its
+// checkpoint when it fails. A checkpoint left by an earlier export is
+// dropped, the file it was made for is about to be rewritten and the
+// candidate list it went through is gone.
+func runExportNode(node ExportNode, dir, prefix string, retries int, retryDelay time.Duration, status *NodeStatus) {
+	endpoint := registeredEndpoints[node.Endpoint]
+	endpoint.QueryParams = append(endpoint.QueryParams, node.QueryParams...)
+	start := time.Now()
+	defer func() { status.Duration = time.Since(start) }()
+
+	perCandidate := strings.Contains(endpoint.SprintfPath, "%s")
+	if perCandidate && len(candidateIDs) == 0 {
+		logrus.WithField("endpoint", node.Endpoint).Info("No candidates to export for")
+		return
+	}
+
+	state := NewCheckpoint(shard.Namespace(prefix + endpoint.Type))
+	lock, err := AcquireRunLock(node.Endpoint, state, false)
+	if err != nil {
+		status.Error = err
+		return
+	}
+	defer lock.Release()
+	state.Remove()
+
+	files := NewRotatingFile(filepath.Join(dir, endpoint.Type+".json"), 0, 0)
+	unroute := Route(endpoint.Type, files)
+
+	if endpoint.Type == "candidates" && !perCandidate {
+		candidatesMu.Lock()
+		candidateIDs = nil
+		collectCandidates = true
+		candidatesMu.Unlock()
+
+		defer func() {
+			candidatesMu.Lock()
+			collectCandidates = false
+			candidatesMu.Unlock()
+		}()
+	}
+
+	for status.Attempts = 1; ; status.Attempts++ {
+		logrus.WithFields(logrus.Fields{"endpoint": node.Endpoint, "attempt": status.Attempts}).Info("Exporting")
+		status.Error = endpoint.Handler(endpoint, "", state)
+		if status.Error == nil || status.Attempts > retries {
+			break
+		}
+
+		logrus.WithFields(logrus.Fields{"endpoint": node.Endpoint, "error": status.Error}).Warn("Export failed, retrying in ", retryDelay)
+		time.Sleep(retryDelay)
+	}
+
+	unroute()
+	if err := files.Close(); err != nil && status.Error == nil {
+		status.Error = err
+	}
+	for _, records := range files.PartRecords {
+		status.Records += records
+	}
+
+	// The next export-all has a new candidate list to work through
+	if status.Error == nil {
+		state.Remove()
+	}
+
+	if endpoint.Type == "candidates" && !perCandidate {
+		candidatesMu.Lock()
+		candidateIDs = uniqueIDs(candidateIDs)
+		candidatesMu.Unlock()
+	}
+}
+
+// ExportOrder sorts nodes so each comes after the nodes it depends on,
+// keeping the given order where dependencies allow.
+func ExportOrder(nodes []ExportNode) ([]ExportNode, error) {
+	known := map[string]bool{}
+	for _, node := range nodes {
+		if _, ok := registeredEndpoints[node.Endpoint]; !ok {
+			return nil, fmt.Errorf("unknown endpoint %q", node.Endpoint)
+		}
+		known[node.Endpoint] = true
+	}
+
+	done := map[string]bool{}
+	var order []ExportNode
+	for len(order) < len(nodes) {
+		progress := false
+		for _, node := range nodes {
+			if done[node.Endpoint] {
+				continue
+			}
+
+			ready := true
+			for _, dep := range node.DependsOn {
+				if !known[dep] {
+					return nil, fmt.Errorf("%s depends on %s which is not exported", node.Endpoint, dep)
+				}
+				ready = ready && done[dep]
+			}
+
+			if ready {
+				done[node.Endpoint] = true
+				order = append(order, node)
+				progress = true
+			}
+		}
+
+		if !progress {
+			return nil, fmt.Errorf("export dependencies form a cycle")
+		}
+	}
+	return order, nil
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := map[string]bool{}
+	unique := ids[:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
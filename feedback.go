@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	scoreDigits = regexp.MustCompile(`^\s*(\d+(\.\d+)?)`)
+
+	// scoreSystemValues maps Lever's standard overall rating answers onto
+	// the same 1-4 scale used by the thumbs score field.
+	scoreSystemValues = map[string]float64{
+		"strong no hire": 1,
+		"no hire":        2,
+		"hire":           3,
+		"strong hire":    4,
+	}
+)
+
+// ExtractScore looks through the feedback form fields for Lever's standard
+// rating fields and sets Score. The overall rating (score-system or score)
+// wins over an averaged scorecard.
+func (feedback *Feedback) ExtractScore() {
+	var scorecard *float64
+
+	for _, field := range feedback.Fields {
+		switch field.Type {
+		case "score-system", "score":
+			if score, ok := parseScore(field.Value); ok {
+				feedback.Score = &score
+				return
+			}
+		case "scorecard":
+			if score, ok := averageScorecard(field.Value); ok && scorecard == nil {
+				scorecard = &score
+			}
+		}
+	}
+
+	feedback.Score = scorecard
+}
+
+func parseScore(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		normalized := strings.ToLower(strings.Replace(v, "-", " ", -1))
+		if score, ok := scoreSystemValues[strings.TrimSpace(normalized)]; ok {
+			return score, true
+		}
+
+		// Ratings are sometimes returned as "4 - Strong Hire"
+		if match := scoreDigits.FindStringSubmatch(v); match != nil {
+			score, err := strconv.ParseFloat(match[1], 64)
+			return score, err == nil
+		}
+	}
+	return 0, false
+}
+
+// averageScorecard returns the mean of the scored skills on a scorecard field.
+func averageScorecard(value interface{}) (float64, bool) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return 0, false
+	}
+
+	var skills []struct {
+		Text  string      `json:"text"`
+		Score interface{} `json:"score"`
+	}
+	if err := json.Unmarshal(raw, &skills); err != nil {
+		return 0, false
+	}
+
+	total, count := 0.0, 0
+	for _, skill := range skills {
+		if score, ok := parseScore(skill.Score); ok {
+			total += score
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, false
+	}
+	return total / float64(count), true
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FieldMapping reshapes one resource type's records at output time. Paths
+// may be dotted to reach into nested objects.
+//
+//	candidates:
+//	  rename:
+//	    id: candidate_id
+//	  drop: [followers, archived.archivedReason]
+//	  defaults:
+//	    origin: unknown
+//	  snakeCase: true
+//
+// A "*" entry applies to every resource type without its own entry.
+type FieldMapping struct {
+	Rename    map[string]string      `yaml:"rename"`
+	Drop      []string               `yaml:"drop"`
+	Defaults  map[string]interface{} `yaml:"defaults"`
+	SnakeCase bool                   `yaml:"snakeCase"`
+}
+
+var fieldMappings map[string]FieldMapping
+
+// resourceNames maps the Go type of an output record to its resource type.
+var resourceNames = map[string]string{
+	"User":            "users",
+	"Candidate":       "candidates",
+	"Posting":         "postings",
+	"ArchiveReason":   "archivedReasons",
+	"Stage":           "stages",
+	"Interview":       "interviews",
+	"Feedback":        "feedback",
+	"Resume":          "resumes",
+	"ResumePosition":  "resumePositions",
+	"ResumeSchool":    "resumeSchools",
+	"Survey":          "surveys",
+	"Referral":        "referrals",
+	"Offer":           "offers",
+	"Application":     "applications",
+	"CandidateFile":   "files",
+	"DuplicateGroup":  "duplicates",
+	"FunnelRow":       "funnel",
+	"StageDuration":   "stageDurations",
+	"ReferralOutcome": "referralOutcomes",
+	"ReferrerStats":   "referrers",
+	"OfferFunnelRow":  "offerFunnel",
+	"ErasureRecord":   "erasures",
+}
+
+// ResourceName returns the resource type of an output record.
+func ResourceName(obj interface{}) string {
+	t := reflect.TypeOf(obj)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil {
+		return ""
+	}
+	return resourceNames[t.Name()]
+}
+
+func LoadFieldMappings(path string) (map[string]FieldMapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := map[string]FieldMapping{}
+	if err := yaml.Unmarshal(data, &mappings); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	for resource := range mappings {
+		if resource == "*" {
+			continue
+		}
+
+		known := false
+		for _, name := range resourceNames {
+			known = known || name == resource
+		}
+		if !known {
+			return nil, fmt.Errorf("%s maps unknown resource type %q", path, resource)
+		}
+	}
+	return mappings, nil
+}
+
+// MapFields applies the field mapping for the resource type, returning obj
+// untouched when there is none.
+func MapFields(resource string, obj interface{}) interface{} {
+	mapping, ok := fieldMappings[resource]
+	if !ok {
+		if mapping, ok = fieldMappings["*"]; !ok {
+			return obj
+		}
+	}
+
+	converted, err := ToRecord(obj)
+	if err != nil {
+		return obj
+	}
+
+	record, ok := converted.(map[string]interface{})
+	if !ok {
+		return obj
+	}
+	return mapping.Apply(record)
+}
+
+func (mapping FieldMapping) Apply(record map[string]interface{}) map[string]interface{} {
+	for path, value := range mapping.Defaults {
+		if current, ok := LookupField(record, strings.Split(path, ".")); !ok || current == nil || current == "" {
+			setField(record, strings.Split(path, "."), value)
+		}
+	}
+
+	for _, path := range mapping.Drop {
+		deleteField(record, strings.Split(path, "."))
+	}
+
+	renamed := map[string]bool{}
+	for from, to := range mapping.Rename {
+		path := strings.Split(from, ".")
+		if value, ok := LookupField(record, path); ok {
+			deleteField(record, path)
+			setField(record, strings.Split(to, "."), value)
+			renamed[to] = true
+		}
+	}
+
+	if mapping.SnakeCase {
+		return snakeCaseKeys(record, renamed)
+	}
+	return record
+}
+
+func setField(record map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := record[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			record[key] = next
+		}
+		record = next
+	}
+	record[path[len(path)-1]] = value
+}
+
+func deleteField(record map[string]interface{}, path []string) {
+	parent, ok := LookupField(record, path[:len(path)-1])
+	if object, isObject := parent.(map[string]interface{}); ok && isObject {
+		delete(object, path[len(path)-1])
+	}
+}
+
+// snakeCaseKeys converts top level keys, and keys of nested objects, from
+// lever's camelCase. Keys that were explicitly renamed are left alone.
+func snakeCaseKeys(record map[string]interface{}, keep map[string]bool) map[string]interface{} {
+	converted := make(map[string]interface{}, len(record))
+	for key, value := range record {
+		if nested, ok := value.(map[string]interface{}); ok {
+			value = snakeCaseKeys(nested, nil)
+		}
+
+		if keep[key] {
+			converted[key] = value
+		} else {
+			converted[SnakeCase(key)] = value
+		}
+	}
+	return converted
+}
+
+// SnakeCase converts camelCase to snake_case, e.g. createdAt to created_at.
+func SnakeCase(name string) string {
+	var out []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				out = append(out, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
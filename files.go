@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// CandidateFile is a file attached to a candidate, such as an offer letter
+// or signed document. Path is set once the file has been archived locally.
+type CandidateFile struct {
+	CandidateID string `json:"candidateId,omitempty"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Ext         string `json:"ext"`
+	DownloadURL string `json:"downloadUrl"`
+	UploadedAt  int    `json:"uploadedAt"`
+	Status      string `json:"status"`
+	Size        int64  `json:"size"`
+	Path        string `json:"path,omitempty"`
+}
+
+// mimeExtensions maps the mime types --mime accepts to file extensions,
+// short names like pdf are used as extensions directly.
+var mimeExtensions = map[string]string{
+	"application/pdf":    "pdf",
+	"application/msword": "doc",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": "docx",
+	"application/vnd.oasis.opendocument.text":                                 "odt",
+	"application/rtf": "rtf",
+	"text/plain":      "txt",
+	"image/png":       "png",
+	"image/jpeg":      "jpg",
+}
+
+// FileFilter decides which candidate files are exported.
+type FileFilter struct {
+	Extensions map[string]bool
+	MaxSize    int64
+}
+
+// fileFilter is nil unless --mime or --max-file-size is used, a nil filter
+// allows every file.
+var fileFilter *FileFilter
+
+// filesDir is where --files-dir archives the files themselves.
+var filesDir = ""
+
+// NewFileFilter parses a comma separated list of extensions or mime types.
+func NewFileFilter(types string, maxSize int64) (*FileFilter, error) {
+	filter := &FileFilter{Extensions: map[string]bool{}, MaxSize: maxSize}
+	for _, t := range strings.Split(types, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+
+		if strings.Contains(t, "/") {
+			ext, ok := mimeExtensions[t]
+			if !ok {
+				return nil, fmt.Errorf("unknown mime type %q, use a file extension such as pdf instead", t)
+			}
+			t = ext
+		}
+		filter.Extensions[strings.TrimPrefix(t, ".")] = true
+	}
+
+	// jpg and jpeg are the same thing
+	if filter.Extensions["jpg"] || filter.Extensions["jpeg"] {
+		filter.Extensions["jpg"], filter.Extensions["jpeg"] = true, true
+	}
+	return filter, nil
+}
+
+// Allows reports if the file should be exported. Files without a known size
+// are checked against MaxSize when downloaded.
+func (f *FileFilter) Allows(file CandidateFile) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Extensions) > 0 && !f.Extensions[fileExt(file)] {
+		return false
+	}
+	return f.MaxSize == 0 || file.Size <= f.MaxSize
+}
+
+// fileExt returns the file's extension without the dot, falling back to its
+// name when lever doesn't give one.
+func fileExt(file CandidateFile) string {
+	ext := file.Ext
+	if ext == "" {
+		ext = filepath.Ext(file.Name)
+	}
+	return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+// FilterFiles drops the files the filter doesn't allow.
+func FilterFiles(files []CandidateFile) []CandidateFile {
+	allowed := files[:0]
+	for _, file := range files {
+		if fileFilter.Allows(file) {
+			allowed = append(allowed, file)
+		}
+	}
+	return allowed
+}
+
+// ArchiveFile downloads a file into <filesDir>/<candidateId>/<id>.<ext>
+// and returns the path it was written to. A file already archived by an
+// earlier run is kept without downloading it again, and a download that was
+// interrupted carries on from where it stopped.
+func ArchiveFile(file CandidateFile) (string, error) {
+	url := file.DownloadURL
+	if url == "" {
+		endpoint := Endpoint{SprintfPath: "/candidates/%s/files/%s/download", Arguments: []interface{}{file.CandidateID, file.ID}}
+		url = endpoint.URLString()
+	}
+
+	dir := filepath.Join(filesDir, filepath.Base(file.CandidateID))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(file.ID)
+	if ext := fileExt(file); ext != "" {
+		name += "." + ext
+	}
+	path := filepath.Join(dir, name)
+
+	fields := logrus.Fields{"candidateId": file.CandidateID, "fileId": file.ID}
+	if AlreadyArchived(path, file.Size) {
+		logrus.WithFields(fields).Debug("File already archived")
+		return path, nil
+	}
+
+	var maxSize int64
+	if fileFilter != nil {
+		maxSize = fileFilter.MaxSize
+	}
+
+	if _, err := DownloadFileTo(url, path, maxSize, fields); err == errFileTooLarge {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return path, writeChecksum(path)
+}
+
+// errFileTooLarge stops a download that turns out to be over its size cap,
+// --max-file-size when given.
+var errFileTooLarge = errors.New("file is over the size cap")
+
+// AlreadyArchived reports if path holds a complete copy of a file from an earlier
+// run: its size matches the one lever reports, when it reports one, and its
+// contents match the checksum written next to it.
+func AlreadyArchived(path string, size int64) bool {
+	actual, sum, err := checksumFile(path)
+	if err != nil || (size > 0 && actual != size) {
+		return false
+	}
+
+	recorded, err := ioutil.ReadFile(path + ".sha256")
+	if err != nil {
+		// Files archived before checksums were kept go by size alone
+		return size > 0 && os.IsNotExist(err)
+	}
+
+	fields := strings.Fields(string(recorded))
+	return len(fields) > 0 && fields[0] == sum
+}
+
+// writeChecksum writes the file's sha256 next to it in the format sha256sum
+// -c checks.
+func writeChecksum(path string) error {
+	_, sum, err := checksumFile(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".sha256", []byte(sum+"  "+filepath.Base(path)+"\n"), 0600)
+}
+
+// DownloadFileTo streams a file lever hosts into path through path.part,
+// which is only renamed into place once complete. A part left by an
+// interrupted attempt, in this run or an earlier one, is resumed with a
+// range request rather than downloaded again. A file found to be over
+// maxSize, when it is set, is abandoned with errFileTooLarge.
+func DownloadFileTo(url, path string, maxSize int64, fields logrus.Fields) (int64, error) {
+	part := path + ".part"
+	profile := clientProfiles["download"]
+
+	for attempt := 0; ; attempt++ {
+		if err := runBudget.Allow(); err != nil {
+			return 0, err
+		}
+
+		size, status, err := downloadRange(url, part, maxSize, fields, profile, attempt)
+		if err == nil {
+			return size, os.Rename(part, path)
+		}
+
+		if err == errFileTooLarge {
+			os.Remove(part)
+			return 0, err
+		}
+
+		temporary := status == 0 || (&LeverError{StatusCode: status}).Temporary()
+		if attempt >= profile.MaxRetries || !temporary {
+			return 0, err
+		}
+
+		wait := profile.retryDelay(attempt, nil)
+		if !runBudget.Retry(wait) {
+			return 0, err
+		}
+
+		logrus.WithFields(fields).WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"wait":    wait.String(),
+			"error":   err.Error(),
+		}).Warn("Retrying file download")
+		time.Sleep(wait)
+	}
+}
+
+// downloadRange appends what's missing from part, returning its size once
+// complete and the response status, zero when there was no response.
+func downloadRange(url, part string, maxSize int64, fields logrus.Fields, profile *ClientProfile, attempt int) (int64, int, error) {
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	authorize(req)
+	// Ranges count bytes of the file as stored, not of a compressed body
+	req.Header.Set("Accept-Encoding", "identity")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	release := profile.acquire()
+	defer release()
+	stats.Throttle()
+
+	ctx := req.Context()
+	if profile.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, profile.Timeout)
+		defer cancel()
+	}
+
+	entry := AuditEntry{Event: "request", Method: req.Method, URL: req.URL.String(), Retries: attempt}
+	start := time.Now()
+	defer func() {
+		entry.LatencyMs = int64(time.Since(start) / time.Millisecond)
+		audit.Write(entry)
+	}()
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		entry.Error = err.Error()
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	stats.ObserveResponse(resp)
+	entry.Status = resp.StatusCode
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		logrus.WithFields(fields).WithField("offset", offset).Info("Resuming file download")
+	case resp.StatusCode == http.StatusOK:
+		// No range support, or no part yet, so the whole file follows
+		if err := f.Truncate(0); err != nil {
+			return 0, resp.StatusCode, err
+		}
+		if offset, err = f.Seek(0, io.SeekStart); err != nil {
+			return 0, resp.StatusCode, err
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The part already holds the whole file
+		return offset, resp.StatusCode, nil
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		var err error = NewLeverError(resp, body)
+		if resp.StatusCode == http.StatusPartialContent {
+			// A range other than the one asked for, start over next attempt
+			f.Truncate(0)
+			err = fmt.Errorf("unexpected Content-Range %q resuming at %d", resp.Header.Get("Content-Range"), offset)
+		}
+		entry.Error = err.Error()
+		return 0, resp.StatusCode, err
+	}
+
+	// Files of unknown size are checked against the cap as they arrive
+	var body io.Reader = bandwidth.Reader(resp.Body)
+	if maxSize > 0 {
+		if resp.ContentLength > maxSize-offset {
+			return 0, resp.StatusCode, errFileTooLarge
+		}
+		body = io.LimitReader(body, maxSize-offset+1)
+	}
+
+	n, err := io.Copy(f, body)
+	stats.ObserveBytes(int(n), int(n))
+	if maxSize > 0 && offset+n > maxSize {
+		return 0, resp.StatusCode, errFileTooLarge
+	}
+	entry.Bytes = n
+	if err != nil {
+		// What arrived stays in the part for the next attempt to resume
+		entry.Error = err.Error()
+		return 0, 0, err
+	}
+	return offset + n, resp.StatusCode, f.Close()
+}
+
+// DownloadFile fetches the contents of a file lever hosts, fields describe
+// the file in request logs. Files over maxSize bytes are not read, they fail
+// with errFileTooLarge.
+func DownloadFile(url string, maxSize int64, fields logrus.Fields) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = WithBodyLimit(WithClientProfile(WithRequestFields(req, fields), "download"), maxSize)
+
+	resp, body, err := SendLeverRequest(req)
+	if err == errBodyTooLarge {
+		return nil, errFileTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewLeverError(resp, body)
+	}
+	return body, nil
+}
+
+// ArchiveFiles downloads the files when --files-dir is set, dropping any
+// found to be over the size cap while downloading.
+func ArchiveFiles(files []CandidateFile) ([]CandidateFile, error) {
+	if filesDir == "" {
+		return files, nil
+	}
+
+	archived := files[:0]
+	for _, file := range files {
+		path, err := ArchiveFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if path == "" {
+			logrus.WithFields(logrus.Fields{"candidateId": file.CandidateID, "fileId": file.ID}).Info("Skipping file over --max-file-size")
+			continue
+		}
+
+		file.Path = path
+		archived = append(archived, file)
+	}
+	return archived, nil
+}
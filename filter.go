@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var filterExpr = regexp.MustCompile(`^\s*([\w.]+)\s+(==|!=|>=|<=|>|<|contains|!contains)\s+(.+?)\s*$`)
+
+// Filter is a simple predicate such as `tags contains "university"` checked
+// against each record before it is written out.
+type Filter struct {
+	Field []string
+	Op    string
+	Value interface{}
+}
+
+var filters []Filter
+
+// ParseFilter parses `<field> <op> <value>` where field may be a dotted path
+// into nested objects and value is a quoted string, number or boolean.
+func ParseFilter(expr string) (Filter, error) {
+	match := filterExpr.FindStringSubmatch(expr)
+	if match == nil {
+		return Filter{}, fmt.Errorf("unable to parse filter %q, expected <field> <op> <value>", expr)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(match[3]), &value); err != nil {
+		return Filter{}, fmt.Errorf("filter value %s must be a quoted string, number or boolean", match[3])
+	}
+
+	return Filter{Field: strings.Split(match[1], "."), Op: match[2], Value: value}, nil
+}
+
+// Match reports if the record satisfies the filter. A missing field never
+// matches.
+func (f Filter) Match(record map[string]interface{}) bool {
+	current, ok := LookupField(record, f.Field)
+	if !ok {
+		return false
+	}
+
+	switch f.Op {
+	case "==":
+		return compare(current, f.Value) == 0
+	case "!=":
+		return compare(current, f.Value) != 0
+	case ">":
+		return compare(current, f.Value) > 0
+	case "<":
+		return compare(current, f.Value) < 0
+	case ">=":
+		return compare(current, f.Value) >= 0
+	case "<=":
+		return compare(current, f.Value) <= 0
+	case "contains":
+		return contains(current, f.Value)
+	case "!contains":
+		return !contains(current, f.Value)
+	}
+	return false
+}
+
+// LookupField follows a path of keys into nested json objects.
+func LookupField(record map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = record
+	for _, key := range path {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// MatchesFilters reports if obj passes every --filter.
+func MatchesFilters(obj interface{}) bool {
+	if len(filters) == 0 {
+		return true
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return false
+	}
+
+	for _, filter := range filters {
+		if !filter.Match(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders numbers numerically and everything else by its string form.
+func compare(a, b interface{}) int {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(toString(a), toString(b))
+}
+
+func contains(haystack, needle interface{}) bool {
+	switch h := haystack.(type) {
+	case []interface{}:
+		for _, item := range h {
+			if compare(item, needle) == 0 {
+				return true
+			}
+		}
+		return false
+	case string:
+		return strings.Contains(strings.ToLower(h), strings.ToLower(toString(needle)))
+	}
+	return false
+}
+
+func toString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// stringList collects a flag that may be given more than once.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
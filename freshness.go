@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "freshness",
+		Description: "Exit non-zero when the latest export of an endpoint, going by its --manifest, is older than --max-age",
+		Run:         runFreshness,
+	})
+}
+
+func runFreshness(args []string) error {
+	var manifests stringList
+	flags := flag.NewFlagSet("freshness", flag.ExitOnError)
+	flags.Var(&manifests, "manifest", "Manifest written by an export, or a glob of them, may be repeated")
+	maxAge := flags.Duration("max-age", 26*time.Hour, "How long ago the latest export of each endpoint may have finished")
+	endpoints := flags.String("endpoints", "", "Comma separated endpoints that must have a fresh export, by default those in the manifests")
+	flags.Parse(args)
+
+	if len(manifests) == 0 {
+		return fmt.Errorf("freshness needs at least one --manifest")
+	}
+
+	latest, err := LatestExports(manifests)
+	if err != nil {
+		return err
+	}
+
+	expected := []string{}
+	if *endpoints != "" {
+		for _, name := range strings.Split(*endpoints, ",") {
+			expected = append(expected, strings.TrimSpace(name))
+		}
+	} else {
+		for name := range latest {
+			expected = append(expected, name)
+		}
+	}
+	sort.Strings(expected)
+
+	now := time.Now().UTC()
+	stale := []string{}
+	for _, name := range expected {
+		finished, ok := latest[name]
+		if !ok {
+			stale = append(stale, name)
+			fmt.Printf("%-28s missing\n", name)
+			continue
+		}
+
+		age := now.Sub(finished).Truncate(time.Minute)
+		status := "ok"
+		if age > *maxAge {
+			status = "stale"
+			stale = append(stale, name)
+		}
+		fmt.Printf("%-28s %-6s finished %s, %s ago\n", name, status, finished.Format(time.RFC3339), age)
+	}
+
+	if len(stale) > 0 {
+		return fmt.Errorf("no export within %s for %s", *maxAge, strings.Join(stale, ", "))
+	}
+	return nil
+}
+
+// LatestExports reads the manifests matching the given paths or globs and
+// returns when the latest export of each endpoint finished. A manifest is
+// only written once its export succeeded.
+func LatestExports(patterns []string) (map[string]time.Time, error) {
+	latest := map[string]time.Time{}
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no manifest matches %s", pattern)
+		}
+
+		for _, path := range paths {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			var m Manifest
+			if err := json.Unmarshal(data, &m); err != nil {
+				return nil, fmt.Errorf("reading manifest %s: %v", path, err)
+			}
+			if m.Endpoint == "" || m.FinishedAt.IsZero() {
+				return nil, fmt.Errorf("%s is not a finished export's manifest", path)
+			}
+
+			if m.FinishedAt.After(latest[m.Endpoint]) {
+				latest[m.Endpoint] = m.FinishedAt
+			}
+		}
+	}
+	return latest, nil
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
-	"encoding/csv"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"reflect"
 	"strings"
@@ -19,8 +21,10 @@ import (
 
 var (
 	client              = http.Client{}
-	enc                 = json.NewEncoder(os.Stdout)
+	enc                 = json.NewEncoder(sink)
 	apiToken            = ""
+	extractScores       = false
+	apiVersion          = ""
 	baseURI             = "api.lever.co/v1/"
 	registeredEndpoints = map[string]Endpoint{
 		"downloadUsers": Endpoint{
@@ -55,6 +59,14 @@ var (
 			SprintfPath: "/candidates",
 			Description: "Download all candidates",
 		},
+		"downloadCandidate": Endpoint{
+			Name:        "Download Candidate",
+			Method:      "GET",
+			Type:        "candidates",
+			Handler:     DownloadUsingList,
+			SprintfPath: "/candidates/%s",
+			Description: "Download the candidates listed in the input csv by id",
+		},
 		"downloadArchivedReasons": Endpoint{
 			Name:        "Download Archived Reasons",
 			Method:      "GET",
@@ -71,6 +83,86 @@ var (
 			SprintfPath: "/postings",
 			Description: "Download all job postings",
 		},
+		"anonymizeCandidates": Endpoint{
+			Name:        "Anonymize Candidates",
+			Type:        "anonymize",
+			Method:      "DELETE",
+			Handler:     AnonymizeCandidates,
+			Planner:     planErasure,
+			Columns:     []UploadColumn{{Name: "candidateId", Kind: "id"}},
+			SprintfPath: "/candidates/%s",
+			Description: "Request erasure of the candidates listed in the input csv",
+		},
+		"uploadTags": Endpoint{
+			Name:        "Upload Tags",
+			Type:        "tags",
+			Method:      "POST",
+			Handler:     Upload,
+			Planner:     planTags,
+			Columns:     []UploadColumn{{Name: "candidateId", Kind: "id"}, {Name: "tag", Kind: "text", Repeated: true}},
+			SprintfPath: "/candidates/%s/addTags",
+			Description: "Add the tags listed after each candidate id in the input csv",
+		},
+		"uploadStages": Endpoint{
+			Name:        "Upload Stages",
+			Type:        "stageMoves",
+			Method:      "PUT",
+			Handler:     Upload,
+			Planner:     planStage,
+			Columns:     []UploadColumn{{Name: "candidateId", Kind: "id"}, {Name: "stage", Kind: "stages"}},
+			SprintfPath: "/candidates/%s/stage",
+			Description: "Move each candidate in the input csv to the stage id in the second column",
+		},
+		"uploadArchives": Endpoint{
+			Name:        "Upload Archives",
+			Type:        "archives",
+			Method:      "PUT",
+			Handler:     Upload,
+			Planner:     planArchive,
+			Columns:     []UploadColumn{{Name: "candidateId", Kind: "id"}, {Name: "reason", Kind: "archiveReasons"}},
+			SprintfPath: "/candidates/%s/archived",
+			Description: "Archive each candidate in the input csv with the archive reason id in the second column",
+		},
+		"downloadStages": Endpoint{
+			Name:        "Download Stages",
+			Type:        "stages",
+			Method:      "GET",
+			Handler:     Download,
+			SprintfPath: "/stages",
+			Description: "Download all pipeline stages",
+		},
+		"downloadResumes": Endpoint{
+			Name:        "Download Resumes",
+			Type:        "resumes",
+			Method:      "GET",
+			Handler:     DownloadUsingList,
+			SprintfPath: "/candidates/%s/resumes",
+			Description: "Download resume metadata for a candidate",
+		},
+		"downloadSurveys": Endpoint{
+			Name:        "Download Surveys",
+			Type:        "surveys",
+			Method:      "GET",
+			Handler:     DownloadUsingList,
+			SprintfPath: "/candidates/%s/surveys",
+			Description: "Download candidate survey responses, requires --allow-surveys",
+		},
+		"downloadReferrals": Endpoint{
+			Name:        "Download Referrals",
+			Type:        "referrals",
+			Method:      "GET",
+			Handler:     DownloadUsingList,
+			SprintfPath: "/candidates/%s/referrals",
+			Description: "Download referrals for a candidate",
+		},
+		"downloadOffers": Endpoint{
+			Name:        "Download Offers",
+			Type:        "offers",
+			Method:      "GET",
+			Handler:     DownloadUsingList,
+			SprintfPath: "/candidates/%s/offers",
+			Description: "Download offers for a candidate",
+		},
 		"downloadApplications": Endpoint{
 			Name:        "Download Applications",
 			Type:        "applications",
@@ -79,6 +171,14 @@ var (
 			SprintfPath: "/candidates/%s/applications",
 			Description: "Download all job applications for a candidate",
 		},
+		"downloadFiles": Endpoint{
+			Name:        "Download Files",
+			Type:        "files",
+			Method:      "GET",
+			Handler:     DownloadUsingList,
+			SprintfPath: "/candidates/%s/files",
+			Description: "Download files attached to a candidate, use --mime and --files-dir to archive only some",
+		},
 	}
 )
 
@@ -86,9 +186,13 @@ type Endpoint struct {
 	Name        string
 	Type        string
 	Method      string
-	Offset      string
+	Cursor      string // next token of the last page, sent back to lever as offset
 	HasNext     bool
+	Page        int // pages fetched since the cursor was last reset, for logging
 	Handler     func(endpoint Endpoint, input string, state *Checkpoint) error
+	Planner     Planner // set for endpoints that write to lever
+	Profile     string  // client profile for list calls, list when empty
+	Columns     []UploadColumn
 	Data        *strings.Reader
 	SprintfPath string
 	Description string
@@ -96,20 +200,39 @@ type Endpoint struct {
 	QueryParams []QueryParam
 }
 
+// LeverData is the page envelope returned from a lever list endpoint.
 type LeverData struct {
 	Data    json.RawMessage `json:"data"`
 	Next    string          `json:"next"`
 	HasNext bool            `json:"hasNext"`
 }
 
+// NextCursor returns the token for the page following this one. A page that
+// claims there is more data but carries no token is an error, otherwise we
+// would silently restart from the first page.
+func (page *LeverData) NextCursor() (string, error) {
+	if !page.HasNext {
+		return "", nil
+	}
+
+	if page.Next == "" {
+		return "", errors.New("lever returned hasNext without a next token")
+	}
+	return page.Next, nil
+}
+
 type ArchiveReason struct {
 	ID   string `json:"id"`
 	Text string `json:"text"`
+	Type string `json:"type"`
 }
 
 type Archived struct {
 	ArchivedAt     int    `json:"archivedAt"`
 	ArchivedReason string `json:"archivedReason"`
+	Reason         string `json:"reason"`
+	ReasonText     string `json:"reasonText,omitempty"`
+	ArchivedBy     string `json:"archivedBy"`
 }
 
 type QueryParam struct {
@@ -118,25 +241,82 @@ type QueryParam struct {
 }
 
 type Candidate struct {
-	ID         string   `json:"id"`
-	Name       string   `json:"name"`
-	CreatedAt  int      `json:"createdAt"`
-	ArchivedAt int      `json:"archivedAt"`
-	Archived   Archived `json:"archived"`
-	Tags       []string `json:"tags"`
+	ID           string        `json:"id"`
+	Name         string        `json:"name"`
+	CreatedAt    int           `json:"createdAt"`
+	UpdatedAt    int           `json:"updatedAt"`
+	ArchivedAt   int           `json:"archivedAt"`
+	Archived     Archived      `json:"archived"`
+	Tags         []string      `json:"tags"`
+	Owner        string        `json:"owner"`
+	Followers    []string      `json:"followers"`
+	Emails       []string      `json:"emails"`
+	Phones       []Phone       `json:"phones"`
+	Links        []string      `json:"links"`
+	Applications []string      `json:"applications"`
+	Sources      []string      `json:"sources"`
+	Origin       string        `json:"origin"`
+	Stage        string        `json:"stage"`
+	StageText    string        `json:"stageText,omitempty"`
+	StageChanges []StageChange `json:"stageChanges"`
+}
+
+type StageChange struct {
+	ToStageID    string `json:"toStageId"`
+	ToStageText  string `json:"toStageText,omitempty"`
+	ToStageIndex int    `json:"toStageIndex"`
+	UpdatedAt    int    `json:"updatedAt"`
+	UserID       string `json:"userId"`
+}
+
+type Stage struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+type Phone struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
 }
 
 type Posting struct {
-	ID         string   `json:"id"`
-	Text       string   `json:"text"`
-	CreatedAt  int      `json:"createdAt"`
-	UpdatedAt  int      `json:"updatedAt"`
-	User       string   `json:"user"`
-	Owner      string   `json:"Owner"`
-	Categories Category `json:"categories"`
-	Tags       []string `json:"tags"`
-	State      string   `json:"state"`
-	ReqCode    string   `json:"reqcode"`
+	ID                   string          `json:"id"`
+	Text                 string          `json:"text"`
+	CreatedAt            int             `json:"createdAt"`
+	UpdatedAt            int             `json:"updatedAt"`
+	User                 string          `json:"user"`
+	Owner                string          `json:"owner"`
+	HiringManager        string          `json:"hiringManager"`
+	Followers            []string        `json:"followers"`
+	Categories           Category        `json:"categories"`
+	Tags                 []string        `json:"tags"`
+	State                string          `json:"state"`
+	DistributionChannels []string        `json:"distributionChannels"`
+	ReqCode              string          `json:"reqcode"`
+	RequisitionCodes     []string        `json:"requisitionCodes"`
+	Content              *PostingContent `json:"content,omitempty"`
+	URLs                 PostingURLs     `json:"urls"`
+}
+
+// PostingContent is only returned by Lever when the request includes
+// include=content, see --include-content.
+type PostingContent struct {
+	Description     string        `json:"description"`
+	DescriptionHTML string        `json:"descriptionHtml"`
+	Lists           []PostingList `json:"lists"`
+	Closing         string        `json:"closing"`
+	ClosingHTML     string        `json:"closingHtml"`
+}
+
+type PostingList struct {
+	Text    string `json:"text"`
+	Content string `json:"content"`
+}
+
+type PostingURLs struct {
+	List  string `json:"list"`
+	Show  string `json:"show"`
+	Apply string `json:"apply"`
 }
 
 type Category struct {
@@ -153,12 +333,13 @@ type User struct {
 	ID         string `json:"id"`
 	Name       string `json:"name"`
 	Username   string `json:"username"`
-	Email      string `json:"username"`
+	Email      string `json:"email"`
 	CreatedAt  int    `json:"createdAt"`
 	AccessRole string `json:"accessRole"`
 }
 
 type Feedback struct {
+	CandidateID    string      `json:"candidateId,omitempty"`
 	ID             string      `json:"id"`
 	Type           string      `json:"type"`
 	Text           string      `json:"text"`
@@ -167,8 +348,13 @@ type Feedback struct {
 	BaseTemplateID string      `json:"baseTemplateId"`
 	Interview      string      `json:"interview"`
 	User           string      `json:"user"`
+	UserName       string      `json:"userName,omitempty"`
+	UserEmail      string      `json:"userEmail,omitempty"`
 	CreatedAt      int         `json:"createdAt"`
 	CompletedAt    int         `json:"completedAt"`
+	Score          *float64    `json:"score,omitempty"`
+	// Language is set with --detect-language.
+	Language string `json:"language,omitempty"`
 }
 
 type FormField struct {
@@ -180,20 +366,148 @@ type FormField struct {
 }
 
 type Application struct {
-	ID                   string   `json:"id"`
-	CreatedAt            int      `json:"createdAt"`
-	Type                 string   `json:"type"`
-	Posting              string   `json:"posting"`
-	PostingOwner         string   `json:"postingOwnner"`
-	PostingHiringManager string   `json:"postingHiringManager"`
-	User                 string   `json:"user"`
-	Name                 string   `json:"name"`
-	Email                string   `json:"email"`
-	Company              string   `json:"company"`
-	Archived             Archived `json:"archived"`
+	CandidateID          string              `json:"candidateId,omitempty"`
+	ID                   string              `json:"id"`
+	CreatedAt            int                 `json:"createdAt"`
+	Type                 string              `json:"type"`
+	Posting              string              `json:"posting"`
+	PostingText          string              `json:"postingText,omitempty"`
+	PostingTeam          string              `json:"postingTeam,omitempty"`
+	PostingLocation      string              `json:"postingLocation,omitempty"`
+	PostingOwner         string              `json:"postingOwner"`
+	PostingHiringManager string              `json:"postingHiringManager"`
+	User                 string              `json:"user"`
+	Name                 string              `json:"name"`
+	Email                string              `json:"email"`
+	Company              string              `json:"company"`
+	Archived             Archived            `json:"archived"`
+	CustomQuestions      []CustomQuestion    `json:"customQuestions"`
+	RequisitionForHire   *RequisitionForHire `json:"requisitionForHire"`
+}
+
+// CustomQuestion is a posting form filled out by the candidate when applying.
+type CustomQuestion struct {
+	ID             string      `json:"id"`
+	Type           string      `json:"type"`
+	Text           string      `json:"text"`
+	Instructions   string      `json:"instructions"`
+	Fields         []FormField `json:"fields"`
+	BaseTemplateID string      `json:"baseTemplateId"`
+	User           string      `json:"user"`
+	CreatedAt      int         `json:"createdAt"`
+	CompletedAt    int         `json:"completedAt"`
+}
+
+// RequisitionForHire is set once an application is hired against a requisition.
+type RequisitionForHire struct {
+	ID                  string `json:"id"`
+	RequisitionCode     string `json:"requisitionCode"`
+	HiringManagerOnHire string `json:"hiringManagerOnHire"`
+}
+
+type Resume struct {
+	CandidateID string        `json:"candidateId,omitempty"`
+	ID          string        `json:"id"`
+	CreatedAt   int           `json:"createdAt"`
+	File        ResumeFile    `json:"file"`
+	ParsedData  *ParsedResume `json:"parsedData"`
+	// Text is the plain text of the resume file, set with --extract-text.
+	Text string `json:"text,omitempty"`
+}
+
+// ParsedResume is the employment and education history lever parsed out of
+// the resume file.
+type ParsedResume struct {
+	Positions []Position `json:"positions"`
+	Schools   []School   `json:"schools"`
+}
+
+type Position struct {
+	Org      string      `json:"org"`
+	Title    string      `json:"title"`
+	Summary  string      `json:"summary,omitempty"`
+	Location string      `json:"location,omitempty"`
+	Start    *ResumeDate `json:"start"`
+	End      *ResumeDate `json:"end"`
+}
+
+type School struct {
+	Org     string      `json:"org"`
+	Degree  string      `json:"degree"`
+	Field   string      `json:"field"`
+	Summary string      `json:"summary,omitempty"`
+	Start   *ResumeDate `json:"start,omitempty"`
+	End     *ResumeDate `json:"end,omitempty"`
+}
+
+// ResumeDate is a month in a resume, lever leaves the month out when the
+// resume only gives a year.
+type ResumeDate struct {
+	Year  int `json:"year"`
+	Month int `json:"month,omitempty"`
+}
+
+type ResumeFile struct {
+	Name        string `json:"name"`
+	Ext         string `json:"ext"`
+	DownloadURL string `json:"downloadUrl"`
+	UploadedAt  int    `json:"uploadedAt"`
+}
+
+// Survey is a survey sent to a candidate, such as a candidate experience
+// survey. Responses are free text and may contain sensitive information.
+type Survey struct {
+	CandidateID string      `json:"candidateId,omitempty"`
+	ID          string      `json:"id"`
+	Type        string      `json:"type"`
+	Text        string      `json:"text"`
+	Posting     string      `json:"posting"`
+	Fields      []FormField `json:"fields"`
+	CreatedAt   int         `json:"createdAt"`
+	CompletedAt int         `json:"completedAt"`
+}
+
+// Referral is a referral form filled out by the employee who referred the
+// candidate.
+type Referral struct {
+	CandidateID    string      `json:"candidateId,omitempty"`
+	ID             string      `json:"id"`
+	Type           string      `json:"type"`
+	Text           string      `json:"text"`
+	Instructions   string      `json:"instructions"`
+	Fields         []FormField `json:"fields"`
+	BaseTemplateID string      `json:"baseTemplateId"`
+	User           string      `json:"user"`
+	Referrer       string      `json:"referrer"`
+	ReferrerName   string      `json:"referrerName,omitempty"`
+	ReferrerEmail  string      `json:"referrerEmail,omitempty"`
+	Stage          string      `json:"stage"`
+	CreatedAt      int         `json:"createdAt"`
+	CompletedAt    int         `json:"completedAt"`
+}
+
+type Offer struct {
+	ID          string       `json:"id"`
+	CandidateID string       `json:"candidateId,omitempty"`
+	CreatedAt   int          `json:"createdAt"`
+	Status      string       `json:"status"`
+	Creator     string       `json:"creator"`
+	Posting     string       `json:"posting,omitempty"`
+	Fields      []OfferField `json:"fields"`
+	SentAt      int          `json:"sentAt"`
+	ApprovedAt  int          `json:"approvedAt"`
+}
+
+// OfferField is a value from the offer form, identified by the field's
+// identifier such as salary_amount or anticipated_start_date.
+type OfferField struct {
+	Text       string      `json:"text"`
+	Identifier string      `json:"identifier"`
+	Value      interface{} `json:"value"`
 }
 
 type Interview struct {
+	CandidateID      string   `json:"candidateId,omitempty"`
 	ID               string   `json:"id"`
 	Subject          string   `json:"subject"`
 	Note             string   `json:"note"`
@@ -205,8 +519,22 @@ type Interview struct {
 	FeedbackTemplate string   `json:"feedbackTemplate"`
 	FeedbackForms    []string `json:"feedbackForms"`
 	User             string   `json:"user"`
+	UserName         string   `json:"userName,omitempty"`
+	UserEmail        string   `json:"userEmail,omitempty"`
 	Stage            string   `json:"stage"`
 	CanceledAt       int      `json:"canceledAt"`
+	// Language of the note, set with --detect-language.
+	Language string `json:"language,omitempty"`
+}
+
+// HasQueryParam reports if the endpoint has been given the query param.
+func (endpoint *Endpoint) HasQueryParam(field string) bool {
+	for _, param := range endpoint.QueryParams {
+		if param.Field == field {
+			return true
+		}
+	}
+	return false
 }
 
 func (endpoint *Endpoint) PartialPath() string {
@@ -233,26 +561,143 @@ func (endpoint *Endpoint) URLString() string {
 		u.RawQuery = q.Encode()
 	}
 
-	if endpoint.Offset != "" {
+	if endpoint.Cursor != "" {
 		q := u.Query()
-		q.Set("offset", endpoint.Offset)
+		q.Set("offset", endpoint.Cursor)
 		u.RawQuery = q.Encode()
 	}
 
 	return u.String()
 }
 
-// LeverEndpointResult is the default response object returned
-// from a lever endpoint request.
-type LeverEndpointResult struct {
-	Data    *json.RawMessage `json:"data"`
-	HasNext bool             `json:"hasNext"`
-	Next    string           `json:"next"`
+// OutputRecord is a record as it will be written, along with the resource
+// it is routed by.
+type OutputRecord struct {
+	Resource string
+	Value    interface{}
+
+	// What writing the record changes in the run's state, e.g. the
+	// watermark, is left to Encode so the pipeline's transform stage never
+	// records more than the sink has written. Left out records are only
+	// kept for these.
+	commits []func()
+	leftOut bool
 }
 
 func Output(obj interface{}, encoder *json.Encoder) {
-	if err := encoder.Encode(&obj); err != nil {
-		logrus.Error(err)
+	record, _ := Transform(obj)
+	record.Encode(encoder)
+}
+
+// Transform filters, scans, maps and hashes a record the way the flags ask
+// for, reporting false when it shouldn't be written.
+func Transform(obj interface{}) (OutputRecord, bool) {
+	var record OutputRecord
+	leaveOut := func() (OutputRecord, bool) {
+		record.leftOut = true
+		return record, false
+	}
+
+	if !watermark.Changed(obj) {
+		return leaveOut()
+	}
+	original := obj
+	record.commit(func() { watermark.Observe(original) })
+
+	if !MatchesFilters(obj) || !retention.Keeps(obj) {
+		return leaveOut()
+	}
+
+	CheckEnums(obj)
+	record.commit(func() { manifest.Observe(original) })
+	resource := ResourceName(obj)
+	scanner.Scan(resource, original)
+
+	if candidate, ok := obj.(Candidate); ok {
+		collectCandidate(candidate.ID)
+	}
+
+	// Nulls are decided on lever's field names so run before mapping
+	if emitNulls {
+		obj = NullRecord(obj)
+	}
+
+	// Policies name lever's fields, a rename must not slip a field past one
+	if accessPolicy != nil {
+		if obj = accessPolicy.Apply(resource, obj); obj == nil {
+			return leaveOut()
+		}
+	}
+
+	if fieldMappings != nil {
+		obj = MapFields(resource, obj)
+	}
+
+	if hashRecords {
+		hashed, hash, changed := hashOutput(original, obj)
+		record.commit(func() { manifest.ObserveHash(recordID(original), hash) })
+		if !changed {
+			return leaveOut()
+		}
+		obj = hashed
+	}
+
+	if versions != nil {
+		event, err := versions.Change(resource, original, obj)
+		if err != nil {
+			logrus.Fatal("Unable to compare with the stored version: ", err)
+		}
+
+		if event == nil {
+			return leaveOut()
+		}
+
+		id, version := event.ID, obj
+		record.commit(func() {
+			if err := versions.Stage(id, version); err != nil {
+				logrus.Fatal("Unable to store the record's version: ", err)
+			}
+		})
+		obj = event
+	}
+
+	if sortKeys {
+		obj = SortedRecord(obj)
+	}
+
+	record.Resource = resource
+	record.Value = obj
+	return record, true
+}
+
+func (r *OutputRecord) commit(fn func()) {
+	r.commits = append(r.commits, fn)
+}
+
+// Encode writes the record to the encoder, or the file its resource is
+// routed to, and then commits what it changes in the run's state.
+func (r OutputRecord) Encode(encoder *json.Encoder) {
+	if !r.leftOut {
+		if err := routedEncoder(r.Resource, encoder).Encode(&r.Value); err != nil {
+			logrus.Error(err)
+			return
+		}
+		stats.RecordWritten()
+	}
+
+	for _, commit := range r.commits {
+		commit()
+	}
+}
+
+// SetCandidateID links per candidate records in the slice v back to the
+// candidate they were downloaded for.
+func SetCandidateID(v interface{}, candidateID string) {
+	rv := reflect.ValueOf(v)
+	for i := 0; i < rv.Len(); i++ {
+		if field := rv.Index(i).FieldByName("CandidateID"); field.IsValid() && field.String() == "" {
+			field.SetString(candidateID)
+		}
 	}
 }
 
@@ -268,172 +713,303 @@ func OutputList(v interface{}, encoder *json.Encoder) {
 	}
 }
 
-func ExecuteLeverRequest(endpoint *Endpoint, v interface{}) error {
-	req, err := http.NewRequest(endpoint.Method, endpoint.URLString(), nil)
-	if err != nil {
-		return err
+// SendLeverRequest performs an authenticated, rate limited request against
+// lever and returns the response along with its body. Rate limited and
+// server errors are retried with backoff.
+func SendLeverRequest(req *http.Request) (*http.Response, []byte, error) {
+	profile := RequestProfile(req)
+	for attempt := 0; ; attempt++ {
+		if err := runBudget.Allow(); err != nil {
+			return nil, nil, err
+		}
+
+		resp, body, err := sendLeverRequestOnce(req, attempt)
+
+		// A token rotated since it was last checked is retried with straight away
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && tokenSource.Rotated() {
+			if err := rewindBody(req); err != nil {
+				return resp, body, err
+			}
+			continue
+		}
+
+		if attempt >= profile.MaxRetries || !retryable(req, resp, err) {
+			return resp, body, err
+		}
+
+		if checkErr := checkBeforeRetry(req, resp, body, err); checkErr != nil {
+			return resp, body, checkErr
+		}
+
+		wait := profile.retryDelay(attempt, resp)
+		if !runBudget.Retry(wait) {
+			return resp, body, err
+		}
+
+		fields := RequestFields(req, resp)
+		fields["attempt"] = attempt + 1
+		fields["wait"] = wait.String()
+		if err != nil {
+			fields["error"] = err.Error()
+		} else {
+			for k, v := range NewLeverError(resp, body).Fields() {
+				fields[k] = v
+			}
+		}
+		logrus.WithFields(fields).Warn("Retrying lever request")
+		time.Sleep(wait)
+
+		if err := rewindBody(req); err != nil {
+			return resp, body, err
+		}
 	}
-	req.SetBasicAuth(apiToken, "")
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+// authorize adds the api token to a request for the lever api. Files can be
+// hosted elsewhere, e.g. a download url pointing at storage, and never get it.
+func authorize(req *http.Request) {
+	if req.URL.Host == strings.SplitN(baseURI, "/", 2)[0] {
+		req.SetBasicAuth(tokenSource.Token(), "")
 	}
+}
 
-	if resp.StatusCode != 200 {
-		logrus.Error("Non 200 HTTP status response from ", endpoint.URLString())
-		logrus.Fatal(resp)
+type bodyLimitKey struct{}
+
+// errBodyTooLarge is returned for a response over the request's body limit.
+var errBodyTooLarge = errors.New("response body is over the size limit")
+
+// WithBodyLimit has SendLeverRequest give up on a response body larger than
+// limit bytes rather than read it all into memory.
+func WithBodyLimit(req *http.Request, limit int64) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), bodyLimitKey{}, limit))
+}
+
+func sendLeverRequestOnce(req *http.Request, retries int) (*http.Response, []byte, error) {
+	authorize(req)
+	if apiVersion != "" {
+		req.Header.Set("Accept", AcceptHeader(apiVersion))
 	}
 
+	// Asking for gzip ourselves leaves the body compressed so the bytes on
+	// the wire can be counted
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	// Respect the rate limit
+	stats.Throttle()
+
+	profile := RequestProfile(req)
+	release := profile.acquire()
+	defer release()
+
+	entry := AuditEntry{Event: "request", Method: req.Method, URL: req.URL.String(), Retries: retries}
+	start := time.Now()
+
+	// The timeout covers reading the body, which happens before returning
+	ctx := req.Context()
+	if profile.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, profile.Timeout)
+		defer cancel()
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		return err
+		entry.Error = err.Error()
+		audit.Write(entry)
+		logrus.WithFields(RequestFields(req, nil)).WithField("attempt", retries+1).WithError(err).Debug("Lever request failed")
+		return nil, nil, err
 	}
 
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	stats.ObserveResponse(resp)
+
+	var reader io.Reader = resp.Body
+	if fileDownload(req) {
+		reader = bandwidth.Reader(reader)
 	}
 
-	err = json.Unmarshal(body, &v)
-	if err != nil {
-		return err
+	limit, _ := req.Context().Value(bodyLimitKey{}).(int64)
+	if limit > 0 {
+		reader = io.LimitReader(reader, limit+1)
 	}
 
-	// Track next token for endpoint
-	rv := reflect.ValueOf(v).Elem()
-	endpoint.Offset = rv.FieldByName("Next").String()
-	endpoint.HasNext = rv.FieldByName("HasNext").Bool()
-	return nil
-}
+	var raw []byte
+	if limit > 0 && resp.ContentLength > limit {
+		err = errBodyTooLarge
+	} else if raw, err = ioutil.ReadAll(reader); err == nil && limit > 0 && int64(len(raw)) > limit {
+		err = errBodyTooLarge
+	}
 
-func DownloadUsingList(endpoint Endpoint, input string, state *Checkpoint) error {
-	if input == "" {
-		logrus.Fatal("To download interviews we need a csv file with a list of candidate ids.")
+	body := raw
+	if err == nil && resp.Header.Get("Content-Encoding") == "gzip" {
+		body, err = gunzip(raw, limit)
 	}
+	stats.ObserveBytes(len(raw), len(body))
 
-	f, err := os.Open(input)
+	entry.Status = resp.StatusCode
+	entry.LatencyMs = int64(time.Since(start) / time.Millisecond)
+	entry.Bytes = int64(len(body))
 	if err != nil {
-		logrus.Fatal(err)
+		entry.Error = err.Error()
 	}
+	audit.Write(entry)
 
-	// Setup channel we can write to and rate limit the requests to the
-	// endpoint
-	rate := time.Second / 10
-	throttle := time.Tick(rate)
+	logrus.WithFields(RequestFields(req, resp)).WithFields(logrus.Fields{
+		"attempt":   retries + 1,
+		"latencyMs": entry.LatencyMs,
+		"bytes":     entry.Bytes,
+	}).Debug("Lever request")
 
-	defer f.Close()
+	return resp, body, err
+}
 
-	r := csv.NewReader(f)
-	for {
-		record, err := r.Read()
+// AcceptHeader returns the media type that pins requests to a lever api
+// version.
+func AcceptHeader(version string) string {
+	return "application/vnd.lever." + version + "+json"
+}
 
-		if err == io.EOF {
-			break
-		}
+// gunzip decompresses a response body, which must stay within limit bytes
+// when it is set.
+func gunzip(data []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing response: %v", err)
+	}
+	defer r.Close()
 
-		if err != nil {
-			logrus.Fatal(err)
-		}
+	var reader io.Reader = r
+	if limit > 0 {
+		reader = io.LimitReader(r, limit+1)
+	}
 
-		candidateID := record[0]
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing response: %v", err)
+	}
+	if limit > 0 && int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
 
-		if checkReached := state.ReachedCheckpoint(candidateID); !checkReached {
-			continue
-		}
+// ExecuteLeverRequest fetches the page at the endpoint's current cursor and
+// advances the cursor to the next page.
+func ExecuteLeverRequest(endpoint *Endpoint, page *LeverData) error {
+	req, err := http.NewRequest(endpoint.Method, endpoint.URLString(), nil)
+	if err != nil {
+		return err
+	}
 
-		endpoint.Arguments = []interface{}{candidateID}
+	fields := logrus.Fields{"page": endpoint.Page + 1}
+	if len(endpoint.Arguments) > 0 {
+		fields["candidateId"] = endpoint.Arguments[0]
+	}
+	req = WithClientProfile(WithRequestFields(req, fields), EndpointProfile(endpoint))
 
-		for {
-			var leverData LeverData
+	resp, body, err := SendLeverRequest(req)
+	if resp == nil {
+		return err
+	}
 
-			// Respect the rate limit
-			<-throttle
+	if resp.StatusCode != 200 {
+		return NewLeverError(resp, body)
+	}
 
-			err = ExecuteLeverRequest(&endpoint, &leverData)
-			if err != nil {
-				return err
-			}
+	if err != nil {
+		return err
+	}
 
-			switch endpoint.Type {
-			case "interviews":
-				var interviews []Interview
-				if err := json.Unmarshal(leverData.Data, &interviews); err != nil {
-					logrus.Fatal(err)
-				}
+	err = json.Unmarshal(body, page)
+	if err != nil {
+		return err
+	}
 
-				OutputList(interviews, enc)
-			case "applications":
-				var applications []Application
+	cursor, err := page.NextCursor()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, endpoint.URLString())
+	}
 
-				if err := json.Unmarshal(leverData.Data, &applications); err != nil {
-					logrus.Fatal(err)
-				}
+	endpoint.Cursor = cursor
+	endpoint.HasNext = page.HasNext
+	endpoint.Page++
+	return nil
+}
 
-				OutputList(applications, enc)
-			default:
-				logrus.Fatal("Unknown endpoint type: ", endpoint.Type)
-			}
+func DownloadUsingList(endpoint Endpoint, input string, state *Checkpoint) error {
+	r, f, err := OpenCandidateList(input)
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-			if !endpoint.HasNext {
-				break
-			}
-		}
+	defer f.Close()
 
-		state.UpdateLastID(candidateID)
-		state.CheckPoint()
+	if total, err := CountCandidates(input); err == nil {
+		stats.mu.Lock()
+		stats.UnitsTotal = total - len(unknownCandidates)
+		stats.mu.Unlock()
 	}
-	return nil
-}
-
-func Download(endpoint Endpoint, input string, state *Checkpoint) error {
-	for {
-		var leverData LeverData
 
-		if err := ExecuteLeverRequest(&endpoint, &leverData); err != nil {
-			return err
-		}
+	// The fetch stage works from the checkpoint as it was when the run
+	// started, only the sink stage moves it on
+	saved := checkpointFile{
+		LastID:   state.LastProcessedID(),
+		Cursor:   state.Cursor,
+		CursorID: state.CursorID,
+	}
 
-		switch endpoint.Type {
-		case "users":
-			var users []User
+	fetch := func(out chan<- *pipelinePage, done <-chan struct{}) {
+		fetchCandidatePages(endpoint, r, saved, out, done)
+	}
 
-			if err := json.Unmarshal(leverData.Data, &users); err != nil {
-				logrus.Fatal(err)
+	return runPipeline(endpoint, fetch, func(page *pipelinePage) error {
+		if page.Err != nil {
+			// A candidate list that can't be read fails no candidate in particular
+			if page.CandidateID != "" {
+				state.QueueFailure(page.CandidateID, page.Err)
 			}
+			return page.Err
+		}
 
-			OutputList(users, enc)
-		case "archivedReasons":
-			var reasons []ArchiveReason
-			if err := json.Unmarshal(leverData.Data, &reasons); err != nil {
-				logrus.Fatal(err)
-			}
+		if page.HasNext {
+			state.UpdateCursor(page.CandidateID, page.Cursor)
+			state.CheckPoint()
+			return nil
+		}
 
-			OutputList(reasons, enc)
-		case "postings":
-			var posting []Posting
-			if err := json.Unmarshal(leverData.Data, &posting); err != nil {
-				logrus.Fatal(err)
-			}
+		state.UpdateLastID(page.CandidateID)
+		state.UpdateCursor("", "")
+		state.CheckPoint()
+		state.ClearFailure(page.CandidateID)
+		stats.UnitDone()
+		return nil
+	})
+}
 
-			OutputList(posting, enc)
-		case "candidates":
-			var candidates []Candidate
+func Download(endpoint Endpoint, input string, state *Checkpoint) error {
+	cursor := state.ResumeCursor("")
 
-			if err := json.Unmarshal(leverData.Data, &candidates); err != nil {
-				logrus.Fatal(err)
-			}
+	fetch := func(out chan<- *pipelinePage, done <-chan struct{}) {
+		fetchPages(endpoint, "", cursor, out, done)
+	}
 
-			OutputList(candidates, enc)
-		default:
-			logrus.Fatal("Unknown endpoint type", endpoint.Type)
+	err := runPipeline(endpoint, fetch, func(page *pipelinePage) error {
+		if page.Err != nil {
+			return page.Err
 		}
 
-		if !endpoint.HasNext {
-			break
+		if page.HasNext {
+			state.UpdateCursor("", page.Cursor)
+			state.CheckPoint()
 		}
-
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+
+	// The export finished so the next run should start from the first page
+	state.Remove()
 	return nil
 }
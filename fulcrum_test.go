@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCandidates(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/candidates.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var candidates []Candidate
+	if err := json.Unmarshal(data, &candidates); err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 {
+		t.Fatalf("decoded %d candidates, want 2", len(candidates))
+	}
+
+	shane := candidates[0]
+	if shane.Owner != "df0a4d4e-f7e0-4d8c-8a3c-3a8e5a0a1a3b" {
+		t.Errorf("owner = %q", shane.Owner)
+	}
+	if want := []string{"df0a4d4e-f7e0-4d8c-8a3c-3a8e5a0a1a3b", "c8e4b6d1-31d6-4c6a-9d1f-0a3e7cf4f2b5"}; !reflect.DeepEqual(shane.Followers, want) {
+		t.Errorf("followers = %q, want %q", shane.Followers, want)
+	}
+	if want := []string{"shane@exampleq3.com"}; !reflect.DeepEqual(shane.Emails, want) {
+		t.Errorf("emails = %q, want %q", shane.Emails, want)
+	}
+	if want := []Phone{{Type: "mobile", Value: "(123) 456-7891"}, {Type: "work", Value: "+1 415 555 0100"}}; !reflect.DeepEqual(shane.Phones, want) {
+		t.Errorf("phones = %+v, want %+v", shane.Phones, want)
+	}
+	if want := []string{"https://www.linkedin.com/in/shane-smith", "https://github.com/shanesmith"}; !reflect.DeepEqual(shane.Links, want) {
+		t.Errorf("links = %q, want %q", shane.Links, want)
+	}
+	if want := []string{"cdb63f6f-7d2c-4b16-a2d6-7f5d8a1c2e91"}; !reflect.DeepEqual(shane.Applications, want) {
+		t.Errorf("applications = %q, want %q", shane.Applications, want)
+	}
+	if shane.Archived.ArchivedAt != 1417588008635 {
+		t.Errorf("archived = %+v", shane.Archived)
+	}
+
+	// Lever sends null for a candidate without an owner or archive
+	lee := candidates[1]
+	if lee.Owner != "" || lee.Archived != (Archived{}) {
+		t.Errorf("owner = %q, archived = %+v, want neither", lee.Owner, lee.Archived)
+	}
+	if len(lee.Phones) != 0 || len(lee.Followers) != 0 || len(lee.Links) != 0 || len(lee.Applications) != 0 {
+		t.Errorf("empty lists decoded as %+v", lee)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// FunnelRow is one candidate application with everything needed to build a
+// recruiting funnel without joining the individual exports.
+type FunnelRow struct {
+	CandidateID      string         `json:"candidateId"`
+	CandidateName    string         `json:"candidateName"`
+	ApplicationID    string         `json:"applicationId"`
+	ApplicationType  string         `json:"applicationType"`
+	AppliedAt        int            `json:"appliedAt"`
+	PostingID        string         `json:"postingId"`
+	PostingText      string         `json:"postingText"`
+	PostingTeam      string         `json:"postingTeam"`
+	PostingLocation  string         `json:"postingLocation"`
+	Origin           string         `json:"origin"`
+	Sources          []string       `json:"sources"`
+	CurrentStage     string         `json:"currentStage"`
+	CurrentStageText string         `json:"currentStageText"`
+	StageEnteredAt   map[string]int `json:"stageEnteredAt"`
+	ArchivedAt       int            `json:"archivedAt"`
+	ArchiveReason    string         `json:"archiveReason"`
+	Outcome          string         `json:"outcome"`
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "funnel",
+		Description: "Export one row per candidate application with posting, source, stage history and outcome",
+		Run:         runFunnel,
+	})
+}
+
+func runFunnel(args []string) error {
+	flags := NewCommandFlags("funnel")
+	createdAtStart := flags.String("createdAtStart", "", "Only include candidates created after this epoch millisecond timestamp")
+	flags.Parse(args)
+	RequireToken()
+
+	var err error
+	if resolver, err = NewResolver("stages,postings,archiveReasons"); err != nil {
+		return err
+	}
+
+	candidates := Endpoint{Method: "GET", SprintfPath: "/candidates"}
+	if *createdAtStart != "" {
+		candidates.QueryParams = append(candidates.QueryParams, QueryParam{Field: "created_at_start", Value: *createdAtStart})
+	}
+
+	applications := registeredEndpoints["downloadApplications"]
+
+	rows := 0
+	for {
+		var leverData LeverData
+		if err := ExecuteLeverRequest(&candidates, &leverData); err != nil {
+			return err
+		}
+
+		var page []Candidate
+		if err := json.Unmarshal(leverData.Data, &page); err != nil {
+			return err
+		}
+		resolver.AnnotateCandidates(page)
+
+		for _, candidate := range page {
+			var apps []Application
+			applications.Arguments = []interface{}{candidate.ID}
+			applications.Cursor = ""
+			if err := FetchAllFrom(applications, &apps); err != nil {
+				return fmt.Errorf("fetching applications for %s: %v", candidate.ID, err)
+			}
+			resolver.AnnotateApplications(apps)
+
+			for _, application := range apps {
+				Output(NewFunnelRow(candidate, application), enc)
+				rows++
+			}
+		}
+
+		if !candidates.HasNext {
+			break
+		}
+	}
+
+	logrus.Infof("Exported %d funnel rows", rows)
+	return nil
+}
+
+func NewFunnelRow(candidate Candidate, application Application) FunnelRow {
+	row := FunnelRow{
+		CandidateID:      candidate.ID,
+		CandidateName:    candidate.Name,
+		ApplicationID:    application.ID,
+		ApplicationType:  application.Type,
+		AppliedAt:        application.CreatedAt,
+		PostingID:        application.Posting,
+		PostingText:      application.PostingText,
+		PostingTeam:      application.PostingTeam,
+		PostingLocation:  application.PostingLocation,
+		Origin:           candidate.Origin,
+		Sources:          candidate.Sources,
+		CurrentStage:     candidate.Stage,
+		CurrentStageText: candidate.StageText,
+		StageEnteredAt:   map[string]int{},
+		Outcome:          "active",
+	}
+
+	// Stage changes are keyed by the stage name, keeping the first time the
+	// candidate entered each stage.
+	for _, change := range candidate.StageChanges {
+		key := change.ToStageText
+		if key == "" {
+			key = change.ToStageID
+		}
+
+		if entered, ok := row.StageEnteredAt[key]; !ok || change.UpdatedAt < entered {
+			row.StageEnteredAt[key] = change.UpdatedAt
+		}
+	}
+
+	archived := application.Archived
+	if archived.ArchivedAt == 0 {
+		archived = candidate.Archived
+	}
+
+	row.ArchivedAt = archived.ArchivedAt
+	row.ArchiveReason, row.Outcome = resolver.Outcome(archived)
+	return row
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Lever fields each greenhouse file has a column for. Anything else with data
+// ends up in the mapping report.
+var (
+	greenhouseCandidateFields = map[string]bool{
+		"id": true, "name": true, "emails": true, "phones": true, "links": true,
+		"sources": true, "tags": true, "createdAt": true, "owner": true,
+	}
+	greenhouseApplicationFields = map[string]bool{
+		"id": true, "candidateId": true, "posting": true, "postingText": true, "createdAt": true,
+		"archived": true, "type": true,
+	}
+	greenhouseFeedbackFields = map[string]bool{
+		"id": true, "candidateId": true, "interview": true, "user": true, "userName": true, "userEmail": true,
+		"text": true, "fields": true, "completedAt": true, "score": true,
+	}
+
+	// Greenhouse overall recommendations on the same 1-4 scale as lever's
+	// rating field.
+	greenhouseRecommendations = []string{"definitely_not", "no", "yes", "strong_yes"}
+)
+
+func init() {
+	registeredConverters["greenhouse"] = Converter{
+		Name:        "greenhouse",
+		Description: "Greenhouse Harvest bulk import csv files",
+		Convert:     convertGreenhouse,
+	}
+}
+
+func convertGreenhouse(options ConvertOptions, report *MappingReport) error {
+	if options.Candidates != "" {
+		if err := greenhouseCandidates(options, report); err != nil {
+			return err
+		}
+	}
+
+	if options.Applications != "" {
+		if err := greenhouseApplications(options, report); err != nil {
+			return err
+		}
+	}
+
+	if options.Feedback != "" {
+		if err := greenhouseScorecards(options, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func greenhouseCandidates(options ConvertOptions, report *MappingReport) error {
+	out, err := CreateCSV(filepath.Join(options.OutDir, "greenhouse_candidates.csv"), []string{
+		"External ID", "First Name", "Last Name", "Email", "Additional Emails", "Phone",
+		"Websites", "Source", "Tags", "Created At", "Recruiter",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = ReadRecords(options.Candidates, func(record json.RawMessage) error {
+		var candidate Candidate
+		if err := json.Unmarshal(record, &candidate); err != nil {
+			return err
+		}
+		report.Track("candidates", record, greenhouseCandidateFields)
+
+		first, last := SplitName(candidate.Name)
+		email, additional := "", []string{}
+		if len(candidate.Emails) > 0 {
+			email, additional = candidate.Emails[0], candidate.Emails[1:]
+		}
+
+		phone := ""
+		if len(candidate.Phones) > 0 {
+			phone = candidate.Phones[0].Value
+		}
+
+		source := ""
+		if len(candidate.Sources) > 0 {
+			source = candidate.Sources[0]
+		}
+
+		return out.Write([]string{
+			candidate.ID, first, last, email, strings.Join(additional, ";"), phone,
+			strings.Join(candidate.Links, ";"), source, strings.Join(candidate.Tags, ";"),
+			FormatEpoch(candidate.CreatedAt, time.RFC3339), candidate.Owner,
+		})
+	})
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func greenhouseApplications(options ConvertOptions, report *MappingReport) error {
+	out, err := CreateCSV(filepath.Join(options.OutDir, "greenhouse_applications.csv"), []string{
+		"Candidate External ID", "Application External ID", "Job External ID", "Job Name",
+		"Applied At", "Status", "Rejection Reason", "Rejected At",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = ReadRecords(options.Applications, func(record json.RawMessage) error {
+		var application Application
+		if err := json.Unmarshal(record, &application); err != nil {
+			return err
+		}
+		report.Track("applications", record, greenhouseApplicationFields)
+
+		status, reason := "active", ""
+		if application.Archived.ArchivedAt != 0 {
+			status = "rejected"
+			reason = application.Archived.ReasonText
+			if reason == "" {
+				reason = application.Archived.Reason
+			}
+		}
+		if application.RequisitionForHire != nil {
+			status = "hired"
+		}
+
+		return out.Write([]string{
+			application.CandidateID, application.ID, application.Posting, application.PostingText,
+			FormatEpoch(application.CreatedAt, time.RFC3339), status, reason,
+			FormatEpoch(application.Archived.ArchivedAt, time.RFC3339),
+		})
+	})
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func greenhouseScorecards(options ConvertOptions, report *MappingReport) error {
+	out, err := CreateCSV(filepath.Join(options.OutDir, "greenhouse_scorecards.csv"), []string{
+		"Candidate External ID", "Scorecard External ID", "Interview", "Interviewer",
+		"Submitted At", "Overall Recommendation", "Notes",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = ReadRecords(options.Feedback, func(record json.RawMessage) error {
+		var feedback Feedback
+		if err := json.Unmarshal(record, &feedback); err != nil {
+			return err
+		}
+		report.Track("feedback", record, greenhouseFeedbackFields)
+
+		if feedback.Score == nil {
+			feedback.ExtractScore()
+		}
+
+		recommendation := ""
+		if feedback.Score != nil {
+			index := int(*feedback.Score+0.5) - 1
+			if index >= 0 && index < len(greenhouseRecommendations) {
+				recommendation = greenhouseRecommendations[index]
+			}
+		}
+
+		interviewer := feedback.UserEmail
+		if interviewer == "" {
+			interviewer = feedback.User
+		}
+
+		var notes []string
+		for _, field := range feedback.Fields {
+			if text, ok := field.Value.(string); ok && text != "" && (field.Type == "text" || field.Type == "textarea") {
+				notes = append(notes, field.Text+": "+text)
+			}
+		}
+
+		return out.Write([]string{
+			feedback.CandidateID, feedback.ID, feedback.Text, interviewer,
+			FormatEpoch(feedback.CompletedAt, time.RFC3339), recommendation, strings.Join(notes, "\n"),
+		})
+	})
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
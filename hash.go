@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// hashField is added to each record by --hash.
+const hashField = "_hash"
+
+var (
+	// hashRecords is set with --hash or --only-changed.
+	hashRecords = false
+	// previousHashes are the record hashes of the --prev manifest, records
+	// whose hash is unchanged aren't written.
+	previousHashes map[string]string
+	// unchangedRecords counts the records --only-changed left out.
+	unchangedRecords = 0
+)
+
+// HashRecord returns the record with a _hash field holding the sha256 of its
+// canonical json: object keys sorted, numbers as lever sent them and any
+// earlier _hash left out. The hash is taken after field mapping and access
+// policies so it describes exactly what is written.
+func HashRecord(obj interface{}) (interface{}, string, error) {
+	converted, err := ToRecord(obj)
+	if err != nil {
+		return nil, "", err
+	}
+
+	record, ok := converted.(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("can't hash a %T record", obj)
+	}
+	delete(record, hashField)
+
+	// Marshalling a map sorts its keys at every level
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	record[hashField] = hash
+	return record, hash, nil
+}
+
+// LoadPreviousHashes reads the record hashes from a manifest written by an
+// earlier --hash run.
+func LoadPreviousHashes(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous Manifest
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %v", path, err)
+	}
+
+	if previous.Hashes == nil {
+		return nil, fmt.Errorf("manifest %s has no record hashes, it was written without --hash", path)
+	}
+	return previous.Hashes, nil
+}
+
+// hashOutput hashes a record about to be written, reporting false when
+// --only-changed should leave it out. The hash is for the manifest, which
+// also lists records left out.
+func hashOutput(original, obj interface{}) (interface{}, string, bool) {
+	record, hash, err := HashRecord(obj)
+	if err != nil {
+		logrus.Fatal("Unable to hash record: ", err)
+	}
+
+	id := recordID(original)
+	if id != "" && previousHashes != nil && previousHashes[id] == hash {
+		unchangedRecords++
+		return nil, hash, false
+	}
+	return record, hash, true
+}
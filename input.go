@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// candidateIDs holds the ids given with --ids, used in place of an input csv.
+var candidateIDs []string
+
+// OpenCandidateList returns a csv reader over the candidate ids to process,
+// either the explicit --ids or the input csv file.
+func OpenCandidateList(input string) (*csv.Reader, io.Closer, error) {
+	if len(candidateIDs) > 0 {
+		ids := strings.NewReader(strings.Join(candidateIDs, "\n"))
+		return csv.NewReader(ids), ioutil.NopCloser(ids), nil
+	}
+
+	if input == "" {
+		return nil, nil, errors.New("we need --ids or an --input csv file with a list of candidate ids")
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	return csvDialect.NewReader(f), f, nil
+}
+
+// CountCandidates returns how many candidate ids from OpenCandidateList
+// belong to this process' shard.
+func CountCandidates(input string) (int, error) {
+	r, f, err := OpenCandidateList(input)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		if shard.Contains(record[0]) {
+			count++
+		}
+	}
+}
+
+// ParseIDs splits a comma separated --ids value dropping empty entries.
+func ParseIDs(value string) []string {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ReadRecords calls fn with each record of a json export, newline delimited
+// or, when written with --format json-array, one array of records.
+func ReadRecords(path string, fn func(record json.RawMessage) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	array, err := startsArray(r)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+
+	decoder := json.NewDecoder(r)
+	if array {
+		// The opening [, the records then stream one at a time
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("reading %s as a json array: %v", path, err)
+		}
+	}
+
+	for !array || decoder.More() {
+		var record json.RawMessage
+		if err := decoder.Decode(&record); err == io.EOF && !array {
+			return nil
+		} else if err != nil {
+			if array {
+				return fmt.Errorf("reading %s as a json array: %v", path, err)
+			}
+			return fmt.Errorf("reading %s: %v", path, err)
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("reading %s as a json array: %v", path, err)
+	}
+	return nil
+}
+
+// startsArray reports if the first thing in r other than white space is the
+// [ of a json array, leaving it unread.
+func startsArray(r *bufio.Reader) (bool, error) {
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c == '[', r.UnreadByte()
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fulcrum-input-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		data string
+		want []string
+		err  bool
+	}{
+		{name: "ndjson", data: "{\"id\":\"a\"}\n{\"id\":\"b\"}\n", want: []string{"a", "b"}},
+		{name: "pretty ndjson", data: "{\n  \"id\": \"a\"\n}\n{\n  \"id\": \"b\"\n}\n", want: []string{"a", "b"}},
+		{name: "json array", data: "[{\"id\":\"a\"},{\"id\":\"b\"}]\n", want: []string{"a", "b"}},
+		{name: "pretty json array", data: "\n[\n  {\n    \"id\": \"a\"\n  },\n  {\n    \"id\": \"b\"\n  }\n]\n", want: []string{"a", "b"}},
+		{name: "empty json array", data: "[]\n"},
+		{name: "empty file", data: ""},
+		{name: "truncated json array", data: "[{\"id\":\"a\"},{\"id\":", err: true},
+		{name: "unclosed json array", data: "[{\"id\":\"a\"}", err: true},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, "records.json")
+		if err := ioutil.WriteFile(path, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		err := ReadRecords(path, func(record json.RawMessage) error {
+			var r struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(record, &r); err != nil {
+				return err
+			}
+			ids = append(ids, r.ID)
+			return nil
+		})
+
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: read %q, want an error", test.name, ids)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("%s: read %q, want %q", test.name, ids, test.want)
+		}
+	}
+}
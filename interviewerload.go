@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// InterviewerLoad is how many interviews an interviewer sat in a week,
+// starting on Monday in UTC.
+type InterviewerLoad struct {
+	InterviewerID string
+	Name          string
+	Email         string
+	Week          string
+	Interviews    int
+	Minutes       int
+	Candidates    int
+}
+
+var interviewerLoadHeader = []string{"interviewerId", "interviewerName", "interviewerEmail", "week", "interviews", "hours", "candidates"}
+
+func init() {
+	RegisterAnalysis(Command{
+		Name:        "interviewer-load",
+		Description: "Count interviews per interviewer per week from an interviews export as csv",
+		Run:         runInterviewerLoad,
+	})
+}
+
+func runInterviewerLoad(args []string) error {
+	flags := NewCommandFlags("interviewer-load")
+	input := flags.String("interviews", "", "Interviews export to count")
+	out := flags.String("out", "", "Csv file to write, stdout when not given")
+	includeCanceled := flags.Bool("include-canceled", false, "Also count interviews that were canceled")
+	AddCSVFlags(flags)
+	flags.Parse(args)
+
+	if *input == "" {
+		return errors.New("interviewer-load needs an --interviews export")
+	}
+
+	var interviews []Interview
+	err := ReadRecords(*input, func(record json.RawMessage) error {
+		var interview Interview
+		if err := json.Unmarshal(record, &interview); err != nil {
+			return err
+		}
+
+		if interview.CanceledAt == 0 || *includeCanceled {
+			interviews = append(interviews, interview)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	loads := InterviewerLoads(interviews)
+	if err := writeInterviewerLoads(*out, loads); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"interviews": len(interviews), "rows": len(loads)}).Info("Counted interviewer load")
+	return nil
+}
+
+// InterviewerLoads counts interviews per interviewer and week, ordered by
+// week then busiest interviewer first.
+func InterviewerLoads(interviews []Interview) []InterviewerLoad {
+	type key struct{ interviewer, week string }
+	loads := map[key]*InterviewerLoad{}
+	candidates := map[key]map[string]bool{}
+	for _, interview := range interviews {
+		date, ok := EpochTime(interview.Date)
+		if !ok {
+			continue
+		}
+
+		week := WeekStart(date).Format("2006-01-02")
+		for _, interviewer := range interview.Interviewers {
+			k := key{interviewer.ID, week}
+			load := loads[k]
+			if load == nil {
+				load = &InterviewerLoad{InterviewerID: interviewer.ID, Week: week}
+				loads[k] = load
+				candidates[k] = map[string]bool{}
+			}
+
+			if load.Name == "" {
+				load.Name = interviewer.Name
+			}
+			if load.Email == "" {
+				load.Email = interviewer.Email
+			}
+
+			load.Interviews++
+			load.Minutes += interview.Duration
+			if interview.CandidateID != "" {
+				candidates[k][interview.CandidateID] = true
+			}
+		}
+	}
+
+	result := make([]InterviewerLoad, 0, len(loads))
+	for k, load := range loads {
+		load.Candidates = len(candidates[k])
+		result = append(result, *load)
+	}
+
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Week != result[b].Week {
+			return result[a].Week < result[b].Week
+		}
+		if result[a].Interviews != result[b].Interviews {
+			return result[a].Interviews > result[b].Interviews
+		}
+		return result[a].InterviewerID < result[b].InterviewerID
+	})
+	return result
+}
+
+// WeekStart is midnight UTC on the Monday of t's week.
+func WeekStart(t time.Time) time.Time {
+	t = t.UTC()
+	days := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, time.UTC)
+}
+
+func writeInterviewerLoads(path string, loads []InterviewerLoad) error {
+	rows := [][]string{}
+	for _, load := range loads {
+		rows = append(rows, []string{
+			load.InterviewerID,
+			load.Name,
+			load.Email,
+			load.Week,
+			strconv.Itoa(load.Interviews),
+			strconv.FormatFloat(float64(load.Minutes)/60, 'f', 2, 64),
+			strconv.Itoa(load.Candidates),
+		})
+	}
+
+	if path == "" {
+		w := csvDialect.NewWriter(os.Stdout)
+		w.Write(interviewerLoadHeader)
+		for _, row := range rows {
+			w.Write(row)
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	out, err := CreateCSV(path, interviewerLoadHeader)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if err := out.Write(row); err != nil {
+			out.Close()
+			return fmt.Errorf("writing %s: %v", path, err)
+		}
+	}
+	return out.Close()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// detectLanguages is set with --detect-language.
+var detectLanguages = false
+
+// undetermined is the ISO 639 code for text whose language isn't clear.
+const undetermined = "und"
+
+// scriptLanguages name the language of text mostly written in a script only
+// one common language uses. Kana is checked before Han so Japanese mixing
+// kanji with kana isn't taken for Chinese.
+var scriptLanguages = []struct {
+	script   *unicode.RangeTable
+	language string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// stopwords are frequent words that tell apart languages written in latin
+// script.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "was", "with", "for", "that", "this", "have", "he", "she", "they", "not", "but", "very", "good", "strong", "of", "to", "in"},
+	"es": {"el", "la", "los", "las", "y", "es", "con", "para", "que", "una", "muy", "pero", "no", "del", "por", "su", "buen", "fue", "tiene", "en"},
+	"fr": {"le", "la", "les", "et", "est", "avec", "pour", "que", "une", "très", "mais", "pas", "des", "du", "il", "elle", "bon", "a", "sur", "dans"},
+	"de": {"der", "die", "das", "und", "ist", "mit", "für", "dass", "nicht", "sehr", "aber", "ein", "eine", "er", "sie", "gut", "hat", "war", "zu", "auf"},
+	"pt": {"o", "os", "as", "e", "é", "com", "para", "que", "uma", "muito", "mas", "não", "do", "da", "ele", "ela", "bom", "foi", "tem", "em"},
+	"it": {"il", "lo", "gli", "e", "è", "con", "per", "che", "una", "molto", "ma", "non", "del", "della", "lui", "lei", "buon", "ha", "di", "nel"},
+	"nl": {"de", "het", "en", "is", "met", "voor", "dat", "niet", "zeer", "heel", "maar", "een", "hij", "zij", "goed", "heeft", "was", "van", "op", "ook"},
+}
+
+var stopwordLanguages = func() map[string][]string {
+	languages := map[string][]string{}
+	for language, words := range stopwords {
+		for _, word := range words {
+			languages[word] = append(languages[word], language)
+		}
+	}
+	return languages
+}()
+
+// DetectLanguage guesses the ISO 639-1 code of text, first from its script
+// and then, for latin script, from its most frequent words. Text too short
+// or too mixed to tell is und.
+func DetectLanguage(text string) string {
+	letters := 0
+	scripts := make([]int, len(scriptLanguages))
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+
+		letters++
+		for i, s := range scriptLanguages {
+			if unicode.Is(s.script, r) {
+				scripts[i]++
+				break
+			}
+		}
+	}
+
+	if letters == 0 {
+		return undetermined
+	}
+
+	for i, count := range scripts {
+		// Japanese text is mostly kanji, a little kana is enough to tell
+		kana := scriptLanguages[i].language == "ja" && count*10 >= letters
+		if count*2 > letters || kana {
+			return scriptLanguages[i].language
+		}
+	}
+
+	scores := map[string]int{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		for _, language := range stopwordLanguages[word] {
+			scores[language]++
+		}
+	}
+
+	best, bestScore, runnerUp := undetermined, 0, 0
+	for language, score := range scores {
+		switch {
+		case score > bestScore || (score == bestScore && language < best):
+			runnerUp = bestScore
+			best, bestScore = language, score
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+
+	// A couple of shared short words isn't enough to go on
+	if bestScore < 2 || bestScore == runnerUp {
+		return undetermined
+	}
+	return best
+}
+
+// DetectLanguage sets the language of the free text answers in the form.
+func (feedback *Feedback) DetectLanguage() {
+	var text []string
+	for _, field := range feedback.Fields {
+		if value, ok := field.Value.(string); ok && (field.Type == "text" || field.Type == "textarea") {
+			text = append(text, value)
+		}
+	}
+
+	if len(text) > 0 {
+		feedback.Language = DetectLanguage(strings.Join(text, "\n"))
+	}
+}
+
+// DetectLanguage sets the language of the interview's note.
+func (interview *Interview) DetectLanguage() {
+	if strings.TrimSpace(interview.Note) != "" {
+		interview.Language = DetectLanguage(interview.Note)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// legalHoldProfile is the --profile exporting everything about one
+// candidate, unredacted, with a chain of custody.
+const legalHoldProfile = "legal-hold"
+
+// CustodyManifest is the chain of custody of a legal hold: who exported
+// what, when, and the checksum of every file as it was written.
+type CustodyManifest struct {
+	Profile     string          `json:"profile"`
+	CandidateID string          `json:"candidateId"`
+	Operator    CustodyOperator `json:"operator"`
+	StartedAt   time.Time       `json:"startedAt"`
+	FinishedAt  time.Time       `json:"finishedAt"`
+	Redaction   string          `json:"redaction"`
+	Requests    int             `json:"requests"`
+	Files       []CustodyFile   `json:"files"`
+}
+
+// CustodyOperator is the person and machine that ran the export.
+type CustodyOperator struct {
+	User      string `json:"user"`
+	Host      string `json:"host"`
+	PerformAs string `json:"performAs,omitempty"`
+}
+
+type CustodyFile struct {
+	Path       string    `json:"path"`
+	Bytes      int64     `json:"bytes"`
+	SHA256     string    `json:"sha256"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+// LegalHoldGraph downloads the candidate and then every record hanging off
+// them, surveys and files included. Nothing is downloaded unless lever knows
+// the candidate.
+func LegalHoldGraph() []ExportNode {
+	nodes := []ExportNode{{Endpoint: "downloadCandidate"}}
+	for _, name := range []string{
+		"downloadApplications",
+		"downloadInterviews",
+		"downloadFeedback",
+		"downloadOffers",
+		"downloadReferrals",
+		"downloadResumes",
+		"downloadSurveys",
+		"downloadFiles",
+	} {
+		nodes = append(nodes, ExportNode{Endpoint: name, DependsOn: []string{"downloadCandidate"}})
+	}
+	return nodes
+}
+
+// LegalHold exports everything about the --candidate into a directory,
+// --output or legal-hold-<id> by default, writes its custody.json and
+// packages both. Flags that would redact or leave out records are refused
+// so the hold is complete.
+func LegalHold(config *Config) error {
+	id := config.Candidate
+	if id == "" || filepath.Base(id) != id {
+		return fmt.Errorf("--profile %s needs the --candidate id to export", legalHoldProfile)
+	}
+
+	narrowing := []struct {
+		flag string
+		set  bool
+	}{
+		{"--policy", config.Policy != ""},
+		{"--field-map", config.FieldMap != ""},
+		{"--filter", len(config.Filters) > 0},
+		{"--mime", config.MimeTypes != ""},
+		{"--max-file-size", config.MaxFileSize != ""},
+		{"--shard", config.Shard != ""},
+		{"--only-changed", config.OnlyChanged},
+		{"--max-age", config.MaxAge != ""},
+		{"--since", config.Since != ""},
+		{"--until", config.Until != ""},
+		{"--createdAtStart", config.CreatedAtStart != ""},
+		{"--archivedAtStart", config.ArchivedAtStart != ""},
+		{"--watch", config.Watch > 0},
+	}
+	for _, n := range narrowing {
+		if n.set {
+			return fmt.Errorf("--profile %s exports records unredacted and complete, it can't be combined with %s", legalHoldProfile, n.flag)
+		}
+	}
+
+	dir := config.Output
+	if dir == "" {
+		dir = filepath.Join(runPackage.Dir, "legal-hold-"+id)
+	}
+
+	custody := &CustodyManifest{
+		Profile:     legalHoldProfile,
+		CandidateID: id,
+		Operator:    currentOperator(config.PerformAs),
+		StartedAt:   time.Now().UTC(),
+		Redaction:   "none",
+	}
+
+	candidateIDs = []string{id}
+	filesDir = filepath.Join(dir, "files")
+	if err := ExportGraph(LegalHoldGraph(), dir, "legal_hold_"+id+"_", 2, 30*time.Second, defaultExportConcurrency); err != nil {
+		return err
+	}
+
+	if err := custody.Write(dir); err != nil {
+		return err
+	}
+
+	runPackage.Add(dir)
+	path, err := runPackage.Write("legal-hold-"+id, CurrentRunSummary(nil))
+	if err != nil {
+		return err
+	}
+
+	// The package can't list its own checksum, so it gets a sidecar
+	if err := writeChecksum(path); err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{"candidate": id, "package": path}).Info("Packaged the legal hold")
+	return nil
+}
+
+// Write lists every file in dir with its checksum and writes custody.json
+// alongside them.
+func (c *CustodyManifest) Write(dir string) error {
+	path := filepath.Join(dir, "custody.json")
+
+	c.Files = []CustodyFile{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || file == path {
+			return err
+		}
+
+		size, sum, err := checksumFile(file)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		c.Files = append(c.Files, CustodyFile{Path: filepath.ToSlash(rel), Bytes: size, SHA256: sum, ModifiedAt: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	stats.mu.Lock()
+	c.Requests = stats.Requests
+	stats.mu.Unlock()
+	c.FinishedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+func currentOperator(performAs string) CustodyOperator {
+	operator := CustodyOperator{PerformAs: performAs}
+	if u, err := user.Current(); err == nil {
+		operator.User = u.Username
+		if u.Name != "" && !strings.EqualFold(u.Name, u.Username) {
+			operator.User = fmt.Sprintf("%s (%s)", u.Name, u.Username)
+		}
+	}
+	operator.Host, _ = os.Hostname()
+	return operator
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// leverID matches the uuids lever uses for every object id.
+var leverID = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "lint-input",
+		Description: "Check an upload input csv for missing columns, bad ids, unknown stages or reasons and duplicates",
+		Run:         runLintInput,
+	})
+}
+
+func runLintInput(args []string) error {
+	flags := NewCommandFlags("lint-input")
+	endpointName := flags.String("for", "", "Upload endpoint the input is for, e.g. uploadTags")
+	mapPath := flags.String("upload-map", "", "YAML file mapping the columns of an input csv with a header onto the upload's fields")
+	flags.Parse(args)
+
+	endpoint, ok := registeredEndpoints[*endpointName]
+	if !ok || endpoint.Columns == nil {
+		return fmt.Errorf("%q is not an upload endpoint", *endpointName)
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("lint-input needs the input csv to check")
+	}
+
+	// Only reference data is fetched, nothing is written to lever
+	var kinds []string
+	for _, column := range endpoint.Columns {
+		if column.Kind != "id" && column.Kind != "text" {
+			kinds = append(kinds, column.Kind)
+		}
+	}
+
+	valid := &Resolver{}
+	if *mapPath != "" {
+		var err error
+		if uploadMapping, err = LoadUploadMapping(*mapPath); err != nil {
+			return err
+		}
+	}
+
+	if len(kinds) > 0 {
+		RequireToken()
+
+		var err error
+		if valid, err = NewResolver(strings.Join(kinds, ",")); err != nil {
+			return err
+		}
+	}
+
+	r, firstRow, f, err := OpenUploadRows(endpoint, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	problems, err := LintInput(endpoint.Columns, r, firstRow, valid)
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problems", flags.Arg(0), len(problems))
+	}
+	fmt.Println(flags.Arg(0), "looks good")
+	return nil
+}
+
+// LintInput checks every row of an upload input against the endpoint's
+// columns and returns a description of each problem found.
+func LintInput(columns []UploadColumn, r RowReader, firstRow int, valid *Resolver) ([]string, error) {
+	var problems []string
+	seenRows := map[string]int{}
+	seenIDs := map[string]int{}
+
+	for row := firstRow; ; row++ {
+		report := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Sprintf("row %d: ", row)+fmt.Sprintf(format, args...))
+		}
+
+		record, err := r.Read()
+		if err == io.EOF {
+			return problems, nil
+		}
+
+		if mappingErr, ok := err.(*MappingError); ok {
+			report("%v", mappingErr)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		key := strings.Join(record, "\x00")
+		if first, ok := seenRows[key]; ok {
+			report("duplicate of row %d", first)
+			continue
+		}
+		seenRows[key] = row
+
+		last := columns[len(columns)-1]
+		if len(record) < len(columns) {
+			report("missing %s column", columns[len(record)].Name)
+		} else if len(record) > len(columns) && !last.Repeated {
+			report("expected %d columns, found %d", len(columns), len(record))
+		}
+
+		for i, value := range record {
+			column := last
+			if i < len(columns) {
+				column = columns[i]
+			} else if !last.Repeated {
+				break
+			}
+
+			value = strings.TrimSpace(value)
+			if value == "" {
+				report("empty %s", column.Name)
+				continue
+			}
+
+			switch column.Kind {
+			case "id":
+				if !leverID.MatchString(value) {
+					report("%s %q is not a lever id", column.Name, value)
+				}
+			case "stages":
+				if _, ok := valid.Stages[value]; !ok {
+					report("%s %q is not a known stage id", column.Name, value)
+				}
+			case "archiveReasons":
+				if _, ok := valid.ArchiveReasons[value]; !ok {
+					report("%s %q is not a known archive reason id", column.Name, value)
+				}
+			}
+		}
+
+		// Later rows for the same candidate silently win for single value
+		// uploads such as stage moves
+		candidateID := strings.TrimSpace(record[0])
+		if first, ok := seenIDs[candidateID]; ok && !last.Repeated && len(columns) > 1 {
+			report("candidate %s already listed on row %d", candidateID, first)
+		} else if !ok {
+			seenIDs[candidateID] = row
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// RunLock stops two runs sharing a checkpoint from running at the same time
+// and corrupting each other's state.
+type RunLock struct {
+	Path      string    `json:"-"`
+	Endpoint  string    `json:"endpoint"`
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// AcquireRunLock creates the lock file next to the checkpoint. When another
+// run holds the lock it returns an error describing the holder unless force
+// is set.
+func AcquireRunLock(endpoint string, state *Checkpoint, force bool) (*RunLock, error) {
+	host, _ := os.Hostname()
+	lock := &RunLock{
+		Path:      state.FilePath + ".lock",
+		Endpoint:  endpoint,
+		PID:       os.Getpid(),
+		Host:      host,
+		StartedAt: time.Now().UTC(),
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
+	if force {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(lock.Path, flags, 0644)
+	if os.IsExist(err) {
+		return nil, lockHeldError(lock.Path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(lock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (lock *RunLock) Release() {
+	if lock == nil {
+		return
+	}
+	os.Remove(lock.Path)
+}
+
+func lockHeldError(path string) error {
+	var holder RunLock
+	if data, err := ioutil.ReadFile(path); err == nil && json.Unmarshal(data, &holder) == nil {
+		return fmt.Errorf("another run of %s (pid %d on %s, started %s) holds %s; if that run is no longer alive rerun with --force",
+			holder.Endpoint, holder.PID, holder.Host, holder.StartedAt.Format(time.RFC3339), path)
+	}
+	return fmt.Errorf("another run holds %s; if that run is no longer alive rerun with --force", path)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingLog is a log file that is rotated once it reaches MaxBytes,
+// keeping MaxFiles old logs as path.1 (newest) to path.N.
+type RotatingLog struct {
+	Path     string
+	MaxBytes int64
+	MaxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func OpenRotatingLog(path string, maxBytes int64, maxFiles int) (*RotatingLog, error) {
+	l := &RotatingLog{Path: path, MaxBytes: maxBytes, MaxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *RotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.MaxBytes > 0 && l.size > 0 && l.size+int64(len(p)) > l.MaxBytes {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *RotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func (l *RotatingLog) open() error {
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+func (l *RotatingLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	// Drop the oldest log and shift the rest up by one
+	os.Remove(fmt.Sprintf("%s.%d", l.Path, l.MaxFiles))
+	for i := l.MaxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.Path, i), fmt.Sprintf("%s.%d", l.Path, i+1))
+	}
+
+	if l.MaxFiles > 0 {
+		if err := os.Rename(l.Path, l.Path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.Path); err != nil {
+		return err
+	}
+	return l.open()
+}
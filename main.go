@@ -1,54 +1,302 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 )
 
 var (
 	//	re_inside_whtsp = regexp.MustCompile(`[\s\p{Zs}]{2,}`)
+	configFile      = flag.String("config", "", "YAML file of flag settings, e.g. endpoint: candidates, flags on the command line win")
 	token           = flag.String("token", "REQUIRED", "Lever api token")
+	tokenFile       = flag.String("token-file", "", "Read the api token from this file instead of --token, rereading it when it changes so a rotated token is used without a restart")
+	tokenCommand    = flag.String("token-command", "", "Shell command printing the api token, e.g. from a secret manager, rerun every --token-refresh")
+	tokenRefresh    = flag.Duration("token-refresh", time.Minute, "How often to check --token-file or rerun --token-command for a rotated token")
 	debug           = flag.Bool("debug", false, "Enable debug logging")
 	download        = flag.Bool("download", true, "Flag to switch upload/download")
 	input           = flag.String("input", "", "File to input and update Lever with")
 	endpoint        = flag.String("endpoint", "", "Lever endpoint to hit")
 	createdAtStart  = flag.String("createdAtStart", "", "Set createdAtStart field")
 	archivedAtStart = flag.String("archivedAtStart", "", "Set archivedAtStart field")
+	since           = flag.String("since", "", "Only download records created from this time, RFC3339, YYYY-MM-DD or an age such as -7d, instead of --createdAtStart")
+	until           = flag.String("until", "", "Only download records created up to this time, RFC3339, YYYY-MM-DD or an age such as -1d")
 	performAs       = flag.String("performAs", "", "Set perform_as query parameter")
+	filterExprs     stringList
+	includeContent  = flag.Bool("include-content", false, "Include full posting content when downloading postings")
+	postingState    = flag.String("state", "", "Only download postings in this state: published, closed, draft, internal or pending")
+	distChannel     = flag.String("distributionChannel", "", "Only download postings on this distribution channel: public or internal")
+	auditLog        = flag.String("audit-log", "", "Append a JSONL audit record of every api call in this run to the given file")
+	resolve         = flag.String("resolve", "", "Comma separated reference data to resolve inline: stages, users, postings, archiveReasons")
+	shardSpec       = flag.String("shard", "", "Only process the k/n share of input candidate ids, e.g. 2/8")
+	output          = flag.String("output", "", "Write records to this file instead of stdout")
+	rotateSize      = flag.String("rotate-size", "", "Start a new numbered output file after this size, e.g. 512MB")
+	rotateRecords   = flag.Int("rotate-records", 0, "Start a new numbered output file after this many records")
+	apiVersionFlag  = flag.String("api-version", "", "Request this lever api version through the Accept header")
+	mimeTypes       = flag.String("mime", "", "Only download candidate files of these comma separated types, e.g. pdf,docx or application/pdf")
+	maxFileSize     = flag.String("max-file-size", "", "Skip candidate files, or resumes read for --extract-text, larger than this, e.g. 10MB")
+	filesDirFlag    = flag.String("files-dir", "", "Also archive candidate files themselves to <dir>/<candidateId>/<fileId>.<ext>")
+	resumeTablesDir = flag.String("resume-tables", "", "Also write the positions and schools parsed from resumes as child tables into this directory")
+	extractText     = flag.Bool("extract-text", false, "Download resume files and add their pdf, docx or plain text to the resume records")
+	allowSurveys    = flag.Bool("allow-surveys", false, "Allow downloading candidate survey responses, which may contain sensitive free text")
+	pretty          = flag.Bool("pretty", false, "Indent json output for human review")
+	sortKeysFlag    = flag.Bool("sort-keys", false, "Write json object keys in sorted order for stable diffs")
+	sortBy          = flag.String("sort-by", "", "Buffer records and write them ordered by createdAt or id")
+	fieldMap        = flag.String("field-map", "", "YAML file renaming, dropping or defaulting output fields per resource type")
+	policyFile      = flag.String("policy", "", "YAML file declaring the fields each output destination may receive")
+	destination     = flag.String("destination", "", "Output destination whose --policy applies to this run")
+	scanRules       = flag.String("scan", "", "YAML file of regex rules to match against the text fields of every record written")
+	scanReport      = flag.String("scan-report", "", "File to write --scan findings to, next to the --output file by default")
+	nulls           = flag.Bool("nulls", false, "Write null instead of empty strings and zero timestamps")
+	layout          = flag.String("layout", "", "Output layout, datalake writes resource=<type>/ingest_date=<date>/part files under --output in the json --format, parquet isn't supported")
+	format          = flag.String("format", "ndjson", "Output format: ndjson or json-array")
+	force           = flag.Bool("force", false, "Take over the run lock even if another run appears to hold it")
+	ids             = flag.String("ids", "", "Comma separated candidate ids to use instead of an --input csv")
+	extractScore    = flag.Bool("extract-score", false, "Add a top-level score to feedback extracted from the form fields")
+	detectLanguage  = flag.Bool("detect-language", false, "Add the language of feedback answers and interview notes as a language field")
+	manifestPath    = flag.String("manifest", "", "After the run write a json manifest of the output files, row counts and checksums to this file")
+	batchSize       = flag.Int("batch-size", 0, "Pause uploads after this many rows have been written")
+	batchDelay      = flag.Duration("batch-delay", 0, "How long uploads pause between batches, e.g. 30s")
+	uploadMap       = flag.String("upload-map", "", "YAML file mapping the columns of an input csv with a header onto the upload's fields")
+	notifySlack     = flag.String("notify-slack", "", "Post a run summary to this slack webhook url when the run finishes or fails")
+	notifyEmail     = flag.String("notify-email", "", "Comma separated addresses to email a run summary to when the run finishes or fails")
+	smtpAddr        = flag.String("smtp-addr", "localhost:25", "SMTP server host:port for --notify-email")
+	smtpUser        = flag.String("smtp-user", "", "SMTP username, the password is read from FULCRUM_SMTP_PASSWORD")
+	smtpFrom        = flag.String("smtp-from", "fulcrum@localhost", "From address for --notify-email")
+	pagerDutyKey    = flag.String("pagerduty-key", "", "PagerDuty events routing key to trigger an incident with when the run fails")
+	sla             = flag.Duration("sla", 0, "With --pagerduty-key also trigger an incident when the run takes longer than this, e.g. 2h")
+	sentryDSN       = flag.String("sentry-dsn", "", "Report fatal errors and panics to this sentry dsn")
+	stateDirFlag    = flag.String("state-dir", DefaultStateDir(), "Directory for checkpoints and run locks")
+	perCandidateDir = flag.String("per-candidate-dir", "", "Write each candidate's records to <dir>/<candidateId>/<type>.json")
+	watch           = flag.Duration("watch", 0, "Keep running and export records changed since the last poll at this interval, e.g. 5m")
+	referenceTTL    = flag.Duration("reference-ttl", time.Hour, "With --watch refetch stages, users, postings and archive reasons once cached this long")
+	precheckIDs     = flag.Bool("precheck-ids", false, "Before a per-candidate export check the input ids against lever's candidates and skip those it doesn't know")
+	knownCandidates = flag.String("known-candidates", "", "Candidates export for --precheck-ids to check against instead of listing lever's candidates")
+	unknownIDs      = flag.String("unknown-ids", "unknown_candidate_ids.csv", "File --precheck-ids writes the unknown input ids to")
+	maxBandwidth    = flag.String("max-bandwidth", "", "Cap the download rate of candidate files and resumes, e.g. 10MB/s, api requests aren't counted")
+	packageFormat   = flag.String("package", "", "Bundle the output files, manifest and run summary into a timestamped archive, the only format is zip")
+	packageDir      = flag.String("package-dir", "", "Directory to write the --package to, next to the --output file by default")
+	packagePassEnv  = flag.String("package-password-env", "", "Encrypt the --package with AES using the password in this environment variable")
+	runProfile      = flag.String("profile", "", "Preset export, legal-hold packages every record and file of the --candidate unredacted with a chain of custody")
+	holdCandidate   = flag.String("candidate", "", "Id of the candidate a --profile legal-hold exports")
+	maxRecordAge    = flag.String("max-age", "", "Leave out records created longer ago than this retention window, e.g. 3y, 18w or 90d")
+	cdc             = flag.Bool("cdc", false, "Write created, updated and archived events with field diffs against the last version seen instead of records")
+	hashFlag        = flag.Bool("hash", false, "Add a _hash field to each record, a sha256 of its canonical json, and list the hashes in the --manifest")
+	onlyChanged     = flag.Bool("only-changed", false, "Only write records whose _hash differs from the one in the --prev manifest")
+	prevManifest    = flag.String("prev", "", "Manifest of the previous --hash run for --only-changed to compare with")
+	profilesFile    = flag.String("client-profiles", "", "YAML file overriding the timeout, maxRetries, retryBackoff and concurrency of the list, download and default client profiles or of single endpoints")
+	logFile         = flag.String("log-file", "", "Write logs to this file instead of stderr")
+	logMaxSize      = flag.String("log-max-size", "100MB", "Rotate the --log-file once it reaches this size")
+	logMaxFiles     = flag.Int("log-max-files", 5, "How many rotated log files to keep")
+	logLevel        = flag.String("log-level", "info", "Log level, debug logs every lever request with its request id")
+	runDeadline     = flag.Duration("run-deadline", 0, "Stop the run once it has taken this long, e.g. 4h, exiting with code 3 after saving the checkpoint")
+	retryBudget     = flag.Int("retry-budget", 0, "Stop the run once this many requests have been retried, exiting with code 3 after saving the checkpoint")
+	sessionID       = flag.String("session", "", "Keep checkpoints, failure reports and output under this session id, e.g. nightly-2024-06-01, resuming on each run until the session completes")
 )
 
 type Config struct {
 	LeverToken      string
+	TokenFile       string
+	TokenCommand    string
+	TokenRefresh    time.Duration
 	Debug           bool
 	Download        bool
 	Input           string
 	Endpoint        string
 	CreatedAtStart  string
 	ArchivedAtStart string
+	Since           string
+	Until           string
+	// CreatedAtEnd is set from --until.
+	CreatedAtEnd    string
 	PerformAs       string
+	IncludeContent  bool
+	ExtractScore    bool
+	DetectLanguage  bool
+	PostingState    string
+	DistChannel     string
+	AuditLog        string
+	Resolve         string
+	IDs             []string
+	Filters         []string
+	Shard           string
+	Force           bool
+	Output          string
+	RotateSize      string
+	RotateRecords   int
+	APIVersion      string
+	AllowSurveys    bool
+	MimeTypes       string
+	MaxFileSize     string
+	FilesDir        string
+	ExtractText     bool
+	ResumeTables    string
+	Format          string
+	Pretty          bool
+	SortKeys        bool
+	SortBy          string
+	FieldMap        string
+	Policy          string
+	Scan            string
+	ScanReport      string
+	Destination     string
+	Nulls           bool
+	Layout          string
+	Manifest        string
+	BatchSize       int
+	BatchDelay      time.Duration
+	UploadMap       string
+	NotifySlack     string
+	NotifyEmail     []string
+	SMTPAddr        string
+	SMTPUser        string
+	SMTPPassword    string
+	SMTPFrom        string
+	PagerDutyKey    string
+	SLA             time.Duration
+	SentryDSN       string
+	StateDir        string
+	PerCandidateDir string
+	Watch           time.Duration
+	CDC             bool
+	Hash            bool
+	OnlyChanged     bool
+	Prev            string
+	LogFile         string
+	LogMaxSize      string
+	LogMaxFiles     int
+	LogLevel        string
+	RunDeadline     time.Duration
+	RetryBudget     int
+	Session         string
+	ClientProfiles  string
+	ReferenceTTL    time.Duration
+	PrecheckIDs     bool
+	KnownCandidates string
+	UnknownIDs      string
+	MaxBandwidth    string
+	Package         string
+	PackageDir      string
+	PackagePassEnv  string
+	Profile         string
+	Candidate       string
+	MaxAge          string
 }
 
 func LoadFromFlags() (*Config, error) {
 	flag.Parse()
 
+	if *configFile != "" {
+		if err := ApplyConfigFile(flag.CommandLine, *configFile); err != nil {
+			return nil, err
+		}
+	}
+	return flagConfig(), nil
+}
+
+// flagConfig reads the configuration from the parsed flags.
+func flagConfig() *Config {
 	return &Config{
 		LeverToken:      *token,
+		TokenFile:       *tokenFile,
+		TokenCommand:    *tokenCommand,
+		TokenRefresh:    *tokenRefresh,
 		Debug:           *debug,
 		Input:           *input,
 		Endpoint:        *endpoint,
 		CreatedAtStart:  *createdAtStart,
 		ArchivedAtStart: *archivedAtStart,
+		Since:           *since,
+		Until:           *until,
 		PerformAs:       *performAs,
-	}, nil
+		IncludeContent:  *includeContent,
+		ExtractScore:    *extractScore,
+		DetectLanguage:  *detectLanguage,
+		PostingState:    *postingState,
+		DistChannel:     *distChannel,
+		AuditLog:        *auditLog,
+		Resolve:         *resolve,
+		IDs:             ParseIDs(*ids),
+		Filters:         filterExprs,
+		Shard:           *shardSpec,
+		Force:           *force,
+		Output:          *output,
+		RotateSize:      *rotateSize,
+		RotateRecords:   *rotateRecords,
+		APIVersion:      *apiVersionFlag,
+		AllowSurveys:    *allowSurveys,
+		MimeTypes:       *mimeTypes,
+		MaxFileSize:     *maxFileSize,
+		FilesDir:        *filesDirFlag,
+		ExtractText:     *extractText,
+		ResumeTables:    *resumeTablesDir,
+		Format:          *format,
+		Pretty:          *pretty,
+		SortKeys:        *sortKeysFlag,
+		SortBy:          *sortBy,
+		FieldMap:        *fieldMap,
+		Policy:          *policyFile,
+		Scan:            *scanRules,
+		ScanReport:      *scanReport,
+		Destination:     *destination,
+		Nulls:           *nulls,
+		Layout:          *layout,
+		Manifest:        *manifestPath,
+		BatchSize:       *batchSize,
+		BatchDelay:      *batchDelay,
+		UploadMap:       *uploadMap,
+		NotifySlack:     *notifySlack,
+		NotifyEmail:     ParseIDs(*notifyEmail),
+		SMTPAddr:        *smtpAddr,
+		SMTPUser:        *smtpUser,
+		SMTPPassword:    os.Getenv("FULCRUM_SMTP_PASSWORD"),
+		SMTPFrom:        *smtpFrom,
+		PagerDutyKey:    *pagerDutyKey,
+		SLA:             *sla,
+		SentryDSN:       *sentryDSN,
+		StateDir:        *stateDirFlag,
+		PerCandidateDir: *perCandidateDir,
+		Watch:           *watch,
+		CDC:             *cdc,
+		Hash:            *hashFlag,
+		OnlyChanged:     *onlyChanged,
+		Prev:            *prevManifest,
+		LogFile:         *logFile,
+		LogMaxSize:      *logMaxSize,
+		LogMaxFiles:     *logMaxFiles,
+		LogLevel:        *logLevel,
+		RunDeadline:     *runDeadline,
+		RetryBudget:     *retryBudget,
+		Session:         *sessionID,
+		ClientProfiles:  *profilesFile,
+		ReferenceTTL:    *referenceTTL,
+		PrecheckIDs:     *precheckIDs,
+		KnownCandidates: *knownCandidates,
+		UnknownIDs:      *unknownIDs,
+		MaxBandwidth:    *maxBandwidth,
+		Package:         *packageFormat,
+		PackageDir:      *packageDir,
+		PackagePassEnv:  *packagePassEnv,
+		Profile:         *runProfile,
+		Candidate:       *holdCandidate,
+		MaxAge:          *maxRecordAge,
+	}
 }
 
 func init() {
+	AddCSVFlags(flag.CommandLine)
+	flag.Var(&filterExprs, "filter", `Only output records matching a predicate like 'tags contains "university"', may be repeated`)
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
+		printCommands()
 	}
 }
 
@@ -57,17 +305,209 @@ func main() {
 		flag.Usage()
 	}
 
-	config, _ := LoadFromFlags()
+	if RunCommand(os.Args[1:]) {
+		return
+	}
+
+	config, err := LoadFromFlags()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	if config.LogFile != "" {
+		maxBytes, err := ParseByteSize(config.LogMaxSize)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		logs, err := OpenRotatingLog(config.LogFile, maxBytes, config.LogMaxFiles)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer logs.Close()
+		logrus.SetOutput(logs)
+	}
+
+	level, err := logrus.ParseLevel(config.LogLevel)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.SetLevel(level)
+
 	apiToken = config.LeverToken
+	extractScores = config.ExtractScore
+	detectLanguages = config.DetectLanguage
+	apiVersion = config.APIVersion
+	candidateIDs = config.IDs
+	stateDir = config.StateDir
+	uploadBatchSize = config.BatchSize
+	uploadBatchDelay = config.BatchDelay
+	for _, expr := range config.Filters {
+		filter, err := ParseFilter(expr)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		filters = append(filters, filter)
+	}
+
+	notifyEndpoint = config.Endpoint
+	if config.NotifySlack != "" {
+		AddNotifier(&SlackNotifier{WebhookURL: config.NotifySlack})
+	}
+
+	if len(config.NotifyEmail) > 0 {
+		AddNotifier(&EmailNotifier{
+			Addr:     config.SMTPAddr,
+			Username: config.SMTPUser,
+			Password: config.SMTPPassword,
+			From:     config.SMTPFrom,
+			To:       config.NotifyEmail,
+		})
+	}
+
+	if config.PagerDutyKey != "" {
+		pagerDuty := &PagerDutyNotifier{RoutingKey: config.PagerDutyKey}
+		AddNotifier(pagerDuty)
+
+		if config.SLA > 0 {
+			defer pagerDuty.WatchSLA(config.Endpoint, config.SLA).Stop()
+		}
+	} else if config.SLA > 0 {
+		logrus.Fatal("--sla needs a --pagerduty-key to alert with.")
+	}
+
+	if config.SentryDSN != "" {
+		sentry, err := NewSentryNotifier(config.SentryDSN)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		AddNotifier(sentry)
+		defer sentry.CapturePanic()
+	}
+
+	defer StartTokenSource(config.TokenFile, config.TokenCommand, config.TokenRefresh)()
+
 	if apiToken == "" {
 		logrus.Fatal("No api token given use --token= to specify one.")
 	}
 
+	if config.Shard != "" {
+		var err error
+		if shard, err = ParseShard(config.Shard); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if err := ResolveTimeWindow(config, time.Now()); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if config.MaxAge != "" {
+		age, err := ParseAge(config.MaxAge)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		retention = &Retention{MaxAge: age}
+	}
+
+	if config.Session != "" {
+		// A watch never completes, so neither would its session
+		if config.Watch > 0 {
+			logrus.Fatal("--session can't be combined with --watch.")
+		}
+
+		var err error
+		if session, err = ParseSession(config.Session); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if config.AuditLog != "" {
+		var err error
+		if audit, err = OpenAuditLog(config.AuditLog); err != nil {
+			logrus.Fatal(err)
+		}
+		defer audit.Close()
+
+		audit.RunStart(config)
+		logrus.RegisterExitHandler(func() {
+			audit.RunEnd(errors.New("run exited with a fatal error"))
+			audit.Close()
+		})
+	}
+
+	if config.ClientProfiles != "" {
+		if err := LoadClientProfiles(config.ClientProfiles); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if config.Profile != "" {
+		if config.Profile != legalHoldProfile {
+			logrus.Fatal("Unknown profile: ", config.Profile)
+		}
+
+		// A legal hold is always handed over as a package
+		if config.Package == "" {
+			config.Package = "zip"
+		}
+	} else if config.Candidate != "" {
+		logrus.Fatal("--candidate needs --profile legal-hold.")
+	}
+
+	if config.Package != "" {
+		if config.Package != "zip" {
+			logrus.Fatal("Unknown package format: ", config.Package)
+		}
+		if config.Watch > 0 {
+			logrus.Fatal("--package can't be combined with --watch, a watch never finishes its output.")
+		}
+
+		runPackage = &Package{Dir: config.PackageDir}
+		if runPackage.Dir == "" {
+			runPackage.Dir = filepath.Dir(config.Output)
+		}
+
+		if config.PackagePassEnv != "" {
+			if runPackage.Password = os.Getenv(config.PackagePassEnv); runPackage.Password == "" {
+				logrus.Fatal("--package-password-env names ", config.PackagePassEnv, " which isn't set.")
+			}
+		}
+	} else if config.PackageDir != "" || config.PackagePassEnv != "" {
+		logrus.Fatal("--package-dir and --package-password-env need --package zip.")
+	}
+
+	if config.MaxBandwidth != "" {
+		var err error
+		if bandwidth, err = ParseBandwidth(config.MaxBandwidth); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if config.Profile != "" {
+		if err := LegalHold(config); err != nil {
+			logrus.Fatal(err)
+		}
+
+		stats.Report()
+		audit.RunEnd(nil)
+		if err := NotifyRunEnd(nil); err != nil {
+			logrus.Warn(err)
+		}
+		CloseStateStores()
+		logrus.Info("All done")
+		return
+	}
+
 	queryParams := []QueryParam{}
 	if config.CreatedAtStart != "" {
 		queryParams = append(queryParams, QueryParam{Field: "created_at_start", Value: config.CreatedAtStart})
 	}
 
+	if config.CreatedAtEnd != "" {
+		queryParams = append(queryParams, QueryParam{Field: "created_at_end", Value: config.CreatedAtEnd})
+	}
+
 	if config.ArchivedAtStart != "" {
 		queryParams = append(queryParams, QueryParam{Field: "archived_at_start", Value: config.ArchivedAtStart})
 	}
@@ -80,13 +520,427 @@ func main() {
 	if !ok {
 		logrus.Fatal("Looks like the endpoint is not registered")
 	}
+
+	if endpoint.Type == "surveys" && !config.AllowSurveys {
+		logrus.Fatal("Survey responses may contain sensitive free text, pass --allow-surveys to download them.")
+	}
+
+	if config.MimeTypes != "" || config.MaxFileSize != "" || config.FilesDir != "" {
+		resumeText := endpoint.Type == "resumes" && config.ExtractText && config.MimeTypes == "" && config.FilesDir == ""
+		if endpoint.Type != "files" && !resumeText {
+			logrus.Fatal("--mime and --files-dir only apply to downloadFiles, --max-file-size to downloadFiles and downloadResumes with --extract-text.")
+		}
+
+		var maxBytes int64
+		if config.MaxFileSize != "" {
+			var err error
+			if maxBytes, err = ParseByteSize(config.MaxFileSize); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+
+		var err error
+		if fileFilter, err = NewFileFilter(config.MimeTypes, maxBytes); err != nil {
+			logrus.Fatal(err)
+		}
+		filesDir = config.FilesDir
+	}
+
+	if config.ExtractText {
+		if endpoint.Type != "resumes" {
+			logrus.Fatal("--extract-text only applies to downloadResumes.")
+		}
+		extractResumeText = true
+	}
+
+	if config.ResumeTables != "" {
+		if endpoint.Type != "resumes" {
+			logrus.Fatal("--resume-tables only applies to downloadResumes.")
+		}
+
+		// Child rows have no id of their own to track versions by
+		if config.CDC {
+			logrus.Fatal("--resume-tables can't be combined with --cdc.")
+		}
+
+		var err error
+		if resumeTables, err = NewResumeTables(config.ResumeTables); err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.RegisterExitHandler(func() { resumeTables.Close() })
+	}
+
+	if endpoint.Type == "postings" {
+		if config.IncludeContent {
+			queryParams = append(queryParams, QueryParam{Field: "include", Value: "content"})
+		}
+
+		if config.PostingState != "" {
+			if !postingStates[config.PostingState] {
+				logrus.Fatal("Unknown posting state: ", config.PostingState)
+			}
+			queryParams = append(queryParams, QueryParam{Field: "state", Value: config.PostingState})
+		}
+
+		if config.DistChannel != "" {
+			if !distributionChannels[config.DistChannel] {
+				logrus.Fatal("Unknown distribution channel: ", config.DistChannel)
+			}
+			queryParams = append(queryParams, QueryParam{Field: "distribution_channel", Value: config.DistChannel})
+		}
+	}
 	endpoint.QueryParams = queryParams
 
+	if !outputFormats[config.Format] {
+		logrus.Fatal("Unknown output format: ", config.Format)
+	}
+	outputFormat = config.Format
+	prettyOutput = config.Pretty
+	sortKeys = config.SortKeys
+	emitNulls = config.Nulls
+
+	if config.Policy != "" || config.Destination != "" {
+		if config.Policy == "" || config.Destination == "" {
+			logrus.Fatal("--policy and --destination are needed together.")
+		}
+
+		var err error
+		if accessPolicy, err = LoadAccessPolicy(config.Policy, config.Destination); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if config.Scan != "" {
+		report := config.ScanReport
+		if report == "" {
+			report = "findings.json"
+			if config.Output != "" {
+				report = strings.TrimSuffix(config.Output, filepath.Ext(config.Output)) + "_findings.json"
+			}
+		}
+
+		var err error
+		if scanner, err = LoadScanner(config.Scan, report); err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.RegisterExitHandler(func() { scanner.Close() })
+	} else if config.ScanReport != "" {
+		logrus.Fatal("--scan-report needs --scan rules to match.")
+	}
+
+	if config.FieldMap != "" {
+		var err error
+		if fieldMappings, err = LoadFieldMappings(config.FieldMap); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	var partition *DataLakePartition
+	var files *RotatingFile
+	if config.PerCandidateDir != "" {
+		if !strings.Contains(endpoint.SprintfPath, "/candidates/%s/") || endpoint.Planner != nil {
+			logrus.Fatal("--per-candidate-dir only applies to endpoints downloaded per candidate.")
+		}
+
+		if config.Output != "" || config.Layout != "" || config.SortBy != "" || config.Manifest != "" || config.RotateSize != "" || config.RotateRecords > 0 {
+			logrus.Fatal("--per-candidate-dir can't be combined with --output, --layout, --sort-by, --manifest or rotation.")
+		}
+
+		candidateSink = NewPerCandidateSink(session.Dir(config.PerCandidateDir), endpoint.Type)
+		SetSink(candidateSink)
+	} else if config.Output != "" {
+		var maxBytes int64
+		if config.RotateSize != "" {
+			var err error
+			if maxBytes, err = ParseByteSize(config.RotateSize); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+
+		switch config.Layout {
+		case "":
+			if session == nil {
+				files = NewRotatingFile(config.Output, maxBytes, config.RotateRecords)
+				break
+			}
+
+			var err error
+			if files, err = session.Sink(config.Output, maxBytes, config.RotateRecords); err != nil {
+				logrus.Fatal(err)
+			}
+		case "datalake":
+			var err error
+			if partition, err = NewDataLakePartition(session.Dir(config.Output), endpoint.Type, time.Now().UTC()); err != nil {
+				logrus.Fatal(err)
+			}
+			files = partition.Sink(maxBytes, config.RotateRecords)
+		default:
+			logrus.Fatal("Unknown output layout: ", config.Layout)
+		}
+		SetSink(files)
+	} else if config.Layout != "" {
+		logrus.Fatal("The output layout needs an --output directory.")
+	} else if config.RotateSize != "" || config.RotateRecords > 0 {
+		logrus.Fatal("Output rotation needs an --output file to rotate.")
+	} else if config.Manifest != "" {
+		logrus.Fatal("A manifest needs an --output file to describe.")
+	} else {
+		SetSink(sink)
+	}
+
+	if config.SortBy != "" {
+		if !sortFields[config.SortBy] {
+			logrus.Fatal("Unknown --sort-by field: ", config.SortBy)
+		}
+		SetSink(NewSortingSink(sink, config.SortBy))
+	}
+
+	logrus.RegisterExitHandler(func() { CloseOutput() })
+
+	if config.Manifest != "" {
+		manifest = NewManifest(config.Manifest, config, endpoint)
+	}
+
+	if config.UploadMap != "" {
+		if endpoint.Columns == nil {
+			logrus.Fatal("--upload-map only applies to upload endpoints.")
+		}
+
+		var err error
+		if uploadMapping, err = LoadUploadMapping(config.UploadMap); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if config.Resolve != "" {
+		var err error
+		if resolver, err = NewResolver(config.Resolve); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
 	handler := endpoint.Handler
-	state := NewCheckpoint(endpoint.Type)
-	err := handler(endpoint, config.Input, state)
+	state := NewCheckpoint(session.Namespace(shard.Namespace(endpoint.Type)))
+	notifyState = state
+	HookRunEnd()
+
+	lock, err := AcquireRunLock(config.Endpoint, state, config.Force)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer lock.Release()
+	logrus.RegisterExitHandler(lock.Release)
+
+	if session != nil {
+		store, err := state.Store()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		done, err := session.Resume(store)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if done {
+			logrus.Infof("Session %s completed at %s, nothing left to do", session.ID, session.CompletedAt.Format(time.RFC3339))
+			CloseStateStores()
+			return
+		}
+		logrus.WithFields(logrus.Fields{"session": session.ID, "run": session.Runs}).Info("Resuming session")
+	}
+
+	// Sorted output is only written when the run ends, a checkpoint would
+	// claim records a crash loses, so a sorted run always starts over
+	if config.SortBy != "" {
+		if state.Saved() != nil {
+			logrus.Fatal("--sort-by can't resume the checkpoint an earlier run left, finish that run without --sort-by first.")
+		}
+		state.NoSave = true
+	}
+
+	// A resumed run carries on the output the interrupted one left
+	resuming := state.Saved() != nil
+	if files != nil && resuming {
+		files.Append = true
+	}
+
+	if partition != nil {
+		if err := partition.Start(resuming); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if config.Watch > 0 {
+		if !SupportsWatch(endpoint) {
+			logrus.Fatal("--watch needs a top level endpoint with updatedAt, such as downloadCandidates.")
+		}
+
+		// Sorted output is only written once the run ends, which a watch never does
+		if config.SortBy != "" {
+			logrus.Fatal("--watch can't be combined with --sort-by.")
+		}
+
+		store, err := state.Store()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if watermark, err = LoadWatermark(store, shard.Namespace(endpoint.Type)); err != nil {
+			logrus.Fatal(err)
+		}
+		endpoint.QueryParams = watermark.StartPoll(queryParams)
+	}
+
+	if config.CDC {
+		if _, ok := recordTypes[endpoint.Type]; !ok {
+			logrus.Fatal("--cdc needs an endpoint that downloads records.")
+		}
+
+		store, err := state.Store()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		versions = &VersionStore{store: store}
+	}
+
+	if config.OnlyChanged {
+		if config.Prev == "" {
+			logrus.Fatal("--only-changed needs the --prev manifest to compare with.")
+		}
+		if config.CDC {
+			logrus.Fatal("--only-changed can't be combined with --cdc.")
+		}
+
+		if previousHashes, err = LoadPreviousHashes(config.Prev); err != nil {
+			logrus.Fatal(err)
+		}
+	} else if config.Prev != "" {
+		logrus.Fatal("--prev is only used with --only-changed.")
+	}
+	hashRecords = config.Hash || config.OnlyChanged
+
+	if config.PrecheckIDs {
+		if !strings.Contains(endpoint.SprintfPath, "%s") {
+			logrus.Fatal("--precheck-ids only applies to per-candidate endpoints.")
+		}
+
+		if unknownCandidates, err = PrecheckCandidates(config.Input, config.KnownCandidates, config.UnknownIDs); err != nil {
+			logrus.Fatal("Unable to precheck candidate ids: ", err)
+		}
+	} else if config.KnownCandidates != "" {
+		logrus.Fatal("--known-candidates is only used with --precheck-ids.")
+	}
+
+	if config.RunDeadline > 0 || config.RetryBudget > 0 {
+		runBudget = &RunBudget{MaxRetries: config.RetryBudget}
+		if config.RunDeadline > 0 {
+			runBudget.Deadline = stats.Start.Add(config.RunDeadline)
+		}
+	}
+
+	err = handler(endpoint, config.Input, state)
+	if runBudget.Exceeded() {
+		ExitOverBudget(config.Endpoint, err, state)
+	}
+
 	if err != nil {
+		LogFatal(err)
+	}
+
+	if config.Watch > 0 {
+		// A one off run keeps reference data throughout, a watch would never
+		// see a new stage or user
+		referenceCache.TTL = config.ReferenceTTL
+		Watch(config.Watch, func() error {
+			if resolver != nil && referenceCache.Stale() {
+				refreshed, err := NewResolver(config.Resolve)
+				if err != nil {
+					return err
+				}
+				resolver = refreshed
+			}
+
+			endpoint.QueryParams = watermark.StartPoll(queryParams)
+			err := handler(endpoint, config.Input, state)
+			if runBudget.Exceeded() {
+				ExitOverBudget(config.Endpoint, err, state)
+			}
+			return err
+		})
+	}
+	if err := CloseOutput(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// Everything is written, the versions staged since the last checkpoint
+	// can be kept
+	if versions != nil {
+		if err := versions.Save(versions.store); err != nil {
+			logrus.Fatal("Unable to store record versions: ", err)
+		}
+	}
+
+	if err := resumeTables.Close(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if err := scanner.Close(); err != nil {
 		logrus.Fatal(err)
 	}
+
+	// The manifest goes first so it exists by the time _SUCCESS is seen
+	if err := manifest.Write(files); err != nil {
+		logrus.Fatal("Unable to write manifest: ", err)
+	}
+
+	if partition != nil {
+		if err := partition.MarkSuccess(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if runPackage != nil {
+		if partition != nil {
+			runPackage.Add(partition.Dir)
+		} else if files != nil {
+			runPackage.Add(files.Parts...)
+		}
+		if candidateSink != nil {
+			runPackage.Add(session.Dir(config.PerCandidateDir))
+		}
+		runPackage.Add(config.Manifest, scanner.Report(), config.ResumeTables, filesDir)
+
+		path, err := runPackage.Write(config.Endpoint, CurrentRunSummary(nil))
+		if err != nil {
+			logrus.Fatal("Unable to write package: ", err)
+		}
+		logrus.WithField("package", path).Info("Packaged the run's output")
+	}
+
+	if session != nil {
+		store, err := state.Store()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if err := session.Complete(store); err != nil {
+			logrus.Fatal(err)
+		}
+		logrus.Infof("Session %s is done after %d runs", session.ID, session.Runs)
+	}
+
+	if config.OnlyChanged {
+		logrus.WithField("records", unchangedRecords).Info("Left out unchanged records")
+	}
+	if retention != nil {
+		logrus.WithField("records", retention.Dropped()).Info("Left out records older than --max-age")
+	}
+	stats.Report()
+	audit.RunEnd(nil)
+	if err := NotifyRunEnd(nil); err != nil {
+		logrus.Warn(err)
+	}
+	CloseStateStores()
 	logrus.Info("All done")
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Manifest describes the files a run wrote so a downstream loader, e.g. an
+// Airflow or Dagster sensor, can tell when output is ready and check it
+// arrived complete.
+type Manifest struct {
+	Endpoint      string         `json:"endpoint"`
+	Resource      string         `json:"resource"`
+	SchemaVersion string         `json:"schemaVersion"`
+	Format        string         `json:"format"`
+	StartedAt     time.Time      `json:"startedAt"`
+	FinishedAt    time.Time      `json:"finishedAt"`
+	Window        ManifestWindow `json:"window"`
+	Records       int            `json:"records"`
+	Files         []ManifestFile `json:"files"`
+	// Hashes are the _hash of each record by id, written with --hash so the
+	// next run can compare against them with --only-changed --prev.
+	Hashes map[string]string `json:"hashes,omitempty"`
+
+	path   string
+	schema reflect.Type
+}
+
+// ManifestWindow is the time window the output covers, both as requested
+// and as seen in the createdAt of the records written.
+type ManifestWindow struct {
+	CreatedAtStart  string `json:"createdAtStart,omitempty"`
+	CreatedAtEnd    string `json:"createdAtEnd,omitempty"`
+	ArchivedAtStart string `json:"archivedAtStart,omitempty"`
+	MinCreatedAt    int    `json:"minCreatedAt,omitempty"`
+	MaxCreatedAt    int    `json:"maxCreatedAt,omitempty"`
+}
+
+type ManifestFile struct {
+	Path    string `json:"path"`
+	Records int    `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// manifest is nil unless --manifest was given, every method is safe to call
+// on a nil manifest.
+var manifest *Manifest
+
+func NewManifest(path string, config *Config, endpoint Endpoint) *Manifest {
+	return &Manifest{
+		Endpoint:  config.Endpoint,
+		Resource:  endpoint.Type,
+		Format:    config.Format,
+		StartedAt: time.Now().UTC(),
+		Window: ManifestWindow{
+			CreatedAtStart:  config.CreatedAtStart,
+			CreatedAtEnd:    config.CreatedAtEnd,
+			ArchivedAtStart: config.ArchivedAtStart,
+		},
+		path: path,
+	}
+}
+
+// Observe records a record being written.
+func (m *Manifest) Observe(obj interface{}) {
+	if m == nil {
+		return
+	}
+	m.Records++
+
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	m.schema = v.Type()
+
+	createdAt := v.FieldByName("CreatedAt")
+	if !createdAt.IsValid() || createdAt.Kind() != reflect.Int || createdAt.Int() == 0 {
+		return
+	}
+
+	at := int(createdAt.Int())
+	if m.Window.MinCreatedAt == 0 || at < m.Window.MinCreatedAt {
+		m.Window.MinCreatedAt = at
+	}
+	if at > m.Window.MaxCreatedAt {
+		m.Window.MaxCreatedAt = at
+	}
+}
+
+// ObserveHash records the hash of a record, including records left out
+// because they didn't change, so the manifest describes the full set.
+func (m *Manifest) ObserveHash(id, hash string) {
+	if m == nil || id == "" {
+		return
+	}
+	if m.Hashes == nil {
+		m.Hashes = map[string]string{}
+	}
+	m.Hashes[id] = hash
+}
+
+// Write lists the given output files with their sizes and checksums and
+// writes the manifest. It is meant to be called once the sink is closed.
+func (m *Manifest) Write(files *RotatingFile) error {
+	if m == nil {
+		return nil
+	}
+	m.FinishedAt = time.Now().UTC()
+	var mapping *FieldMapping
+	if fm, ok := fieldMappings[m.Resource]; ok {
+		mapping = &fm
+	} else if fm, ok := fieldMappings["*"]; ok {
+		mapping = &fm
+	}
+	m.SchemaVersion = SchemaVersion(m.schema, mapping)
+
+	m.Files = []ManifestFile{}
+	for i, path := range files.Parts {
+		size, sum, err := checksumFile(path)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, ManifestFile{Path: path, Records: files.PartRecords[i], Bytes: size, SHA256: sum})
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write through a temporary file so a sensor never reads half a manifest
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+func checksumFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SchemaVersion fingerprints the json layout of a record type, together with
+// any field mapping applied to it, so loaders can notice when the shape of
+// the output changes between releases.
+func SchemaVersion(t reflect.Type, mapping *FieldMapping) string {
+	if t == nil {
+		return ""
+	}
+
+	h := sha256.New()
+	writeSchema(h, t, map[reflect.Type]bool{})
+	if mapping != nil {
+		fmt.Fprintf(h, "%v", *mapping)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+func writeSchema(w io.Writer, t reflect.Type, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		fmt.Fprint(w, "[")
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Map {
+		fmt.Fprint(w, "map:")
+		writeSchema(w, t.Elem(), seen)
+		return
+	}
+
+	if t.Kind() != reflect.Struct || seen[t] {
+		fmt.Fprint(w, t.Kind().String(), ";")
+		return
+	}
+	seen[t] = true
+
+	fmt.Fprint(w, "{")
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fmt.Fprint(w, field.Tag.Get("json"), ":")
+		writeSchema(w, field.Type, seen)
+	}
+	fmt.Fprint(w, "}")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// RunSummary is what notifiers are told when a run finishes or fails.
+type RunSummary struct {
+	Endpoint   string          `json:"endpoint"`
+	StartedAt  time.Time       `json:"startedAt"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Records    int             `json:"records"`
+	Requests   int             `json:"requests"`
+	Errors     int             `json:"errors"`
+	Duration   string          `json:"duration"`
+	Checkpoint *checkpointFile `json:"checkpoint,omitempty"`
+}
+
+// Notifier sends a run summary somewhere people will see it.
+type Notifier interface {
+	Notify(summary RunSummary) error
+}
+
+var (
+	notifiers      []Notifier
+	notifyEndpoint string
+	notifyState    *Checkpoint
+	loggedErrors   int
+	notified       bool
+	hooked         bool
+)
+
+var notifyClient = http.Client{Timeout: 10 * time.Second}
+
+// AddNotifier registers a notifier and hooks into logging so a fatal error
+// is reported with its cause before fulcrum exits.
+func AddNotifier(n Notifier) {
+	HookRunEnd()
+	notifiers = append(notifiers, n)
+}
+
+// HookRunEnd hooks into logging so a fatal error ends the run with its
+// cause, the run history needs this even without notifiers.
+func HookRunEnd() {
+	if !hooked {
+		hooked = true
+		logrus.AddHook(notifyHook{})
+	}
+}
+
+// NotifyRunEnd adds the run to the run history and tells every notifier how
+// it went, err is nil on success.
+func NotifyRunEnd(err error) error {
+	if notified {
+		return nil
+	}
+	notified = true
+
+	summary := CurrentRunSummary(err)
+
+	var failed []error
+	if notifyState != nil {
+		summary.Checkpoint = notifyState.Saved()
+		if err := notifyState.RecordRun(summary); err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	for _, n := range notifiers {
+		if err := n.Notify(summary); err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("recording the end of the run: %v", failed)
+	}
+	return nil
+}
+
+// CurrentRunSummary describes the run so far, err is nil on success.
+func CurrentRunSummary(err error) RunSummary {
+	stats.mu.Lock()
+	summary := RunSummary{
+		Endpoint:  notifyEndpoint,
+		StartedAt: stats.Start.UTC(),
+		Status:    "succeeded",
+		Records:   stats.Records,
+		Requests:  stats.Requests,
+		Errors:    loggedErrors,
+		Duration:  time.Since(stats.Start).Round(time.Second).String(),
+	}
+	stats.mu.Unlock()
+
+	if err != nil {
+		summary.Status = "failed"
+		summary.Error = err.Error()
+	}
+	return summary
+}
+
+// notifyHook counts logged errors for the summary and sends the failure
+// notification on a fatal error. It must not log itself.
+type notifyHook struct{}
+
+func (notifyHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel, logrus.FatalLevel}
+}
+
+func (notifyHook) Fire(entry *logrus.Entry) error {
+	if entry.Level == logrus.ErrorLevel {
+		loggedErrors++
+		return nil
+	}
+
+	cause := entry.Message
+	for k, v := range entry.Data {
+		cause += fmt.Sprintf(" %s=%v", k, v)
+	}
+	return NotifyRunEnd(errors.New(cause))
+}
+
+// SlackNotifier posts to a slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+func (s *SlackNotifier) Notify(summary RunSummary) error {
+	text := fmt.Sprintf("fulcrum %s %s: %d records, %d requests, %d errors in %s",
+		summary.Endpoint, summary.Status, summary.Records, summary.Requests, summary.Errors, summary.Duration)
+
+	if summary.Error != "" {
+		text = fmt.Sprintf(":rotating_light: %s\n>%s", text, summary.Error)
+	}
+
+	if cp := summary.Checkpoint; cp != nil {
+		text += fmt.Sprintf("\ncheckpoint: lastId=%s lastRow=%d cursor=%s", cp.LastID, cp.LastRow, cp.Cursor)
+	} else {
+		text += "\ncheckpoint: none"
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(s.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// OfferFunnelRow is one application with its latest offer and outcome, for
+// time to offer and acceptance rate reporting.
+type OfferFunnelRow struct {
+	CandidateID     string   `json:"candidateId"`
+	CandidateName   string   `json:"candidateName"`
+	ApplicationID   string   `json:"applicationId"`
+	PostingID       string   `json:"postingId"`
+	PostingText     string   `json:"postingText"`
+	AppliedAt       int      `json:"appliedAt"`
+	OfferID         string   `json:"offerId"`
+	OfferStatus     string   `json:"offerStatus"`
+	OfferCreatedAt  int      `json:"offerCreatedAt"`
+	OfferApprovedAt int      `json:"offerApprovedAt"`
+	OfferSentAt     int      `json:"offerSentAt"`
+	FirstOfferAt    int      `json:"firstOfferAt"`
+	Offers          int      `json:"offers"`
+	Accepted        bool     `json:"accepted"`
+	DaysToOffer     *float64 `json:"daysToOffer"`
+	ArchivedAt      int      `json:"archivedAt"`
+	ArchiveReason   string   `json:"archiveReason"`
+	Outcome         string   `json:"outcome"`
+}
+
+func init() {
+	RegisterAnalysis(Command{
+		Name:        "offer-funnel",
+		Description: "Link applications to their offers and hired or archived outcomes in one flat table",
+		Run:         runOfferFunnel,
+	})
+}
+
+func runOfferFunnel(args []string) error {
+	flags := NewCommandFlags("offer-funnel")
+	applicationsPath := flags.String("applications", "", "Applications export")
+	offersPath := flags.String("offers", "", "Offers export for the same candidates")
+	reasonsPath := flags.String("archived-reasons", "", "Archived reasons export, telling hires from other archives")
+	candidatesPath := flags.String("candidates", "", "Candidates export to name candidates with")
+	flags.Parse(args)
+
+	if *applicationsPath == "" || *offersPath == "" || *reasonsPath == "" {
+		return errors.New("offer-funnel needs --applications, --offers and --archived-reasons exports")
+	}
+
+	lookups := &Resolver{}
+	if err := lookups.ReadArchiveReasons(*reasonsPath); err != nil {
+		return err
+	}
+
+	names := map[string]string{}
+	if *candidatesPath != "" {
+		err := ReadRecords(*candidatesPath, func(record json.RawMessage) error {
+			var candidate Candidate
+			if err := json.Unmarshal(record, &candidate); err != nil {
+				return err
+			}
+			names[candidate.ID] = candidate.Name
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	offers := map[string][]Offer{}
+	err := ReadRecords(*offersPath, func(record json.RawMessage) error {
+		var offer Offer
+		if err := json.Unmarshal(record, &offer); err != nil {
+			return err
+		}
+		offers[offer.CandidateID] = append(offers[offer.CandidateID], offer)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	applications := map[string][]Application{}
+	var candidateIDs []string
+	err = ReadRecords(*applicationsPath, func(record json.RawMessage) error {
+		var application Application
+		if err := json.Unmarshal(record, &application); err != nil {
+			return err
+		}
+
+		if _, ok := applications[application.CandidateID]; !ok {
+			candidateIDs = append(candidateIDs, application.CandidateID)
+		}
+		applications[application.CandidateID] = append(applications[application.CandidateID], application)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, offered, accepted := 0, 0, 0
+	for _, candidateID := range candidateIDs {
+		for _, row := range OfferFunnelRows(lookups, applications[candidateID], offers[candidateID]) {
+			row.CandidateName = names[candidateID]
+			Output(row, enc)
+
+			rows++
+			if row.OfferID != "" {
+				offered++
+			}
+			if row.Accepted {
+				accepted++
+			}
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{"applications": rows, "offered": offered, "accepted": accepted}).Info("Built offer funnel")
+	return nil
+}
+
+// OfferFunnelRows links a candidate's offers to their applications by
+// posting. Offers without a posting belong to the candidate's only
+// application, when they have just one.
+func OfferFunnelRows(lookups *Resolver, applications []Application, offers []Offer) []OfferFunnelRow {
+	sort.SliceStable(offers, func(a, b int) bool {
+		return offers[a].CreatedAt < offers[b].CreatedAt
+	})
+
+	rows := make([]OfferFunnelRow, 0, len(applications))
+	for _, application := range applications {
+		row := OfferFunnelRow{
+			CandidateID:   application.CandidateID,
+			ApplicationID: application.ID,
+			PostingID:     application.Posting,
+			PostingText:   application.PostingText,
+			AppliedAt:     application.CreatedAt,
+			ArchivedAt:    application.Archived.ArchivedAt,
+		}
+		row.ArchiveReason, row.Outcome = lookups.Outcome(application.Archived)
+
+		for _, offer := range offers {
+			if offer.Posting != application.Posting && (offer.Posting != "" || len(applications) > 1) {
+				continue
+			}
+
+			if row.Offers == 0 {
+				row.FirstOfferAt = offer.CreatedAt
+			}
+
+			// The latest offer is the one that was accepted or not
+			row.Offers++
+			row.OfferID = offer.ID
+			row.OfferStatus = offer.Status
+			row.OfferCreatedAt = offer.CreatedAt
+			row.OfferApprovedAt = offer.ApprovedAt
+			row.OfferSentAt = offer.SentAt
+			row.Accepted = offer.Status == "signed"
+		}
+
+		if row.Offers > 0 && row.AppliedAt != 0 && row.FirstOfferAt >= row.AppliedAt {
+			days := float64(row.FirstOfferAt-row.AppliedAt) / float64(24*time.Hour/time.Millisecond)
+			days = math.Round(days*100) / 100
+			row.DaysToOffer = &days
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// outputBufferSize is where BenchmarkOutputBufferSize stops getting faster
+// with larger buffers, while keeping little unflushed data around between
+// checkpoints.
+var outputBufferSize = 64 * 1024
+
+// OutputSink is where encoded records are written. Output is buffered so
+// sinks must be flushed before a checkpoint is written and at shutdown.
+type OutputSink interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+var sink OutputSink = NewStdoutSink()
+
+// FlushOutput makes sure everything encoded so far has reached the sink
+// and any routed sinks.
+func FlushOutput() {
+	if err := sink.Flush(); err != nil {
+		logrus.Fatal("Unable to flush output: ", err)
+	}
+
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+	for _, route := range routes {
+		if err := route.sink.Flush(); err != nil {
+			logrus.Fatal("Unable to flush output: ", err)
+		}
+	}
+}
+
+// routes send the records of a resource to a sink of their own, so
+// endpoints exported concurrently each write their own file. Records of
+// other resources go to the encoder Output is given.
+var (
+	routesMu sync.RWMutex
+	routes   = map[string]*route{}
+)
+
+type route struct {
+	sink    *lockedSink
+	encoder *json.Encoder
+}
+
+// lockedSink lets a checkpoint of one endpoint flush the sink another
+// endpoint is writing to.
+type lockedSink struct {
+	mu sync.Mutex
+	OutputSink
+}
+
+func (s *lockedSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.OutputSink.Write(p)
+}
+
+func (s *lockedSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.OutputSink.Flush()
+}
+
+// Route sends the records of resource to s until the returned func is
+// called. Closing s is left to the caller.
+func Route(resource string, s OutputSink) (unroute func()) {
+	locked := &lockedSink{OutputSink: s}
+
+	routesMu.Lock()
+	routes[resource] = &route{sink: locked, encoder: NewEncoder(locked)}
+	routesMu.Unlock()
+
+	return func() {
+		routesMu.Lock()
+		delete(routes, resource)
+		routesMu.Unlock()
+	}
+}
+
+// routedEncoder is the encoder for records of resource.
+func routedEncoder(resource string, encoder *json.Encoder) *json.Encoder {
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+	if route, ok := routes[resource]; ok {
+		return route.encoder
+	}
+	return encoder
+}
+
+// SetSink replaces the output sink records are encoded to.
+func SetSink(s OutputSink) {
+	sink = s
+	enc = NewEncoder(sink)
+	sinkClosed = false
+}
+
+// sinkClosed is set once CloseOutput has closed the sink.
+var sinkClosed bool
+
+// CloseOutput closes the sink, finishing off its output. Later calls do
+// nothing, so a run that stops early can close it before the exit handlers
+// run.
+func CloseOutput() error {
+	if sinkClosed {
+		return nil
+	}
+	sinkClosed = true
+	return sink.Close()
+}
+
+// outputFormat is how records are laid out in a sink: ndjson writes one json
+// document per line, json-array writes a single json array.
+var outputFormat = "ndjson"
+
+var outputFormats = map[string]bool{
+	"ndjson":     true,
+	"json-array": true,
+}
+
+// Framer lays out encoded records in a single output file according to
+// outputFormat. A new Framer is needed for each file.
+type Framer struct {
+	format  string
+	records int
+}
+
+func NewFramer() *Framer {
+	return &Framer{format: outputFormat}
+}
+
+func (f *Framer) Write(w io.Writer, record []byte) (int, error) {
+	if f.format == "json-array" {
+		prefix := ",\n"
+		if f.records == 0 {
+			prefix = "[\n"
+		}
+
+		if _, err := io.WriteString(w, prefix); err != nil {
+			return 0, err
+		}
+		record = []byte(strings.TrimSuffix(string(record), "\n"))
+	}
+
+	f.records++
+	return w.Write(record)
+}
+
+// Finish writes anything needed to close off the file.
+func (f *Framer) Finish(w io.Writer) error {
+	if f.format != "json-array" {
+		return nil
+	}
+
+	closing := "\n]\n"
+	if f.records == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(w, closing)
+	return err
+}
+
+type stdoutSink struct {
+	*bufio.Writer
+	framer *Framer
+}
+
+func NewStdoutSink() OutputSink {
+	return &stdoutSink{Writer: bufio.NewWriterSize(os.Stdout, outputBufferSize)}
+}
+
+func (s *stdoutSink) Write(p []byte) (int, error) {
+	if s.framer == nil {
+		s.framer = NewFramer()
+	}
+	return s.framer.Write(s.Writer, p)
+}
+
+func (s *stdoutSink) Close() error {
+	if s.framer == nil {
+		s.framer = NewFramer()
+	}
+
+	if err := s.framer.Finish(s.Writer); err != nil {
+		return err
+	}
+	s.framer = nil
+	return s.Flush()
+}
+
+// RotatingFile writes output records to a file, starting a new numbered part
+// file once the current one reaches the size or record limit. Each Write is
+// expected to be one whole record, which is how json.Encoder writes.
+type RotatingFile struct {
+	Path       string
+	MaxBytes   int64
+	MaxRecords int
+	Parts      []string
+
+	// PartRecords counts the records written to each of Parts.
+	PartRecords []int
+
+	// PartName names numbered part files when set, parts are otherwise named
+	// after Path.
+	PartName func(part int) string
+
+	// Append continues the output of an interrupted run instead of starting
+	// over: the file is appended to and numbered parts follow those already
+	// on disk.
+	Append bool
+
+	existing int
+	file     *os.File
+	buf      *bufio.Writer
+	framer   *Framer
+	bytes    int64
+	records  int
+}
+
+func NewRotatingFile(path string, maxBytes int64, maxRecords int) *RotatingFile {
+	return &RotatingFile{Path: path, MaxBytes: maxBytes, MaxRecords: maxRecords}
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	if r.file == nil || r.full(len(p)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.framer.Write(r.buf, p)
+	r.bytes += int64(n)
+	r.records++
+	r.PartRecords[len(r.PartRecords)-1]++
+	return n, err
+}
+
+func (r *RotatingFile) Flush() error {
+	if r.buf == nil {
+		return nil
+	}
+	return r.buf.Flush()
+}
+
+func (r *RotatingFile) Close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	if err := r.framer.Finish(r.buf); err != nil {
+		r.file.Close()
+		return err
+	}
+
+	if err := r.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	r.buf = nil
+	return err
+}
+
+// full reports if writing another record would go over a limit. A record is
+// never split so an empty part always accepts one.
+func (r *RotatingFile) full(next int) bool {
+	if r.records == 0 {
+		return false
+	}
+
+	if r.MaxRecords > 0 && r.records >= r.MaxRecords {
+		return true
+	}
+	return r.MaxBytes > 0 && r.bytes+int64(next) > r.MaxBytes
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	path := r.Path
+	switch {
+	case r.PartName != nil:
+		path = r.PartName(len(r.Parts) + 1)
+	case r.MaxBytes > 0 || r.MaxRecords > 0:
+		if r.Append && len(r.Parts) == 0 {
+			existing, err := filepath.Glob(partGlob(r.Path))
+			if err != nil {
+				return err
+			}
+			r.existing = len(existing)
+		}
+		path = partPath(r.Path, r.existing+len(r.Parts)+1)
+	}
+
+	f, err := r.open(path)
+	if err != nil {
+		return err
+	}
+
+	r.file = f
+	r.buf = bufio.NewWriterSize(f, outputBufferSize)
+	r.framer = NewFramer()
+	r.bytes = 0
+	r.records = 0
+	r.Parts = append(r.Parts, path)
+	r.PartRecords = append(r.PartRecords, 0)
+	return nil
+}
+
+// open creates the file at path, or when appending to the one file output
+// goes to, opens it at the end. A json array can't be continued that way.
+func (r *RotatingFile) open(path string) (*os.File, error) {
+	if !r.Append || path != r.Path {
+		return os.Create(path)
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 && outputFormat == "json-array" {
+		return nil, fmt.Errorf("can't resume writing %s, a json-array file can't be appended to, rerun with ndjson output or remove the checkpoint to start over", path)
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// partPath numbers a file, out/candidates.json becomes
+// out/candidates.part-00001.json.
+func partPath(path string, part int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.part-%05d%s", strings.TrimSuffix(path, ext), part, ext)
+}
+
+// partGlob matches the numbered parts of path.
+func partGlob(path string) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.part-*%s", strings.TrimSuffix(path, ext), ext)
+}
+
+// ParseByteSize parses sizes such as 512MB or 2GB. Units are powers of 1024.
+func ParseByteSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("unable to parse size %q, expected a value like 512MB", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkOutputBufferSize writes candidate sized records to a file through
+// buffers of different sizes, outputBufferSize should sit where larger
+// buffers stop paying off.
+func BenchmarkOutputBufferSize(b *testing.B) {
+	record := append(bytes.Repeat([]byte("x"), 2048), '\n')
+	defer func(size int) { outputBufferSize = size }(outputBufferSize)
+
+	for _, size := range []int{4 << 10, 16 << 10, 64 << 10, 256 << 10} {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "fulcrum-bench-")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			outputBufferSize = size
+			f := NewRotatingFile(filepath.Join(dir, "candidates.json"), 0, 0)
+			b.SetBytes(int64(len(record)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := f.Write(record); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := f.Close(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
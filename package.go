@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Package bundles everything a run wrote, with its manifest and summary,
+// into one timestamped zip, e.g. to hand over as a disclosure package. With
+// a Password the entries are AES encrypted.
+type Package struct {
+	Dir      string
+	Password string
+
+	paths []string
+}
+
+// runPackage is nil unless --package is used.
+var runPackage *Package
+
+// Add includes a file, or every file under a directory, in the package.
+// Empty paths are ignored.
+func (p *Package) Add(paths ...string) {
+	if p == nil {
+		return
+	}
+	for _, path := range paths {
+		if path != "" {
+			p.paths = append(p.paths, path)
+		}
+	}
+}
+
+// Write creates the zip in Dir and returns its path. Entries keep their
+// paths relative to the working directory.
+func (p *Package) Write(endpoint string, summary RunSummary) (string, error) {
+	if err := os.MkdirAll(p.Dir, 0755); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("fulcrum-%s-%s.zip", endpoint, time.Now().UTC().Format("20060102T150405Z"))
+	path := filepath.Join(p.Dir, name)
+
+	// Written under a temporary name so a half written package is never
+	// mistaken for a complete one
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.write(f, summary); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+func (p *Package) write(w io.Writer, summary RunSummary) error {
+	archive := zip.NewWriter(w)
+	if p.Password != "" {
+		archive.RegisterCompressor(zipMethodAES, zipAESCompressor(p.Password))
+	}
+
+	entries := 0
+	for _, root := range p.paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			// Outputs the run never got to write are left out
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			if err != nil || info.IsDir() {
+				return err
+			}
+			entries++
+			return p.addFile(archive, path, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	entry, err := archive.CreateHeader(p.header("summary.json", int64(len(data)), time.Now()))
+	if err != nil {
+		return err
+	}
+	if _, err := entry.Write(data); err != nil {
+		return err
+	}
+
+	logrus.WithField("files", entries).Debug("Packaged output")
+	return archive.Close()
+}
+
+func (p *Package) addFile(archive *zip.Writer, path string, info os.FileInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entry, err := archive.CreateHeader(p.header(entryName(path), info.Size(), info.ModTime()))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, f)
+	return err
+}
+
+func (p *Package) header(name string, size int64, modified time.Time) *zip.FileHeader {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified.UTC()}
+	header.UncompressedSize64 = uint64(size)
+	if p.Password != "" {
+		header.Method = zipMethodAES
+		header.Extra = zipAESExtra
+		header.Flags |= 0x1 // encrypted
+	}
+	return header
+}
+
+// entryName is the path inside the zip, relative and without any leading
+// ../ so unzipping can't write outside the target directory.
+func entryName(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				return filepath.ToSlash(rel)
+			}
+		}
+		path = abs
+	}
+
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	kept := parts[:0]
+	for _, part := range parts {
+		if part != "" && part != "." && part != ".." {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "/")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+var pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyNotifier triggers a PagerDuty incident when a run fails. Events
+// share a dedup key per endpoint and day so retries of a scheduled export
+// don't page twice.
+type PagerDutyNotifier struct {
+	RoutingKey string
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string      `json:"summary"`
+	Source        string      `json:"source"`
+	Severity      string      `json:"severity"`
+	CustomDetails interface{} `json:"custom_details,omitempty"`
+}
+
+func (p *PagerDutyNotifier) Notify(summary RunSummary) error {
+	if summary.Status != "failed" {
+		return nil
+	}
+	return p.Trigger(fmt.Sprintf("fulcrum %s failed: %s", summary.Endpoint, summary.Error), summary)
+}
+
+// WatchSLA triggers an incident if the run is still going after sla. The
+// returned timer should be stopped when the run ends.
+func (p *PagerDutyNotifier) WatchSLA(endpoint string, sla time.Duration) *time.Timer {
+	return time.AfterFunc(sla, func() {
+		// The run goes on, there is nowhere to report a failed page but stderr
+		if err := p.Trigger(fmt.Sprintf("fulcrum %s still running after %s", endpoint, sla), nil); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to trigger PagerDuty event:", err)
+		}
+	})
+}
+
+func (p *PagerDutyNotifier) Trigger(summary string, details interface{}) error {
+	event := pagerDutyEvent{
+		RoutingKey:  p.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    fmt.Sprintf("fulcrum-%s-%s", notifyEndpoint, time.Now().UTC().Format("2006-01-02")),
+		Payload: pagerDutyPayload{
+			Summary:       summary,
+			Source:        "fulcrum",
+			Severity:      "error",
+			CustomDetails: details,
+		},
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(pagerDutyEventsURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("pagerduty returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+)
+
+// PerCandidateSink writes the records of each candidate to their own file,
+// <dir>/<candidateID>/<type>.json, so everything about one candidate can be
+// handed over together, e.g. for a legal hold.
+type PerCandidateSink struct {
+	Dir      string
+	Resource string
+
+	file   *os.File
+	buf    *bufio.Writer
+	framer *Framer
+}
+
+// candidateSink is set with --per-candidate-dir, DownloadUsingList switches
+// it to each candidate before writing their records.
+var candidateSink *PerCandidateSink
+
+func NewPerCandidateSink(dir, resource string) *PerCandidateSink {
+	return &PerCandidateSink{Dir: dir, Resource: resource}
+}
+
+// Candidate closes the previous candidate's file and starts writing to the
+// candidate's. A candidate resumed part way through their pages is appended
+// to instead of starting over, which only ndjson files can be.
+func (s *PerCandidateSink) Candidate(candidateID string, resumed bool) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.Dir, candidateID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resumed {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, s.Resource+".json"), flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.file = f
+	s.buf = bufio.NewWriterSize(f, outputBufferSize)
+	s.framer = NewFramer()
+	return nil
+}
+
+func (s *PerCandidateSink) Write(p []byte) (int, error) {
+	return s.framer.Write(s.buf, p)
+}
+
+func (s *PerCandidateSink) Flush() error {
+	if s.buf == nil {
+		return nil
+	}
+	return s.buf.Flush()
+}
+
+func (s *PerCandidateSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	if err := s.framer.Finish(s.buf); err != nil {
+		s.file.Close()
+		return err
+	}
+
+	if err := s.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	s.buf = nil
+	return err
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// pipelineDepth is how many pages each stage of the download pipeline may
+// get ahead of the next, bounding the records held in memory.
+var pipelineDepth = 4
+
+// pipelinePage is a page of an endpoint on its way through the download
+// pipeline. It is fetched from lever, its records decoded and annotated,
+// then transformed, and finally written to the sink where progress is
+// checkpointed. The stages run concurrently so waiting on lever overlaps
+// with decoding and writing earlier pages.
+type pipelinePage struct {
+	// CandidateID is empty for top level endpoints.
+	CandidateID string
+	// First is set on the first page fetched for the candidate, Resumed
+	// when that page continues from a saved cursor.
+	First   bool
+	Resumed bool
+	// Cursor and HasNext point at the page after this one.
+	Cursor  string
+	HasNext bool
+
+	Data    json.RawMessage
+	Records interface{}
+	Output  []OutputRecord
+	Err     error
+}
+
+// pipelineStage runs fn on every page from in, in order, on its own
+// goroutine. A failed page is passed on untouched and ends the stage, so
+// nothing after a failure is transformed without being written. A panic in
+// fn fails the page.
+func pipelineStage(in <-chan *pipelinePage, done <-chan struct{}, fn func(page *pipelinePage) error) <-chan *pipelinePage {
+	out := make(chan *pipelinePage, pipelineDepth)
+	go func() {
+		defer close(out)
+		for page := range in {
+			if page.Err == nil {
+				page.Err = runStage(fn, page)
+			}
+
+			// The page belongs to the next stage once sent
+			failed := page.Err != nil
+			select {
+			case out <- page:
+			case <-done:
+				return
+			}
+
+			if failed {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func runStage(fn func(page *pipelinePage) error, page *pipelinePage) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = stagePanic(recovered)
+		}
+	}()
+	return fn(page)
+}
+
+// stagePanic turns a panic on a pipeline goroutine into an error. The
+// process' deferred panic reporting only covers the main goroutine, so the
+// panic instead reaches the sink stage as a failed page and fails the run
+// like any other error.
+func stagePanic(recovered interface{}) error {
+	stack := make([]byte, 64<<10)
+	stack = stack[:runtime.Stack(stack, false)]
+	logrus.WithField("stack", string(stack)).Error("Download pipeline panicked")
+	return fmt.Errorf("download pipeline panicked: %v", recovered)
+}
+
+// runPipeline downloads the pages fetch sends and writes their records.
+// written runs on the calling goroutine once a page's records are in the
+// sink, or with the page's Err set when a stage failed, and stops the
+// pipeline by returning an error.
+func runPipeline(endpoint Endpoint, fetch func(out chan<- *pipelinePage, done <-chan struct{}), written func(page *pipelinePage) error) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	fetched := make(chan *pipelinePage, pipelineDepth)
+	go func() {
+		defer close(fetched)
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				select {
+				case fetched <- &pipelinePage{Err: stagePanic(recovered)}:
+				case <-done:
+				}
+			}
+		}()
+		fetch(fetched, done)
+	}()
+
+	decoded := pipelineStage(fetched, done, func(page *pipelinePage) error {
+		records, err := decodeRecords(endpoint.Type, page.CandidateID, page.Data)
+		page.Records = records
+		page.Data = nil
+		return err
+	})
+
+	transformed := pipelineStage(decoded, done, func(page *pipelinePage) error {
+		page.Output = TransformList(page.Records)
+		return nil
+	})
+
+	for page := range transformed {
+		if page.Err == nil {
+			if page.First && candidateSink != nil {
+				if err := candidateSink.Candidate(page.CandidateID, page.Resumed); err != nil {
+					return err
+				}
+			}
+
+			for _, record := range page.Output {
+				record.Encode(enc)
+			}
+
+			if resumes, ok := page.Records.([]Resume); ok {
+				resumeTables.Write(resumes)
+			}
+		}
+
+		if err := written(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchPages sends the pages of endpoint, starting at cursor, reporting
+// false when the pipeline stopped or a request failed.
+func fetchPages(endpoint Endpoint, candidateID, cursor string, out chan<- *pipelinePage, done <-chan struct{}) bool {
+	endpoint.Cursor = cursor
+	endpoint.Page = 0
+	if candidateID != "" {
+		endpoint.Arguments = []interface{}{candidateID}
+	}
+
+	for first := true; ; first = false {
+		var leverData LeverData
+		err := ExecuteLeverRequest(&endpoint, &leverData)
+
+		page := &pipelinePage{
+			CandidateID: candidateID,
+			First:       first,
+			Resumed:     cursor != "",
+			Cursor:      endpoint.Cursor,
+			HasNext:     endpoint.HasNext,
+			Data:        leverData.Data,
+			Err:         err,
+		}
+
+		select {
+		case out <- page:
+		case <-done:
+			return false
+		}
+
+		if err != nil {
+			return false
+		}
+		if !endpoint.HasNext {
+			return true
+		}
+	}
+}
+
+// fetchCandidatePages fetches the pages of every candidate in the list that
+// belongs to this shard and wasn't already exported, going by the last id
+// and cursor saved before the pipeline started.
+func fetchCandidatePages(endpoint Endpoint, r *csv.Reader, saved checkpointFile, out chan<- *pipelinePage, done <-chan struct{}) {
+	reached := saved.LastID == ""
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			select {
+			case out <- &pipelinePage{Err: fmt.Errorf("reading the candidate list: %v", err)}:
+			case <-done:
+			}
+			return
+		}
+
+		candidateID := record[0]
+		if !shard.Contains(candidateID) || unknownCandidates[candidateID] {
+			continue
+		}
+
+		// The last candidate checkpointed is exported again in case the run
+		// stopped before all of their records were flushed
+		if !reached && candidateID == saved.LastID {
+			reached = true
+		}
+		if !reached {
+			continue
+		}
+
+		cursor := ""
+		if saved.Cursor != "" && saved.CursorID == candidateID {
+			if candidateSink != nil && outputFormat == "json-array" {
+				// Their file can't be appended to and stay one array, so it
+				// is written again from their first page
+				logrus.WithField("candidateId", candidateID).Info("Exporting the candidate again rather than resuming their json array")
+			} else {
+				logrus.Info("Resuming from saved cursor ", saved.Cursor)
+				cursor = saved.Cursor
+			}
+		}
+
+		if !fetchPages(endpoint, candidateID, cursor, out, done) {
+			return
+		}
+	}
+}
+
+// decodeRecords decodes a page of an endpoint into its record type and
+// annotates the records. Pages fetched for a candidate are linked back to
+// them, and with --files-dir their files are archived here so downloads
+// also overlap with writing.
+func decodeRecords(resource, candidateID string, data json.RawMessage) (interface{}, error) {
+	switch resource {
+	case "users":
+		var users []User
+		err := json.Unmarshal(data, &users)
+		return users, err
+	case "archivedReasons":
+		var reasons []ArchiveReason
+		err := json.Unmarshal(data, &reasons)
+		return reasons, err
+	case "postings":
+		var postings []Posting
+		err := json.Unmarshal(data, &postings)
+		return postings, err
+	case "stages":
+		var stages []Stage
+		err := json.Unmarshal(data, &stages)
+		return stages, err
+	case "candidates":
+		var candidates []Candidate
+		if candidateID != "" {
+			// A single candidate rather than a page of them
+			var candidate Candidate
+			if err := json.Unmarshal(data, &candidate); err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, candidate)
+		} else if err := json.Unmarshal(data, &candidates); err != nil {
+			return nil, err
+		}
+
+		resolver.AnnotateCandidates(candidates)
+		return candidates, nil
+	case "interviews":
+		var interviews []Interview
+		if err := json.Unmarshal(data, &interviews); err != nil {
+			return nil, err
+		}
+
+		resolver.AnnotateInterviews(interviews)
+		for i := range interviews {
+			if detectLanguages {
+				interviews[i].DetectLanguage()
+			}
+		}
+
+		SetCandidateID(interviews, candidateID)
+		return interviews, nil
+	case "feedback":
+		var feedback []Feedback
+		if err := json.Unmarshal(data, &feedback); err != nil {
+			return nil, err
+		}
+
+		resolver.AnnotateFeedback(feedback)
+		for i := range feedback {
+			if extractScores {
+				feedback[i].ExtractScore()
+			}
+			if detectLanguages {
+				feedback[i].DetectLanguage()
+			}
+		}
+
+		SetCandidateID(feedback, candidateID)
+		return feedback, nil
+	case "resumes":
+		var resumes []Resume
+		if err := json.Unmarshal(data, &resumes); err != nil {
+			return nil, err
+		}
+
+		SetCandidateID(resumes, candidateID)
+		if extractResumeText {
+			if err := AttachResumeText(resumes); err != nil {
+				return nil, err
+			}
+		}
+		return resumes, nil
+	case "surveys":
+		var surveys []Survey
+		if err := json.Unmarshal(data, &surveys); err != nil {
+			return nil, err
+		}
+
+		SetCandidateID(surveys, candidateID)
+		return surveys, nil
+	case "referrals":
+		var referrals []Referral
+		if err := json.Unmarshal(data, &referrals); err != nil {
+			return nil, err
+		}
+
+		resolver.AnnotateReferrals(referrals)
+		SetCandidateID(referrals, candidateID)
+		return referrals, nil
+	case "offers":
+		var offers []Offer
+		if err := json.Unmarshal(data, &offers); err != nil {
+			return nil, err
+		}
+
+		SetCandidateID(offers, candidateID)
+		return offers, nil
+	case "applications":
+		var applications []Application
+		if err := json.Unmarshal(data, &applications); err != nil {
+			return nil, err
+		}
+
+		resolver.AnnotateApplications(applications)
+		SetCandidateID(applications, candidateID)
+		return applications, nil
+	case "files":
+		var files []CandidateFile
+		if err := json.Unmarshal(data, &files); err != nil {
+			return nil, err
+		}
+
+		SetCandidateID(files, candidateID)
+		return ArchiveFiles(FilterFiles(files))
+	}
+	return nil, fmt.Errorf("unknown endpoint type: %s", resource)
+}
+
+// TransformList runs Transform over a slice of records. Those it drops are
+// only kept when writing them still changes the run's state.
+func TransformList(v interface{}) []OutputRecord {
+	rv := reflect.ValueOf(v)
+	records := make([]OutputRecord, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		if record, ok := Transform(rv.Index(i).Interface()); ok || len(record.commits) > 0 {
+			records = append(records, record)
+		}
+	}
+	return records
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecodeRecordsCandidates(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/candidates.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := decodeRecords("candidates", "", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candidates, ok := records.([]Candidate); !ok || len(candidates) != 2 || candidates[0].Owner == "" {
+		t.Errorf("decoded %+v, want the page's two candidates", records)
+	}
+}
+
+func TestDecodeRecordsSingleCandidate(t *testing.T) {
+	data := []byte(`{"id":"250d8f03","name":"Shane Smith","owner":"df0a4d4e","phones":[{"type":"home","value":"555 0100"}]}`)
+
+	records, err := decodeRecords("candidates", "250d8f03", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := records.([]Candidate)
+	if len(candidates) != 1 || candidates[0].Owner != "df0a4d4e" || candidates[0].Phones[0].Value != "555 0100" {
+		t.Errorf("decoded %+v", candidates)
+	}
+}
+
+func TestDecodeRecordsUnknownEndpoint(t *testing.T) {
+	if _, err := decodeRecords("nonsense", "", []byte("[]")); err == nil {
+		t.Error("an unknown endpoint should fail")
+	}
+}
+
+func TestPipelineStagePanic(t *testing.T) {
+	in := make(chan *pipelinePage, 2)
+	in <- &pipelinePage{CandidateID: "a"}
+	in <- &pipelinePage{CandidateID: "b"}
+	close(in)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	out := pipelineStage(in, done, func(page *pipelinePage) error {
+		var records []Candidate
+		_ = records[len(page.CandidateID)]
+		return nil
+	})
+
+	page := <-out
+	if page.CandidateID != "a" || page.Err == nil {
+		t.Errorf("got page %q with error %v, want a's page to fail", page.CandidateID, page.Err)
+	}
+	if page, ok := <-out; ok {
+		t.Errorf("got page %q after a failed page", page.CandidateID)
+	}
+}
+
+func TestRunPipelineFetchPanic(t *testing.T) {
+	fetch := func(out chan<- *pipelinePage, done <-chan struct{}) {
+		panic("fetch failed")
+	}
+
+	var failed error
+	err := runPipeline(Endpoint{Type: "candidates"}, fetch, func(page *pipelinePage) error {
+		failed = page.Err
+		return page.Err
+	})
+	if err == nil || failed == nil {
+		t.Errorf("runPipeline returned %v, want the fetch panic as an error", err)
+	}
+}
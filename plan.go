@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Plan is the reviewed set of mutations an upload will make. The plan
+// command writes it and apply executes exactly what it lists.
+type Plan struct {
+	Endpoint  string     `json:"endpoint"`
+	Input     string     `json:"input"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Mutations []Mutation `json:"mutations"`
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "plan",
+		Description: "Work out the changes an upload endpoint would make and write them to a plan file for review",
+		Run:         runPlan,
+	})
+	RegisterCommand(Command{
+		Name:        "apply",
+		Description: "Make exactly the changes listed in a plan file",
+		Run:         runApply,
+	})
+}
+
+func runPlan(args []string) error {
+	flags := NewCommandFlags("plan")
+	endpointName := flags.String("endpoint", "", "Upload endpoint to plan, e.g. uploadTags")
+	input := flags.String("input", "", "Input csv with a candidate id and the endpoint's columns on each row")
+	performAs := flags.String("performAs", "", "Lever user id the changes are made on behalf of")
+	out := flags.String("out", "plan.json", "File to write the plan to")
+	mapPath := flags.String("upload-map", "", "YAML file mapping the columns of an input csv with a header onto the upload's fields")
+	flags.Parse(args)
+	RequireToken()
+
+	endpoint, ok := registeredEndpoints[*endpointName]
+	if !ok || endpoint.Planner == nil {
+		return fmt.Errorf("%q is not an upload endpoint", *endpointName)
+	}
+
+	if *mapPath != "" {
+		var err error
+		if uploadMapping, err = LoadUploadMapping(*mapPath); err != nil {
+			return err
+		}
+	}
+
+	if *performAs != "" {
+		endpoint.QueryParams = append(endpoint.QueryParams, QueryParam{Field: "perform_as", Value: *performAs})
+	}
+
+	plan := Plan{Endpoint: *endpointName, Input: *input, CreatedAt: time.Now().UTC(), Mutations: []Mutation{}}
+	err := PlanRows(endpoint, *input, nil, func(row int, mutations []Mutation) error {
+		for _, mutation := range mutations {
+			LogMutation(mutation)
+		}
+		plan.Mutations = append(plan.Mutations, mutations...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := WritePlan(*out, &plan); err != nil {
+		return err
+	}
+	logrus.Infof("Planned %d changes, review %s and run `fulcrum apply %s`", len(plan.Mutations), *out, *out)
+	return nil
+}
+
+// LogMutation describes a planned mutation for review.
+func LogMutation(mutation Mutation) {
+	logrus.WithFields(logrus.Fields{
+		"row":         mutation.Row,
+		"candidateId": mutation.CandidateID,
+		"before":      mutation.Before,
+		"after":       mutation.After,
+	}).Info("Plan to ", mutation.Op)
+}
+
+func WritePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func runApply(args []string) error {
+	flags := NewCommandFlags("apply")
+	flags.IntVar(&uploadBatchSize, "batch-size", 0, "Pause after this many rows have been written")
+	flags.DurationVar(&uploadBatchDelay, "batch-delay", 0, "How long to pause between batches, e.g. 30s")
+	restart := flags.Bool("restart", false, "Discard the progress of an interrupted apply of a different plan")
+	flags.Parse(args)
+	RequireToken()
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("apply needs the plan file to apply")
+	}
+
+	source, err := PlanSource(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	plan, err := ReadPlan(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Plans list mutations in input row order, an interrupted apply resumes
+	// after the last row it finished. Only the same plan resumes, another
+	// plan's rows would be skipped by number.
+	state := NewCheckpoint("apply_" + plan.Endpoint)
+	resumeRow := state.ResumeRow()
+	if resumeRow > 0 && state.ResumeSource() != source {
+		if !*restart {
+			return fmt.Errorf("an apply of %s stopped after row %d, apply that plan again to finish it or pass --restart to discard its progress", state.ResumeSource(), resumeRow)
+		}
+
+		logrus.Warn("Discarding the progress of an interrupted apply of ", state.ResumeSource())
+		resumeRow = 0
+		state.UpdateLastRow(0)
+	}
+	state.UpdateSource(source)
+
+	if resumeRow > 0 {
+		logrus.Info("Resuming apply after row ", resumeRow)
+	}
+
+	var pacer Pacer
+	for i, mutation := range plan.Mutations {
+		if mutation.Row <= resumeRow {
+			continue
+		}
+
+		if err := ApplyMutation(mutation); err != nil {
+			return fmt.Errorf("applied %d of %d changes, row %d failed: %v", i, len(plan.Mutations), mutation.Row, err)
+		}
+
+		// Only checkpoint once every mutation of the row has been made
+		if i+1 == len(plan.Mutations) || plan.Mutations[i+1].Row != mutation.Row {
+			state.UpdateLastRow(mutation.Row)
+			state.CheckPoint()
+			pacer.Done()
+		}
+	}
+	state.Remove()
+
+	logrus.Infof("Applied %d changes", len(plan.Mutations))
+	return nil
+}
+
+// PlanSource identifies a plan by its absolute path and a hash of its
+// content, so an edited plan doesn't resume as if it were the same.
+func PlanSource(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return abs + "@" + hex.EncodeToString(sum[:8]), nil
+}
+
+func ReadPlan(path string) (*Plan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var plan Plan
+	if err := json.NewDecoder(f).Decode(&plan); err != nil {
+		return nil, fmt.Errorf("reading plan %s: %v", path, err)
+	}
+	return &plan, nil
+}
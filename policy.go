@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// AccessPolicy declares which fields one output destination may receive,
+// keyed by resource type with "*" applying to every type. Paths may be
+// dotted and use lever's field names.
+//
+//	warehouse:
+//	  allow:
+//	    candidates: [id, stage, createdAt]
+//	analysts:
+//	  deny:
+//	    "*": [emails, phones]
+//
+// A destination with an allow list only receives the resource types it
+// lists, anything else is held back rather than written in full.
+type AccessPolicy struct {
+	Allow map[string][]string `yaml:"allow"`
+	Deny  map[string][]string `yaml:"deny"`
+
+	blocked map[string]bool
+}
+
+// accessPolicy is nil unless --policy and --destination are used, a nil
+// policy lets every field through.
+var accessPolicy *AccessPolicy
+
+// LoadAccessPolicy reads the policy file and returns the destination's
+// policy.
+func LoadAccessPolicy(path, destination string) (*AccessPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := map[string]*AccessPolicy{}
+	if err := yaml.UnmarshalStrict(data, &policies); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	policy, ok := policies[destination]
+	if !ok || policy == nil {
+		names := make([]string, 0, len(policies))
+		for name := range policies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%s has no policy for destination %q, it has %s", path, destination, strings.Join(names, ", "))
+	}
+
+	for _, rules := range []map[string][]string{policy.Allow, policy.Deny} {
+		for resource := range rules {
+			if resource == "*" {
+				continue
+			}
+
+			known := false
+			for _, name := range resourceNames {
+				known = known || name == resource
+			}
+			if !known {
+				return nil, fmt.Errorf("%s: %s has a rule for unknown resource type %q", path, destination, resource)
+			}
+		}
+	}
+	policy.blocked = map[string]bool{}
+	return policy, nil
+}
+
+// Apply returns obj with only the fields the destination may receive, or
+// nil when the record must not be written at all.
+func (p *AccessPolicy) Apply(resource string, obj interface{}) interface{} {
+	if p == nil {
+		return obj
+	}
+
+	converted, err := ToRecord(obj)
+	if err != nil {
+		logrus.Error("Holding back a record the access policy can't check: ", err)
+		return nil
+	}
+
+	record, ok := converted.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if len(p.Allow) > 0 {
+		allowed, ok := p.Allow[resource]
+		if !ok {
+			allowed, ok = p.Allow["*"]
+		}
+
+		if !ok {
+			if !p.blocked[resource] {
+				p.blocked[resource] = true
+				logrus.WithField("resource", resource).Warn("The access policy allows no fields of this resource, holding its records back")
+			}
+			return nil
+		}
+
+		kept := map[string]interface{}{}
+		for _, field := range allowed {
+			path := strings.Split(field, ".")
+			if value, ok := LookupField(record, path); ok {
+				setField(kept, path, value)
+			}
+		}
+		record = kept
+	}
+
+	for _, field := range append(p.Deny["*"], p.Deny[resource]...) {
+		deleteField(record, strings.Split(field, "."))
+	}
+	return record
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// feedFormats are the feeds postings publish can render.
+var feedFormats = map[string]bool{
+	"jsonfeed": true,
+	"xml":      true,
+	"jsonld":   true,
+}
+
+// JSONFeed is a JSON Feed 1.1 document, https://jsonfeed.org/version/1.1.
+type JSONFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	Items       []JSONFeedItem `json:"items"`
+}
+
+// JSONFeedItem is a posting in a JSON Feed. The _job extension carries what
+// job boards need beyond an article.
+type JSONFeedItem struct {
+	ID            string      `json:"id"`
+	URL           string      `json:"url,omitempty"`
+	Title         string      `json:"title"`
+	ContentHTML   string      `json:"content_html"`
+	ContentText   string      `json:"content_text"`
+	DatePublished string      `json:"date_published,omitempty"`
+	DateModified  string      `json:"date_modified,omitempty"`
+	Tags          []string    `json:"tags,omitempty"`
+	Job           JSONFeedJob `json:"_job"`
+}
+
+type JSONFeedJob struct {
+	ApplyURL   string `json:"apply_url,omitempty"`
+	Team       string `json:"team,omitempty"`
+	Location   string `json:"location,omitempty"`
+	Commitment string `json:"commitment,omitempty"`
+	Level      string `json:"level,omitempty"`
+	ReqCode    string `json:"reqcode,omitempty"`
+}
+
+// XMLJobFeed is the <source> job feed most job boards crawl.
+type XMLJobFeed struct {
+	XMLName       xml.Name `xml:"source"`
+	Publisher     string   `xml:"publisher"`
+	PublisherURL  string   `xml:"publisherurl,omitempty"`
+	LastBuildDate string   `xml:"lastBuildDate"`
+	Jobs          []XMLJob `xml:"job"`
+}
+
+type XMLJob struct {
+	Title           cdata  `xml:"title"`
+	Date            string `xml:"date"`
+	ReferenceNumber string `xml:"referencenumber"`
+	URL             string `xml:"url"`
+	ApplyURL        string `xml:"applyurl,omitempty"`
+	Company         cdata  `xml:"company"`
+	Location        cdata  `xml:"location"`
+	Category        cdata  `xml:"category"`
+	JobType         cdata  `xml:"jobtype"`
+	Description     cdata  `xml:"description"`
+}
+
+type cdata struct {
+	Text string `xml:",cdata"`
+}
+
+// JobPosting is schema.org JobPosting structured data, what Google for Jobs
+// and Indeed read from a careers page's JSON-LD script tag.
+type JobPosting struct {
+	Context            string          `json:"@context"`
+	Type               string          `json:"@type"`
+	Title              string          `json:"title"`
+	Description        string          `json:"description"`
+	Identifier         JobIdentifier   `json:"identifier"`
+	DatePosted         string          `json:"datePosted,omitempty"`
+	URL                string          `json:"url,omitempty"`
+	EmploymentType     string          `json:"employmentType,omitempty"`
+	HiringOrganization JobOrganization `json:"hiringOrganization"`
+	JobLocation        *JobLocation    `json:"jobLocation,omitempty"`
+	JobLocationType    string          `json:"jobLocationType,omitempty"`
+	DirectApply        bool            `json:"directApply"`
+}
+
+type JobIdentifier struct {
+	Type  string `json:"@type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type JobOrganization struct {
+	Type   string `json:"@type"`
+	Name   string `json:"name"`
+	SameAs string `json:"sameAs,omitempty"`
+}
+
+type JobLocation struct {
+	Type    string `json:"@type"`
+	Address struct {
+		Type            string `json:"@type"`
+		AddressLocality string `json:"addressLocality"`
+	} `json:"address"`
+}
+
+// employmentTypes maps lever commitments onto schema.org employment types.
+var employmentTypes = map[string]string{
+	"full-time":  "FULL_TIME",
+	"full time":  "FULL_TIME",
+	"part-time":  "PART_TIME",
+	"part time":  "PART_TIME",
+	"contract":   "CONTRACTOR",
+	"temporary":  "TEMPORARY",
+	"intern":     "INTERN",
+	"internship": "INTERN",
+}
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "postings",
+		Description: "Work with job postings, postings publish renders published postings into a careers feed",
+		Run:         runPostings,
+	})
+}
+
+func runPostings(args []string) error {
+	if len(args) == 0 || args[0] != "publish" {
+		return errors.New("postings needs a subcommand, publish")
+	}
+	return runPublishPostings(args[1:])
+}
+
+func runPublishPostings(args []string) error {
+	flags := NewCommandFlags("postings publish")
+	format := flags.String("format", "jsonfeed", "Feed to write: jsonfeed, xml or jsonld for an array of schema.org JobPosting objects")
+	out := flags.String("out", "", "File to write the feed to")
+	input := flags.String("postings", "", "Postings export downloaded with --include-content to publish instead of lever")
+	title := flags.String("title", "", "Feed title, the company name job boards show")
+	siteURL := flags.String("site-url", "", "Careers site the feed belongs to")
+	flags.Parse(args)
+
+	if !feedFormats[*format] {
+		return fmt.Errorf("unknown feed format %q, expected jsonfeed, xml or jsonld", *format)
+	}
+
+	if *out == "" {
+		return errors.New("postings publish needs an --out file")
+	}
+
+	// Google for Jobs rejects postings without a hiring organization
+	if *format == "jsonld" && *title == "" {
+		return errors.New("jsonld needs the hiring organization's name as the --title")
+	}
+
+	var postings []Posting
+	if *input != "" {
+		err := ReadRecords(*input, func(record json.RawMessage) error {
+			var posting Posting
+			if err := json.Unmarshal(record, &posting); err != nil {
+				return err
+			}
+			postings = append(postings, posting)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		RequireToken()
+		endpoint := Endpoint{Method: "GET", SprintfPath: "/postings", QueryParams: []QueryParam{
+			{Field: "state", Value: PostingStatePublished},
+			{Field: "distribution_channel", Value: DistributionChannelPublic},
+			{Field: "include", Value: "content"},
+		}}
+		if err := FetchAllFrom(endpoint, &postings); err != nil {
+			return err
+		}
+	}
+
+	postings = PublishedPostings(postings)
+	for _, posting := range postings {
+		if posting.Content == nil {
+			logrus.WithField("posting", posting.ID).Warn("Posting has no content, export postings with --include-content")
+		}
+	}
+
+	var data []byte
+	var err error
+	switch *format {
+	case "jsonfeed":
+		data, err = marshalFeed(NewJSONFeed(*title, *siteURL, postings), false)
+	case "jsonld":
+		data, err = marshalFeed(NewJobPostings(*title, *siteURL, postings), true)
+	case "xml":
+		data, err = xml.MarshalIndent(NewXMLJobFeed(*title, *siteURL, postings, time.Now().UTC()), "", "  ")
+		data = append([]byte(xml.Header), data...)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Sites serve the feed straight from disk, never leave it half written
+	tmp := *out + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"postings": len(postings), "format": *format, "out": *out}).Info("Published postings")
+	return nil
+}
+
+// PublishedPostings keeps the published postings on the public channel,
+// newest first.
+func PublishedPostings(postings []Posting) []Posting {
+	var published []Posting
+	for _, posting := range postings {
+		if posting.State != PostingStatePublished {
+			continue
+		}
+
+		public := len(posting.DistributionChannels) == 0
+		for _, channel := range posting.DistributionChannels {
+			public = public || channel == DistributionChannelPublic
+		}
+
+		if public {
+			published = append(published, posting)
+		}
+	}
+
+	sort.SliceStable(published, func(a, b int) bool {
+		return published[a].CreatedAt > published[b].CreatedAt
+	})
+	return published
+}
+
+func NewJSONFeed(title, siteURL string, postings []Posting) JSONFeed {
+	feed := JSONFeed{Version: "https://jsonfeed.org/version/1.1", Title: title, HomePageURL: siteURL, Items: []JSONFeedItem{}}
+	for _, posting := range postings {
+		feed.Items = append(feed.Items, JSONFeedItem{
+			ID:            posting.ID,
+			URL:           posting.URLs.Show,
+			Title:         posting.Text,
+			ContentHTML:   PostingHTML(posting),
+			ContentText:   PostingText(posting),
+			DatePublished: FormatEpoch(posting.CreatedAt, time.RFC3339),
+			DateModified:  FormatEpoch(posting.UpdatedAt, time.RFC3339),
+			Tags:          posting.Tags,
+			Job: JSONFeedJob{
+				ApplyURL:   posting.URLs.Apply,
+				Team:       posting.Categories.Team,
+				Location:   posting.Categories.Location,
+				Commitment: posting.Categories.Commitment,
+				Level:      posting.Categories.Level,
+				ReqCode:    posting.ReqCode,
+			},
+		})
+	}
+	return feed
+}
+
+func NewXMLJobFeed(title, siteURL string, postings []Posting, built time.Time) XMLJobFeed {
+	feed := XMLJobFeed{Publisher: title, PublisherURL: siteURL, LastBuildDate: built.Format(time.RFC1123Z)}
+	for _, posting := range postings {
+		feed.Jobs = append(feed.Jobs, XMLJob{
+			Title:           cdata{posting.Text},
+			Date:            FormatEpoch(posting.CreatedAt, time.RFC1123Z),
+			ReferenceNumber: posting.ID,
+			URL:             posting.URLs.Show,
+			ApplyURL:        posting.URLs.Apply,
+			Company:         cdata{title},
+			Location:        cdata{posting.Categories.Location},
+			Category:        cdata{posting.Categories.Team},
+			JobType:         cdata{posting.Categories.Commitment},
+			Description:     cdata{PostingHTML(posting)},
+		})
+	}
+	return feed
+}
+
+// NewJobPostings describes each posting as schema.org JobPosting structured
+// data. Remote locations are marked as telecommute jobs.
+func NewJobPostings(organization, siteURL string, postings []Posting) []JobPosting {
+	jobs := []JobPosting{}
+	for _, posting := range postings {
+		job := JobPosting{
+			Context:            "https://schema.org/",
+			Type:               "JobPosting",
+			Title:              posting.Text,
+			Description:        PostingHTML(posting),
+			Identifier:         JobIdentifier{Type: "PropertyValue", Name: organization, Value: posting.ID},
+			DatePosted:         FormatEpoch(posting.CreatedAt, "2006-01-02"),
+			URL:                posting.URLs.Show,
+			EmploymentType:     employmentTypes[strings.ToLower(strings.TrimSpace(posting.Categories.Commitment))],
+			HiringOrganization: JobOrganization{Type: "Organization", Name: organization, SameAs: siteURL},
+		}
+
+		location := strings.TrimSpace(posting.Categories.Location)
+		switch {
+		case strings.Contains(strings.ToLower(location), "remote"):
+			job.JobLocationType = "TELECOMMUTE"
+		case location != "":
+			job.JobLocation = &JobLocation{Type: "Place"}
+			job.JobLocation.Address.Type = "PostalAddress"
+			job.JobLocation.Address.AddressLocality = location
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// marshalFeed indents a json feed. Content is html, so it is kept readable
+// rather than \u003c escaped unless escapeHTML is set, which JSON-LD needs so
+// a </script> in a posting can't close the script tag it is embedded in.
+func marshalFeed(v interface{}, escapeHTML bool) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(escapeHTML)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// PostingHTML is the posting's description, lists and closing as one html
+// document. Lever's list content is already a run of <li> elements.
+func PostingHTML(posting Posting) string {
+	content := posting.Content
+	if content == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(content.DescriptionHTML)
+	for _, list := range content.Lists {
+		fmt.Fprintf(&b, "<h3>%s</h3><ul>%s</ul>", html.EscapeString(list.Text), list.Content)
+	}
+	b.WriteString(content.ClosingHTML)
+	return b.String()
+}
+
+// PostingText is the plain text counterpart of PostingHTML.
+func PostingText(posting Posting) string {
+	content := posting.Content
+	if content == nil {
+		return ""
+	}
+
+	parts := []string{content.Description}
+	for _, list := range content.Lists {
+		parts = append(parts, list.Text)
+	}
+	parts = append(parts, content.Closing)
+
+	var text []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			text = append(text, part)
+		}
+	}
+	return strings.Join(text, "\n\n")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// unknownCandidates are input ids --precheck-ids found lever doesn't know,
+// DownloadUsingList skips them.
+var unknownCandidates map[string]bool
+
+// PrecheckCandidates compares the input candidate ids with lever's, read from
+// a candidates export when known is given or else listed fresh from lever,
+// and writes the ids lever doesn't know to report. Listing every candidate
+// takes a fraction of the requests a per-candidate export spends finding
+// the same ids one 404 at a time.
+func PrecheckCandidates(input, known, report string) (map[string]bool, error) {
+	existing, err := knownCandidateIDs(known)
+	if err != nil {
+		return nil, err
+	}
+
+	r, f, err := OpenCandidateList(input)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out, err := CreateCSV(report, []string{"candidateId", "row"})
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := map[string]bool{}
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+
+		candidateID := record[0]
+		if existing[candidateID] || unknown[candidateID] || !shard.Contains(candidateID) {
+			continue
+		}
+
+		unknown[candidateID] = true
+		if err := out.Write([]string{candidateID, strconv.Itoa(row)}); err != nil {
+			out.Close()
+			return nil, err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	entry := logrus.WithFields(logrus.Fields{"known": len(existing), "unknown": len(unknown), "report": report})
+	if len(unknown) > 0 {
+		entry.Warn("Skipping input candidate ids lever doesn't know")
+	} else {
+		entry.Info("Every input candidate id is known to lever")
+	}
+	return unknown, nil
+}
+
+// knownCandidateIDs lists the ids of every candidate in lever, or in the
+// candidates export at path.
+func knownCandidateIDs(path string) (map[string]bool, error) {
+	ids := map[string]bool{}
+	var record struct {
+		ID string `json:"id"`
+	}
+
+	if path != "" {
+		err := ReadRecords(path, func(data json.RawMessage) error {
+			record.ID = ""
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			ids[record.ID] = true
+			return nil
+		})
+		return ids, err
+	}
+
+	// Only the ids are kept, the rest of each page is dropped as it comes
+	endpoint := Endpoint{Method: "GET", SprintfPath: "/candidates", QueryParams: []QueryParam{{Field: "limit", Value: "100"}}}
+	for {
+		var page LeverData
+		if err := ExecuteLeverRequest(&endpoint, &page); err != nil {
+			return nil, err
+		}
+
+		var records []struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(page.Data, &records); err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			ids[record.ID] = true
+		}
+
+		if !endpoint.HasNext {
+			return ids, nil
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Lever allows 10 requests per second per api key
+var requestRate = time.Second / 10
+
+// RunStats tracks api usage for the quota report printed at the end of a run.
+type RunStats struct {
+	mu            sync.Mutex
+	Start         time.Time
+	Requests      int
+	Throttled     time.Duration
+	RateLimit     int
+	RateRemaining int
+	UnitsDone     int
+	UnitsTotal    int
+	Records       int
+	WireBytes     int64 // response bytes as sent by lever, before decompressing
+	DecodedBytes  int64
+
+	ticker <-chan time.Time
+}
+
+var stats = NewRunStats()
+
+func NewRunStats() *RunStats {
+	return &RunStats{Start: time.Now(), RateRemaining: -1}
+}
+
+// Throttle blocks until the next request is allowed by the rate limit and
+// records how long we waited.
+func (s *RunStats) Throttle() {
+	s.mu.Lock()
+	if s.ticker == nil {
+		s.ticker = time.Tick(requestRate)
+	}
+	ticker := s.ticker
+	s.mu.Unlock()
+
+	start := time.Now()
+	<-ticker
+
+	s.mu.Lock()
+	s.Throttled += time.Since(start)
+	s.mu.Unlock()
+}
+
+// ObserveResponse counts the request and keeps the lowest rate limit headroom
+// lever has reported during the run.
+func (s *RunStats) ObserveResponse(resp *http.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Requests++
+
+	if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil {
+		s.RateLimit = limit
+	}
+
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		if s.RateRemaining < 0 || remaining < s.RateRemaining {
+			s.RateRemaining = remaining
+		}
+	}
+}
+
+// ObserveBytes counts a response body as sent by lever and once decoded.
+func (s *RunStats) ObserveBytes(wire, decoded int) {
+	s.mu.Lock()
+	s.WireBytes += int64(wire)
+	s.DecodedBytes += int64(decoded)
+	s.mu.Unlock()
+}
+
+// UnitDone marks one input row (e.g. candidate) as fully exported.
+func (s *RunStats) UnitDone() {
+	s.mu.Lock()
+	s.UnitsDone++
+	s.mu.Unlock()
+}
+
+// RecordWritten counts a record written to the output.
+func (s *RunStats) RecordWritten() {
+	s.mu.Lock()
+	s.Records++
+	s.mu.Unlock()
+}
+
+// Projection estimates how long a full export takes when every request is
+// made at the rate limit. Without a known number of input rows we can only
+// project the requests this run made.
+func (s *RunStats) Projection() time.Duration {
+	requests := float64(s.Requests)
+	if s.UnitsDone > 0 && s.UnitsTotal > s.UnitsDone {
+		requests = requests / float64(s.UnitsDone) * float64(s.UnitsTotal)
+	}
+	return time.Duration(requests * float64(requestRate))
+}
+
+func (s *RunStats) Report() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := time.Since(s.Start)
+	fields := logrus.Fields{
+		"requests":          s.Requests,
+		"elapsed":           elapsed.String(),
+		"throttled":         s.Throttled.String(),
+		"projectedFullTime": s.Projection().String(),
+		"bytesOnWire":       s.WireBytes,
+		"bytesDecoded":      s.DecodedBytes,
+	}
+
+	if elapsed > 0 {
+		fields["requestsPerSecond"] = float64(s.Requests) / elapsed.Seconds()
+	}
+
+	if s.RateLimit > 0 {
+		fields["rateLimit"] = s.RateLimit
+	}
+
+	if s.RateRemaining >= 0 {
+		fields["minRateRemaining"] = s.RateRemaining
+	}
+
+	if apiVersion != "" {
+		fields["apiVersion"] = apiVersion
+	}
+
+	if s.UnitsTotal > 0 {
+		fields["inputRows"] = s.UnitsTotal
+		fields["inputRowsDone"] = s.UnitsDone
+	}
+
+	logrus.WithFields(fields).Info("API quota usage")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+var (
+	prettyOutput = false
+	sortKeys     = false
+)
+
+// NewEncoder returns the record encoder for w honouring --pretty.
+func NewEncoder(w io.Writer) *json.Encoder {
+	encoder := json.NewEncoder(w)
+	if prettyOutput {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder
+}
+
+// ToRecord converts a decoded lever struct into generic json values so it
+// can be transformed field by field. Numbers are kept as json.Number so
+// epoch timestamps round trip exactly.
+func ToRecord(obj interface{}) (interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var record interface{}
+	if err := decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// SortedRecord returns obj with the keys of every object in sorted order,
+// which encoding/json does for maps but not for structs.
+func SortedRecord(obj interface{}) interface{} {
+	record, err := ToRecord(obj)
+	if err != nil {
+		return obj
+	}
+	return record
+}
+
+// emitNulls writes null for unset values instead of Go's zero values, so
+// warehouses don't load epoch 0 as 1970 or empty strings as real values.
+var emitNulls = false
+
+// NullRecord returns obj with empty strings and zero timestamps replaced by
+// null. Lever sends timestamps as epoch milliseconds in fields named like
+// createdAt or date; other numbers are left alone since 0 is a meaningful
+// value for things like stage indexes and durations.
+func NullRecord(obj interface{}) interface{} {
+	record, err := ToRecord(obj)
+	if err != nil {
+		return obj
+	}
+	return nullValues("", record)
+}
+
+func nullValues(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+	case json.Number:
+		if isTimestampField(key) && v.String() == "0" {
+			return nil
+		}
+	case map[string]interface{}:
+		for k, nested := range v {
+			v[k] = nullValues(k, nested)
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = nullValues("", nested)
+		}
+	}
+	return value
+}
+
+func isTimestampField(key string) bool {
+	return key == "date" || (len(key) > 2 && strings.HasSuffix(key, "At"))
+}
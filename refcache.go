@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ReferenceCache keeps the small reference collections, stages, users,
+// postings and archive reasons, so a run fetches each at most once however
+// many resolvers and lookups want them. A watch refetches them once they are
+// older than TTL, zero keeps them for the whole run.
+type ReferenceCache struct {
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]referenceEntry
+}
+
+type referenceEntry struct {
+	records   []json.RawMessage
+	fetchedAt time.Time
+}
+
+var referenceCache = &ReferenceCache{}
+
+// Get returns the records cached for path, fetching them when missing or
+// expired.
+func (c *ReferenceCache) Get(path string, fetch func() ([]json.RawMessage, error)) ([]json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[path]; ok && !c.expired(entry) {
+		logrus.WithField("path", path).Debug("Using cached reference data")
+		return entry.records, nil
+	}
+
+	records, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.entries == nil {
+		c.entries = map[string]referenceEntry{}
+	}
+	c.entries[path] = referenceEntry{records: records, fetchedAt: time.Now()}
+	return records, nil
+}
+
+// Stale reports if any cached collection has expired, so a long running
+// resolver knows to rebuild.
+func (c *ReferenceCache) Stale() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entry := range c.entries {
+		if c.expired(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ReferenceCache) expired(entry referenceEntry) bool {
+	return c.TTL > 0 && time.Since(entry.fetchedAt) >= c.TTL
+}
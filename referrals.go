@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ReferralOutcome is a referral joined to where its candidate ended up.
+type ReferralOutcome struct {
+	ReferralID    string `json:"referralId"`
+	CandidateID   string `json:"candidateId"`
+	CandidateName string `json:"candidateName"`
+	Referrer      string `json:"referrer"`
+	ReferrerName  string `json:"referrerName"`
+	ReferredAt    int    `json:"referredAt"`
+	ArchivedAt    int    `json:"archivedAt"`
+	ArchiveReason string `json:"archiveReason"`
+	Outcome       string `json:"outcome"`
+}
+
+// ReferrerStats is how the candidates one person referred did.
+type ReferrerStats struct {
+	Referrer     string  `json:"referrer"`
+	ReferrerName string  `json:"referrerName"`
+	Referrals    int     `json:"referrals"`
+	Active       int     `json:"active"`
+	Archived     int     `json:"archived"`
+	Hired        int     `json:"hired"`
+	HireRate     float64 `json:"hireRate"`
+}
+
+func init() {
+	RegisterAnalysis(Command{
+		Name:        "referrals",
+		Description: "Join referrals to their candidates' outcomes and report hires per referrer",
+		Run:         runReferrals,
+	})
+}
+
+func runReferrals(args []string) error {
+	flags := NewCommandFlags("referrals")
+	referralsPath := flags.String("referrals", "", "Referrals export")
+	candidatesPath := flags.String("candidates", "", "Candidates export the referrals belong to")
+	reasonsPath := flags.String("archived-reasons", "", "Archived reasons export, telling hires from other archives")
+	usersPath := flags.String("users", "", "Users export to name referrers with")
+	detail := flags.Bool("detail", false, "Write one row per referral instead of the per referrer stats")
+	flags.Parse(args)
+
+	if *referralsPath == "" || *candidatesPath == "" || *reasonsPath == "" {
+		return errors.New("referrals needs --referrals, --candidates and --archived-reasons exports")
+	}
+
+	lookups := &Resolver{}
+	if err := lookups.ReadArchiveReasons(*reasonsPath); err != nil {
+		return err
+	}
+
+	if *usersPath != "" {
+		if err := lookups.ReadUsers(*usersPath); err != nil {
+			return err
+		}
+	}
+
+	candidates := map[string]Candidate{}
+	err := ReadRecords(*candidatesPath, func(record json.RawMessage) error {
+		var candidate Candidate
+		if err := json.Unmarshal(record, &candidate); err != nil {
+			return err
+		}
+		candidates[candidate.ID] = candidate
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var outcomes []ReferralOutcome
+	missing := 0
+	err = ReadRecords(*referralsPath, func(record json.RawMessage) error {
+		var referral Referral
+		if err := json.Unmarshal(record, &referral); err != nil {
+			return err
+		}
+
+		candidate, ok := candidates[referral.CandidateID]
+		if !ok {
+			missing++
+			return nil
+		}
+
+		referrals := []Referral{referral}
+		lookups.AnnotateReferrals(referrals)
+		outcomes = append(outcomes, NewReferralOutcome(lookups, referrals[0], candidate))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *detail {
+		for _, outcome := range outcomes {
+			Output(outcome, enc)
+		}
+	} else {
+		for _, stats := range ReferrerConversion(outcomes) {
+			Output(stats, enc)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{"referrals": len(outcomes), "missingCandidates": missing}).Info("Joined referrals to outcomes")
+	return nil
+}
+
+func NewReferralOutcome(lookups *Resolver, referral Referral, candidate Candidate) ReferralOutcome {
+	outcome := ReferralOutcome{
+		ReferralID:    referral.ID,
+		CandidateID:   candidate.ID,
+		CandidateName: candidate.Name,
+		Referrer:      referral.Referrer,
+		ReferrerName:  referral.ReferrerName,
+		ReferredAt:    referral.CreatedAt,
+		ArchivedAt:    candidate.Archived.ArchivedAt,
+	}
+
+	outcome.ArchiveReason, outcome.Outcome = lookups.Outcome(candidate.Archived)
+	return outcome
+}
+
+// ReferrerConversion totals outcomes per referrer, most hires first.
+func ReferrerConversion(outcomes []ReferralOutcome) []ReferrerStats {
+	byReferrer := map[string]*ReferrerStats{}
+	for _, outcome := range outcomes {
+		stats := byReferrer[outcome.Referrer]
+		if stats == nil {
+			stats = &ReferrerStats{Referrer: outcome.Referrer}
+			byReferrer[outcome.Referrer] = stats
+		}
+
+		if stats.ReferrerName == "" {
+			stats.ReferrerName = outcome.ReferrerName
+		}
+
+		stats.Referrals++
+		switch outcome.Outcome {
+		case "hired":
+			stats.Hired++
+		case "archived":
+			stats.Archived++
+		default:
+			stats.Active++
+		}
+	}
+
+	result := make([]ReferrerStats, 0, len(byReferrer))
+	for _, stats := range byReferrer {
+		stats.HireRate = math.Round(float64(stats.Hired)/float64(stats.Referrals)*1000) / 1000
+		result = append(result, *stats)
+	}
+
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Hired != result[b].Hired {
+			return result[a].Hired > result[b].Hired
+		}
+		if result[a].Referrals != result[b].Referrals {
+			return result[a].Referrals > result[b].Referrals
+		}
+		return result[a].Referrer < result[b].Referrer
+	})
+	return result
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type requestFieldsKey struct{}
+
+// WithRequestFields attaches fields saying what a request is for, such as
+// the candidate id and page, to every log line about it.
+func WithRequestFields(req *http.Request, fields logrus.Fields) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestFieldsKey{}, fields))
+}
+
+// RequestFields returns the log fields identifying a request, with lever's
+// request id once there is a response so failures can be quoted to lever
+// support.
+func RequestFields(req *http.Request, resp *http.Response) logrus.Fields {
+	fields := logrus.Fields{"method": req.Method, "url": req.URL.String()}
+	if extra, ok := req.Context().Value(requestFieldsKey{}).(logrus.Fields); ok {
+		for k, v := range extra {
+			fields[k] = v
+		}
+	}
+
+	if resp != nil {
+		fields["status"] = resp.StatusCode
+		if id := resp.Header.Get("X-Request-Id"); id != "" {
+			fields["requestId"] = id
+		}
+	}
+	return fields
+}
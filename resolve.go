@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Resolver holds reference data fetched once at the start of a run and used
+// to annotate records that only carry opaque lever IDs.
+type Resolver struct {
+	Stages         map[string]string
+	Users          map[string]User
+	Postings       map[string]Posting
+	ArchiveReasons map[string]string
+	HiredReasons   map[string]bool
+}
+
+var resolver *Resolver
+
+// NewResolver prefetches the reference data for each of the comma separated
+// kinds given to --resolve.
+func NewResolver(kinds string) (*Resolver, error) {
+	r := &Resolver{}
+
+	for _, kind := range strings.Split(kinds, ",") {
+		switch strings.TrimSpace(kind) {
+		case "":
+		case "stages":
+			var stages []Stage
+			if err := FetchAll("/stages", &stages); err != nil {
+				return nil, err
+			}
+
+			r.Stages = make(map[string]string, len(stages))
+			for _, stage := range stages {
+				r.Stages[stage.ID] = stage.Text
+			}
+			logrus.Infof("Resolved %d stages", len(stages))
+		case "users":
+			var users []User
+			if err := FetchAll("/users", &users); err != nil {
+				return nil, err
+			}
+
+			r.Users = make(map[string]User, len(users))
+			for _, user := range users {
+				r.Users[user.ID] = user
+			}
+			logrus.Infof("Resolved %d users", len(users))
+		case "postings":
+			var postings []Posting
+			if err := FetchAll("/postings", &postings); err != nil {
+				return nil, err
+			}
+
+			r.Postings = make(map[string]Posting, len(postings))
+			for _, posting := range postings {
+				r.Postings[posting.ID] = posting
+			}
+			logrus.Infof("Resolved %d postings", len(postings))
+		case "archiveReasons":
+			var reasons []ArchiveReason
+			if err := FetchAll("/archive_reasons", &reasons); err != nil {
+				return nil, err
+			}
+
+			r.ArchiveReasons = make(map[string]string, len(reasons))
+			for _, reason := range reasons {
+				r.ArchiveReasons[reason.ID] = reason.Text
+			}
+
+			r.HiredReasons = make(map[string]bool)
+			for _, reason := range reasons {
+				CheckEnums(reason)
+				r.HiredReasons[reason.ID] = reason.Type == ArchiveReasonTypeHired
+			}
+			logrus.Infof("Resolved %d archive reasons", len(reasons))
+		default:
+			return nil, fmt.Errorf("unknown --resolve type %q", kind)
+		}
+	}
+	return r, nil
+}
+
+// FetchAll pages through a top level list endpoint decoding every record
+// into v, which must be a pointer to a slice. The records are kept in the
+// run's reference cache.
+func FetchAll(sprintfPath string, v interface{}) error {
+	all, err := referenceCache.Get(sprintfPath, func() ([]json.RawMessage, error) {
+		return fetchRecords(Endpoint{Method: "GET", SprintfPath: sprintfPath})
+	})
+	if err != nil {
+		return err
+	}
+	return unmarshalRecords(all, v)
+}
+
+// FetchAllFrom is FetchAll for an endpoint that has already been set up with
+// its path arguments and query params. Its records aren't cached.
+func FetchAllFrom(endpoint Endpoint, v interface{}) error {
+	all, err := fetchRecords(endpoint)
+	if err != nil {
+		return err
+	}
+	return unmarshalRecords(all, v)
+}
+
+func fetchRecords(endpoint Endpoint) ([]json.RawMessage, error) {
+	var all []json.RawMessage
+	for {
+		var leverData LeverData
+		if err := ExecuteLeverRequest(&endpoint, &leverData); err != nil {
+			return nil, err
+		}
+
+		var records []json.RawMessage
+		if err := json.Unmarshal(leverData.Data, &records); err != nil {
+			return nil, err
+		}
+		all = append(all, records...)
+
+		if !endpoint.HasNext {
+			return all, nil
+		}
+	}
+}
+
+func unmarshalRecords(all []json.RawMessage, v interface{}) error {
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (r *Resolver) AnnotateCandidates(candidates []Candidate) {
+	if r == nil {
+		return
+	}
+
+	for i := range candidates {
+		if r.Stages != nil {
+			candidates[i].StageText = r.Stages[candidates[i].Stage]
+			for j := range candidates[i].StageChanges {
+				change := &candidates[i].StageChanges[j]
+				change.ToStageText = r.Stages[change.ToStageID]
+			}
+		}
+
+		if r.ArchiveReasons != nil {
+			archived := &candidates[i].Archived
+			reason := archived.Reason
+			if reason == "" {
+				reason = archived.ArchivedReason
+			}
+			archived.ReasonText = r.ArchiveReasons[reason]
+		}
+	}
+}
+
+func (r *Resolver) AnnotateFeedback(feedback []Feedback) {
+	if r == nil || r.Users == nil {
+		return
+	}
+
+	for i := range feedback {
+		if user, ok := r.Users[feedback[i].User]; ok {
+			feedback[i].UserName = user.Name
+			feedback[i].UserEmail = user.Email
+		}
+	}
+}
+
+// ReadArchiveReasons resolves archive reasons from an archived reasons export
+// instead of lever.
+func (r *Resolver) ReadArchiveReasons(path string) error {
+	r.ArchiveReasons = map[string]string{}
+	r.HiredReasons = map[string]bool{}
+	return ReadRecords(path, func(record json.RawMessage) error {
+		var reason ArchiveReason
+		if err := json.Unmarshal(record, &reason); err != nil {
+			return err
+		}
+		CheckEnums(reason)
+		r.ArchiveReasons[reason.ID] = reason.Text
+		r.HiredReasons[reason.ID] = reason.Type == ArchiveReasonTypeHired
+		return nil
+	})
+}
+
+// ReadUsers resolves users from a users export instead of lever.
+func (r *Resolver) ReadUsers(path string) error {
+	r.Users = map[string]User{}
+	return ReadRecords(path, func(record json.RawMessage) error {
+		var user User
+		if err := json.Unmarshal(record, &user); err != nil {
+			return err
+		}
+		r.Users[user.ID] = user
+		return nil
+	})
+}
+
+// Outcome is the archive reason and whether the archive was a hire, or
+// active when not archived.
+func (r *Resolver) Outcome(archived Archived) (string, string) {
+	if archived.ArchivedAt == 0 {
+		return "", "active"
+	}
+
+	reason := archived.Reason
+	if reason == "" {
+		reason = archived.ArchivedReason
+	}
+
+	if r == nil {
+		return reason, "archived"
+	}
+
+	if r.HiredReasons[reason] {
+		return r.ArchiveReasons[reason], "hired"
+	}
+	return r.ArchiveReasons[reason], "archived"
+}
+
+func (r *Resolver) AnnotateReferrals(referrals []Referral) {
+	if r == nil || r.Users == nil {
+		return
+	}
+
+	for i := range referrals {
+		if user, ok := r.Users[referrals[i].Referrer]; ok {
+			referrals[i].ReferrerName = user.Name
+			referrals[i].ReferrerEmail = user.Email
+		}
+	}
+}
+
+func (r *Resolver) AnnotateInterviews(interviews []Interview) {
+	if r == nil || r.Users == nil {
+		return
+	}
+
+	for i := range interviews {
+		if user, ok := r.Users[interviews[i].User]; ok {
+			interviews[i].UserName = user.Name
+			interviews[i].UserEmail = user.Email
+		}
+
+		for j := range interviews[i].Interviewers {
+			interviewer := &interviews[i].Interviewers[j]
+			if user, ok := r.Users[interviewer.ID]; ok {
+				if interviewer.Name == "" {
+					interviewer.Name = user.Name
+				}
+				if interviewer.Email == "" {
+					interviewer.Email = user.Email
+				}
+			}
+		}
+	}
+}
+
+func (r *Resolver) AnnotateApplications(applications []Application) {
+	if r == nil || r.Postings == nil {
+		return
+	}
+
+	for i := range applications {
+		if posting, ok := r.Postings[applications[i].Posting]; ok {
+			applications[i].PostingText = posting.Text
+			applications[i].PostingTeam = posting.Categories.Team
+			applications[i].PostingLocation = posting.Categories.Location
+		}
+	}
+}
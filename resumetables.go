@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ResumePosition is one job from a resume's parsed employment history, a
+// row of the resumePositions child table.
+type ResumePosition struct {
+	CandidateID string `json:"candidateId"`
+	ResumeID    string `json:"resumeId"`
+	Index       int    `json:"index"`
+	Org         string `json:"org"`
+	Title       string `json:"title"`
+	Location    string `json:"location"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+}
+
+// ResumeSchool is one school from a resume's parsed education, a row of the
+// resumeSchools child table.
+type ResumeSchool struct {
+	CandidateID string `json:"candidateId"`
+	ResumeID    string `json:"resumeId"`
+	Index       int    `json:"index"`
+	Org         string `json:"org"`
+	Degree      string `json:"degree"`
+	Field       string `json:"field"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+}
+
+// ResumeTables writes the positions and schools of downloaded resumes to
+// their own files alongside the resumes, set with --resume-tables.
+type ResumeTables struct {
+	positions    *RotatingFile
+	schools      *RotatingFile
+	positionsEnc *json.Encoder
+	schoolsEnc   *json.Encoder
+}
+
+var resumeTables *ResumeTables
+
+func NewResumeTables(dir string) (*ResumeTables, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	t := &ResumeTables{
+		positions: NewRotatingFile(filepath.Join(dir, "resumePositions.json"), 0, 0),
+		schools:   NewRotatingFile(filepath.Join(dir, "resumeSchools.json"), 0, 0),
+	}
+	t.positionsEnc = json.NewEncoder(t.positions)
+	t.schoolsEnc = json.NewEncoder(t.schools)
+	return t, nil
+}
+
+// Write explodes each resume's parsed data into child table rows.
+func (t *ResumeTables) Write(resumes []Resume) {
+	if t == nil {
+		return
+	}
+
+	for _, resume := range resumes {
+		if resume.ParsedData == nil {
+			continue
+		}
+
+		for i, position := range resume.ParsedData.Positions {
+			Output(ResumePosition{
+				CandidateID: resume.CandidateID,
+				ResumeID:    resume.ID,
+				Index:       i,
+				Org:         position.Org,
+				Title:       position.Title,
+				Location:    position.Location,
+				Start:       position.Start.String(),
+				End:         position.End.String(),
+			}, t.positionsEnc)
+		}
+
+		for i, school := range resume.ParsedData.Schools {
+			Output(ResumeSchool{
+				CandidateID: resume.CandidateID,
+				ResumeID:    resume.ID,
+				Index:       i,
+				Org:         school.Org,
+				Degree:      school.Degree,
+				Field:       school.Field,
+				Start:       school.Start.String(),
+				End:         school.End.String(),
+			}, t.schoolsEnc)
+		}
+	}
+}
+
+func (t *ResumeTables) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	err := t.positions.Close()
+	if serr := t.schools.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// String formats the date as 2006-01, or just the year when that is all the
+// resume gives. Unset dates are empty.
+func (d *ResumeDate) String() string {
+	switch {
+	case d == nil || d.Year == 0:
+		return ""
+	case d.Month == 0:
+		return fmt.Sprintf("%04d", d.Year)
+	}
+	return fmt.Sprintf("%04d-%02d", d.Year, d.Month)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention drops records created longer ago than MaxAge, so an export
+// never copies data that should already have been deleted. The window is
+// measured back from when each record is written, a long watch keeps it
+// current.
+type Retention struct {
+	MaxAge time.Duration
+
+	dropped int
+}
+
+// retention is nil unless --max-age is given, every method is safe to call
+// on a nil retention.
+var retention *Retention
+
+// Keeps reports whether the record is inside the retention window. Records
+// without a createdAt, such as users or stages, are always kept.
+func (r *Retention) Keeps(obj interface{}) bool {
+	if r == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return true
+	}
+
+	createdAt := v.FieldByName("CreatedAt")
+	if !createdAt.IsValid() || (createdAt.Kind() != reflect.Int && createdAt.Kind() != reflect.Int64) || createdAt.Int() == 0 {
+		return true
+	}
+
+	cutoff := time.Now().Add(-r.MaxAge).UnixNano() / int64(time.Millisecond)
+	if createdAt.Int() < cutoff {
+		r.dropped++
+		return false
+	}
+	return true
+}
+
+// Dropped is how many records fell outside the window.
+func (r *Retention) Dropped() int {
+	if r == nil {
+		return 0
+	}
+	return r.dropped
+}
+
+// ParseAge parses ages such as 3y, 18w or 90d, a year being 365 days, as
+// well as anything time.ParseDuration accepts.
+func ParseAge(value string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"y": 365 * 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"d": 24 * time.Hour,
+	}
+
+	trimmed := strings.TrimSpace(value)
+	for suffix, unit := range units {
+		if !strings.HasSuffix(trimmed, suffix) {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(strings.TrimSuffix(trimmed, suffix), 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("unable to parse age %q, expected a value like 3y, 18w or 90d", value)
+		}
+		return time.Duration(n * float64(unit)), nil
+	}
+
+	age, err := time.ParseDuration(trimmed)
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("unable to parse age %q, expected a value like 3y, 18w or 90d", value)
+	}
+	return age, nil
+}
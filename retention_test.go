@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAge(t *testing.T) {
+	day := 24 * time.Hour
+
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{value: "3y", want: 3 * 365 * day},
+		{value: "18w", want: 18 * 7 * day},
+		{value: "90d", want: 90 * day},
+		{value: "1.5d", want: 36 * time.Hour},
+		{value: " 90d ", want: 90 * day},
+		{value: "36h", want: 36 * time.Hour},
+		{value: "90m", want: 90 * time.Minute},
+		{value: "0d", err: true},
+		{value: "-1d", err: true},
+		{value: "0s", err: true},
+		{value: "-36h", err: true},
+		{value: "d", err: true},
+		{value: "abc", err: true},
+		{value: "", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseAge(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseAge(%q) = %v, want an error", test.value, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseAge(%q) failed: %v", test.value, err)
+		} else if got != test.want {
+			t.Errorf("ParseAge(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
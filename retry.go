@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryable reports if a request that failed with resp or err is worth
+// sending again. Requests with a body that can't be replayed never are.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+
+	// The same body comes back just as large
+	if err == errBodyTooLarge {
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+	return (&LeverError{StatusCode: resp.StatusCode}).Temporary()
+}
+
+type retryCheckKey struct{}
+
+// WithRetryCheck has SendLeverRequest call check before sending the request
+// again after a failure that doesn't say if lever acted on it, a timeout or
+// server error. An error from check is returned instead of retrying.
+func WithRetryCheck(req *http.Request, check func(failure error) error) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), retryCheckKey{}, check))
+}
+
+// checkBeforeRetry runs the request's retry check, if it has one, for a
+// failure lever may have acted on.
+func checkBeforeRetry(req *http.Request, resp *http.Response, body []byte, err error) error {
+	check, ok := req.Context().Value(retryCheckKey{}).(func(error) error)
+	if !ok || (err == nil && resp.StatusCode < 500) {
+		return nil
+	}
+
+	if err == nil {
+		err = NewLeverError(resp, body)
+	}
+	return check(err)
+}
+
+// retryDelay backs off exponentially from the profile's backoff unless lever
+// told us how long to wait.
+func (p *ClientProfile) retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return p.RetryBackoff << uint(attempt)
+}
+
+// rewindBody resets the body of a request about to be sent again, the last
+// attempt consumed it.
+func rewindBody(req *http.Request) error {
+	if req.GetBody == nil {
+		return nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
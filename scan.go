@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// ScanRules are regular expressions matched against the text fields of
+// every record written, e.g.
+//
+//	rules:
+//	  - name: age
+//	    pattern: '(?i)\b(too old|too young)\b'
+//	    severity: high
+//	    resources: [feedback, interviews]
+//	  - name: family
+//	    pattern: '(?i)\b(pregnan\w*|maternity)\b'
+//	    fields: [fields.value, note]
+//
+// Resources and fields narrow a rule, fields are dotted paths into the
+// record with array indexes left out.
+type ScanRules struct {
+	Rules []ScanRule `yaml:"rules"`
+}
+
+type ScanRule struct {
+	Name      string   `yaml:"name"`
+	Pattern   string   `yaml:"pattern"`
+	Severity  string   `yaml:"severity"`
+	Resources []string `yaml:"resources"`
+	Fields    []string `yaml:"fields"`
+
+	re *regexp.Regexp
+}
+
+// Finding is a rule matching a field of a record.
+type Finding struct {
+	Rule        string `json:"rule"`
+	Severity    string `json:"severity,omitempty"`
+	Resource    string `json:"resource"`
+	RecordID    string `json:"recordId"`
+	CandidateID string `json:"candidateId,omitempty"`
+	Field       string `json:"field"`
+	Match       string `json:"match"`
+	Context     string `json:"context"`
+}
+
+// Scanner writes the findings of a run to its report file.
+type Scanner struct {
+	rules    []ScanRule
+	file     *os.File
+	buf      *bufio.Writer
+	enc      *json.Encoder
+	findings int
+}
+
+// scanner is set with --scan, nil scans nothing.
+var scanner *Scanner
+
+func LoadScanner(path, report string) (*Scanner, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules ScanRules
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	if len(rules.Rules) == 0 {
+		return nil, fmt.Errorf("%s has no rules", path)
+	}
+
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("%s: rule %d needs a name", path, i+1)
+		}
+
+		if rule.re, err = regexp.Compile(rule.Pattern); err != nil || rule.Pattern == "" {
+			return nil, fmt.Errorf("%s: rule %s needs a valid pattern: %v", path, rule.Name, err)
+		}
+
+		for _, resource := range rule.Resources {
+			known := false
+			for _, name := range resourceNames {
+				known = known || name == resource
+			}
+			if !known {
+				return nil, fmt.Errorf("%s: rule %s names unknown resource type %q", path, rule.Name, resource)
+			}
+		}
+	}
+
+	f, err := os.Create(report)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scanner{rules: rules.Rules, file: f, buf: bufio.NewWriter(f)}
+	s.enc = json.NewEncoder(s.buf)
+	return s, nil
+}
+
+// Scan matches the rules against the string fields of a record.
+func (s *Scanner) Scan(resource string, obj interface{}) {
+	if s == nil {
+		return
+	}
+
+	converted, err := ToRecord(obj)
+	if err != nil {
+		logrus.Error("Unable to scan record: ", err)
+		return
+	}
+
+	record, ok := converted.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	recordID, _ := record["id"].(string)
+	candidateID, _ := record["candidateId"].(string)
+	eachText(record, "", func(field, text string) {
+		for _, rule := range s.rules {
+			if !rule.applies(resource, field) {
+				continue
+			}
+
+			for _, loc := range rule.re.FindAllStringIndex(text, -1) {
+				s.write(Finding{
+					Rule:        rule.Name,
+					Severity:    rule.Severity,
+					Resource:    resource,
+					RecordID:    recordID,
+					CandidateID: candidateID,
+					Field:       field,
+					Match:       text[loc[0]:loc[1]],
+					Context:     snippet(text, loc[0], loc[1]),
+				})
+			}
+		}
+	})
+}
+
+func (s *Scanner) write(finding Finding) {
+	if err := s.enc.Encode(finding); err != nil {
+		logrus.Error("Unable to write scan finding: ", err)
+		return
+	}
+	s.findings++
+}
+
+// Report is the path findings are written to.
+func (s *Scanner) Report() string {
+	if s == nil {
+		return ""
+	}
+	return s.file.Name()
+}
+
+// Close flushes the report and logs how many findings it has.
+func (s *Scanner) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	if err := s.buf.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+
+	entry := logrus.WithFields(logrus.Fields{"findings": s.findings, "report": s.file.Name()})
+	if s.findings > 0 {
+		entry.Warn("Scan rules matched")
+	} else {
+		entry.Info("Scan rules matched nothing")
+	}
+	return s.file.Close()
+}
+
+func (rule ScanRule) applies(resource, field string) bool {
+	if len(rule.Resources) > 0 && !containsString(rule.Resources, resource) {
+		return false
+	}
+	return len(rule.Fields) == 0 || containsString(rule.Fields, field)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// eachText calls fn with the dotted path of every string in value, in a
+// stable order.
+func eachText(value interface{}, path string, fn func(field, text string)) {
+	switch v := value.(type) {
+	case string:
+		fn(path, v)
+	case []interface{}:
+		for _, element := range v {
+			eachText(element, path, fn)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			eachText(v[key], field, fn)
+		}
+	}
+}
+
+// snippet is the match with up to 40 bytes either side, cut on rune
+// boundaries.
+func snippet(text string, start, end int) string {
+	from, to := start-40, end+40
+	if from < 0 {
+		from = 0
+	}
+	if to > len(text) {
+		to = len(text)
+	}
+
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+	return strings.Join(strings.Fields(text[from:to]), " ")
+}
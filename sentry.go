@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SentryNotifier reports fatal errors and panics to sentry with the run's
+// context, using sentry's store api directly.
+type SentryNotifier struct {
+	storeURL string
+	key      string
+}
+
+type sentryEvent struct {
+	EventID   string                 `json:"event_id"`
+	Timestamp string                 `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Platform  string                 `json:"platform"`
+	Logger    string                 `json:"logger"`
+	Message   string                 `json:"message,omitempty"`
+	Exception *sentryExceptions      `json:"exception,omitempty"`
+	Tags      map[string]string      `json:"tags"`
+	Extra     map[string]interface{} `json:"extra"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string           `json:"type"`
+	Value      string           `json:"value"`
+	Stacktrace sentryStacktrace `json:"stacktrace"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+// NewSentryNotifier parses a dsn such as https://key@o1.ingest.sentry.io/42.
+func NewSentryNotifier(dsn string) (*SentryNotifier, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid sentry dsn %q", dsn)
+	}
+
+	project := path.Base(u.Path)
+	if project == "" || project == "/" || project == "." {
+		return nil, fmt.Errorf("sentry dsn %q has no project id", dsn)
+	}
+
+	store := url.URL{Scheme: u.Scheme, Host: u.Host, Path: path.Join(path.Dir(u.Path), "api", project, "store") + "/"}
+	return &SentryNotifier{storeURL: store.String(), key: u.User.Username()}, nil
+}
+
+func (s *SentryNotifier) Notify(summary RunSummary) error {
+	if summary.Status != "failed" {
+		return nil
+	}
+
+	event := s.newEvent(summary)
+	event.Message = summary.Error
+	return s.send(event)
+}
+
+// CapturePanic reports a panic before letting it continue, use it deferred.
+func (s *SentryNotifier) CapturePanic() {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	event := s.newEvent(RunSummary{Endpoint: notifyEndpoint})
+	event.Level = "fatal"
+	event.Exception = &sentryExceptions{Values: []sentryException{{
+		Type:       "panic",
+		Value:      fmt.Sprint(recovered),
+		Stacktrace: sentryStacktrace{Frames: panicFrames()},
+	}}}
+
+	if err := s.send(event); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to report panic to sentry:", err)
+	}
+	panic(recovered)
+}
+
+func (s *SentryNotifier) newEvent(summary RunSummary) sentryEvent {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	extra := map[string]interface{}{
+		"records":  summary.Records,
+		"requests": summary.Requests,
+		"duration": summary.Duration,
+	}
+
+	// Where the run was when it failed
+	if notifyState != nil {
+		extra["candidateId"] = notifyState.LastSeenID
+		extra["pageToken"] = notifyState.Cursor
+		extra["inputRow"] = notifyState.LastRow
+	}
+
+	return sentryEvent{
+		EventID:   hex.EncodeToString(id),
+		Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Level:     "error",
+		Platform:  "go",
+		Logger:    "fulcrum",
+		Tags:      map[string]string{"endpoint": summary.Endpoint, "apiVersion": apiVersion},
+		Extra:     extra,
+	}
+}
+
+func (s *SentryNotifier) send(event sentryEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=fulcrum/1.0, sentry_key=%s", s.key))
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry returned %s", resp.Status)
+	}
+	return nil
+}
+
+// panicFrames returns the stack of the panicking goroutine, oldest call
+// first as sentry expects, without the runtime's panic handling frames.
+func panicFrames() []sentryFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []sentryFrame
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append([]sentryFrame{{Function: frame.Function, Filename: frame.File, Lineno: frame.Line}}, stack...)
+		}
+		if !more {
+			return stack
+		}
+	}
+}
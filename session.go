@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Session groups the invocations of an export under an id given with
+// --session. Each invocation resumes where the last one stopped, usually at
+// its --run-deadline, until one finishes and the session is marked done.
+type Session struct {
+	ID          string    `json:"id"`
+	StartedAt   time.Time `json:"startedAt"`
+	CompletedAt time.Time `json:"completedAt"`
+	Runs        int       `json:"runs"`
+}
+
+var session *Session
+
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ParseSession checks the id is safe to use in file names.
+func ParseSession(id string) (*Session, error) {
+	if !sessionIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("session %q may only use letters, digits, '.', '_' and '-'", id)
+	}
+	return &Session{ID: id}, nil
+}
+
+// Namespace suffixes a checkpoint prefix so each session resumes
+// independently.
+func (s *Session) Namespace(prefix string) string {
+	if s == nil {
+		return prefix
+	}
+	return fmt.Sprintf("%s_session_%s", prefix, s.ID)
+}
+
+// Dir puts the session's output in a directory named after it under dir.
+func (s *Session) Dir(dir string) string {
+	if s == nil {
+		return dir
+	}
+	return filepath.Join(dir, s.ID)
+}
+
+// Sink writes the output file into the session's directory as numbered parts
+// following those earlier invocations wrote, so resuming keeps what they
+// wrote.
+func (s *Session) Sink(path string, maxBytes int64, maxRecords int) (*RotatingFile, error) {
+	dir := s.Dir(filepath.Dir(path))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	path = filepath.Join(dir, filepath.Base(path))
+	ext := filepath.Ext(path)
+	existing, err := filepath.Glob(path[:len(path)-len(ext)] + ".part-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := NewRotatingFile(path, maxBytes, maxRecords)
+	sink.PartName = func(part int) string {
+		return partPath(path, len(existing)+part)
+	}
+	return sink, nil
+}
+
+// Resume records another invocation of the session, reporting true when the
+// session already completed and there is nothing left to do.
+func (s *Session) Resume(store StateStore) (bool, error) {
+	data, err := store.Get(sessionBucket, s.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if data != nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return false, fmt.Errorf("reading session %s: %v", s.ID, err)
+		}
+	}
+
+	if !s.CompletedAt.IsZero() {
+		return true, nil
+	}
+
+	if s.StartedAt.IsZero() {
+		s.StartedAt = time.Now().UTC()
+	}
+	s.Runs++
+	return false, s.save(store)
+}
+
+// Complete marks the session done, later invocations exit straight away.
+func (s *Session) Complete(store StateStore) error {
+	s.CompletedAt = time.Now().UTC()
+	return s.save(store)
+}
+
+func (s *Session) save(store StateStore) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return store.Put(sessionBucket, s.ID, data)
+}
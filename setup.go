@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultTokenEnv is where init suggests keeping the api token.
+const defaultTokenEnv = "LEVER_API_TOKEN"
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "init",
+		Description: "Ask for a token source, output location and endpoints, check lever access and write a starter --config file",
+		Run:         runInit,
+	})
+}
+
+// prompter asks questions on the terminal, falling back to a default for
+// empty answers.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	eof bool
+}
+
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+
+	// Running out of input takes the default
+	answer, err := p.in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	p.eof = err == io.EOF
+
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+func runInit(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	out := flags.String("out", "fulcrum.yaml", "Config file to write")
+	force := flags.Bool("force", false, "Overwrite an existing config file")
+	flags.Parse(args)
+
+	if _, err := os.Stat(*out); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", *out)
+	}
+
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	fmt.Fprintln(p.out, "Setting up fulcrum, press enter to take the suggestion in brackets.")
+
+	source, err := p.ask("Environment variable holding the lever api token, or 'inline' to store it in the file", defaultTokenEnv)
+	if err != nil {
+		return err
+	}
+
+	tokenSetting := "${" + source + "}"
+	token := os.Getenv(source)
+	if source == "inline" {
+		if token, err = p.ask("Lever api token", ""); err != nil {
+			return err
+		}
+		tokenSetting = token
+	} else if token == "" {
+		fmt.Fprintf(p.out, "%s isn't set in this shell.\n", source)
+		if token, err = p.ask("Paste the token to check access now, it won't be saved (enter skips the check)", ""); err != nil {
+			return err
+		}
+	}
+
+	outputDir, err := p.ask("Directory to write exports to", "exports")
+	if err != nil {
+		return err
+	}
+
+	endpoints, err := askEndpoints(p)
+	if err != nil {
+		return err
+	}
+
+	if token != "" {
+		apiToken = token
+		if err := verifyAccess(p.out, endpoints); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(p.out, "Skipped checking access, run `fulcrum check` once the token is set.")
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+
+	config := StarterConfig(*out, tokenSetting, outputDir, endpoints)
+
+	// An inline token is a credential, keep it from other users
+	mode := os.FileMode(0644)
+	if source == "inline" {
+		mode = 0600
+	}
+	if err := ioutil.WriteFile(*out, []byte(config), mode); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(p.out, "Wrote %s, check it with `fulcrum validate-config %s`.\n", *out, *out)
+	return nil
+}
+
+func askEndpoints(p *prompter) ([]string, error) {
+	names := []string{}
+	for name, endpoint := range registeredEndpoints {
+		if endpoint.Method == "GET" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for {
+		answer, err := p.ask("Comma separated endpoints to export, one of "+strings.Join(names, ", "), "downloadCandidates")
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints := []string{}
+		unknown := []string{}
+		for _, name := range strings.Split(answer, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+
+			if _, ok := registeredEndpoints[name]; ok {
+				endpoints = append(endpoints, name)
+			} else {
+				unknown = append(unknown, name)
+			}
+		}
+
+		if len(unknown) == 0 && len(endpoints) > 0 {
+			return endpoints, nil
+		}
+		if p.eof {
+			return nil, fmt.Errorf("unknown endpoint %s", strings.Join(unknown, ", "))
+		}
+		fmt.Fprintf(p.out, "Unknown endpoint %s, try again.\n", strings.Join(unknown, ", "))
+	}
+}
+
+// verifyAccess checks the token can read each endpoint's first page.
+func verifyAccess(out io.Writer, endpoints []string) error {
+	denied := []string{}
+	for _, name := range endpoints {
+		endpoint := registeredEndpoints[name]
+		if endpoint.Method != "GET" || strings.Contains(endpoint.SprintfPath, "%s") {
+			fmt.Fprintf(out, "access %-28s not checked, it needs a candidate\n", name)
+			continue
+		}
+		endpoint.QueryParams = []QueryParam{{Field: "limit", Value: "1"}}
+
+		status, _, err := probe(&endpoint)
+		switch {
+		case err != nil:
+			return err
+		case status == http.StatusUnauthorized:
+			return &LeverError{StatusCode: status, URL: endpoint.URLString(), Message: "token rejected"}
+		case status == http.StatusOK:
+			fmt.Fprintf(out, "access %-28s ok\n", name)
+		default:
+			denied = append(denied, name)
+			fmt.Fprintf(out, "access %-28s denied (%d)\n", name, status)
+		}
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("token cannot read %s, enable them for the key under Settings > Integrations and API", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// StarterConfig is the config file init writes: the first endpoint is the
+// default and the others are exported by overriding it on the command line.
+func StarterConfig(path, token, outputDir string, endpoints []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Written by fulcrum init, settings are named after the flags and flags\n")
+	fmt.Fprintf(&b, "# on the command line win. Check changes with: fulcrum validate-config %s\n", path)
+	fmt.Fprintf(&b, "token: %q\n", token)
+	fmt.Fprintf(&b, "endpoint: %s\n", endpoints[0])
+	fmt.Fprintf(&b, "output: %q\n", filepath.Join(outputDir, endpoints[0]+".json"))
+	fmt.Fprintf(&b, "manifest: %q\n", filepath.Join(outputDir, endpoints[0]+".manifest.json"))
+
+	if len(endpoints) > 1 {
+		fmt.Fprintf(&b, "\n# Export the other endpoints of interest with:\n")
+		for _, name := range endpoints[1:] {
+			fmt.Fprintf(&b, "#   fulcrum --config %s --endpoint %s --output %s --manifest %s\n", path, name,
+				filepath.Join(outputDir, name+".json"), filepath.Join(outputDir, name+".manifest.json"))
+		}
+	}
+	return b.String()
+}
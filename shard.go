@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Shard selects a deterministic slice of the candidate ids so a large per
+// candidate export can be split across processes with --shard k/n.
+type Shard struct {
+	Index int
+	Count int
+}
+
+var shard *Shard
+
+// ParseShard parses k/n where k is between 1 and n.
+func ParseShard(value string) (*Shard, error) {
+	s := &Shard{}
+	if _, err := fmt.Sscanf(value, "%d/%d", &s.Index, &s.Count); err != nil {
+		return nil, fmt.Errorf("unable to parse shard %q, expected k/n such as 2/8", value)
+	}
+
+	if s.Count < 1 || s.Index < 1 || s.Index > s.Count {
+		return nil, fmt.Errorf("shard %q is out of range, k must be between 1 and n", value)
+	}
+	return s, nil
+}
+
+// Contains reports if id belongs to this shard. A nil shard contains every id.
+func (s *Shard) Contains(id string) bool {
+	if s == nil {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index-1
+}
+
+// Namespace suffixes a checkpoint prefix so each shard resumes independently.
+func (s *Shard) Namespace(prefix string) string {
+	if s == nil {
+		return prefix
+	}
+	return fmt.Sprintf("%s_shard%dof%d", prefix, s.Index, s.Count)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// sortMemoryLimit is how many bytes of records SortingSink holds before
+// spilling a sorted run to a temporary file.
+var sortMemoryLimit = 64 * 1024 * 1024
+
+var sortFields = map[string]bool{
+	"createdAt": true,
+	"id":        true,
+}
+
+type sortedRecord struct {
+	createdAt float64
+	id        string
+	data      []byte
+}
+
+// SortingSink holds every record until the sink is closed and then writes
+// them to Next ordered by createdAt or id, so snapshot files diff cleanly no
+// matter what order pages arrived in. Large exports are spilled to sorted
+// runs on disk and merged. Since nothing reaches Next until the end of the
+// run, sorted runs save no checkpoint and a run that crashes starts over.
+type SortingSink struct {
+	Next OutputSink
+	By   string
+
+	records []sortedRecord
+	size    int
+	runs    []string
+}
+
+func NewSortingSink(next OutputSink, by string) *SortingSink {
+	return &SortingSink{Next: next, By: by}
+}
+
+func (s *SortingSink) Write(p []byte) (int, error) {
+	record := sortedRecord{data: append([]byte(nil), p...)}
+
+	var keys struct {
+		ID        string  `json:"id"`
+		CreatedAt float64 `json:"createdAt"`
+	}
+	json.Unmarshal(p, &keys)
+	record.id = keys.ID
+	record.createdAt = keys.CreatedAt
+
+	s.records = append(s.records, record)
+	s.size += len(p)
+
+	if s.size > sortMemoryLimit {
+		if err := s.spill(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush is a no-op, records can only be written once they are all sorted.
+func (s *SortingSink) Flush() error {
+	return nil
+}
+
+func (s *SortingSink) Close() error {
+	defer s.removeRuns()
+
+	if len(s.runs) == 0 {
+		s.sort(s.records)
+		for _, record := range s.records {
+			if _, err := s.Next.Write(record.data); err != nil {
+				return err
+			}
+		}
+		s.records = nil
+		return s.Next.Close()
+	}
+
+	if err := s.spill(); err != nil {
+		return err
+	}
+
+	if err := s.merge(); err != nil {
+		return err
+	}
+	return s.Next.Close()
+}
+
+func (s *SortingSink) less(a, b sortedRecord) bool {
+	if s.By == "createdAt" && a.createdAt != b.createdAt {
+		return a.createdAt < b.createdAt
+	}
+	return a.id < b.id
+}
+
+func (s *SortingSink) sort(records []sortedRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return s.less(records[i], records[j])
+	})
+}
+
+// spill writes the in memory records to a sorted run file.
+func (s *SortingSink) spill() error {
+	if len(s.records) == 0 {
+		return nil
+	}
+	s.sort(s.records)
+
+	f, err := ioutil.TempFile("", "fulcrum-sort-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+
+	w := bufio.NewWriter(f)
+	for _, record := range s.records {
+		if err := writeRunRecord(w, record); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	s.records = nil
+	s.size = 0
+	return f.Close()
+}
+
+func (s *SortingSink) merge() error {
+	runs := &runHeap{sink: s}
+	for _, path := range s.runs {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		run := &sortRun{r: bufio.NewReader(f)}
+		if ok, err := run.next(); err != nil {
+			return err
+		} else if ok {
+			runs.runs = append(runs.runs, run)
+		}
+	}
+	heap.Init(runs)
+
+	for runs.Len() > 0 {
+		run := runs.runs[0]
+		if _, err := s.Next.Write(run.head.data); err != nil {
+			return err
+		}
+
+		ok, err := run.next()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			heap.Fix(runs, 0)
+		} else {
+			heap.Pop(runs)
+		}
+	}
+	return nil
+}
+
+func (s *SortingSink) removeRuns() {
+	for _, path := range s.runs {
+		os.Remove(path)
+	}
+	s.runs = nil
+}
+
+// Run files hold length prefixed records so pretty printed records keep
+// their newlines.
+func writeRunRecord(w io.Writer, record sortedRecord) error {
+	header := make([]byte, 8+4+8)
+	binary.BigEndian.PutUint64(header, uint64(record.createdAt))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(record.id)))
+	binary.BigEndian.PutUint64(header[12:], uint64(len(record.data)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, record.id); err != nil {
+		return err
+	}
+	_, err := w.Write(record.data)
+	return err
+}
+
+type sortRun struct {
+	r    *bufio.Reader
+	head sortedRecord
+}
+
+func (run *sortRun) next() (bool, error) {
+	header := make([]byte, 8+4+8)
+	if _, err := io.ReadFull(run.r, header); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	id := make([]byte, binary.BigEndian.Uint32(header[8:]))
+	data := make([]byte, binary.BigEndian.Uint64(header[12:]))
+	if _, err := io.ReadFull(run.r, id); err != nil {
+		return false, err
+	}
+	if _, err := io.ReadFull(run.r, data); err != nil {
+		return false, err
+	}
+
+	run.head = sortedRecord{
+		createdAt: float64(binary.BigEndian.Uint64(header)),
+		id:        string(id),
+		data:      data,
+	}
+	return true, nil
+}
+
+type runHeap struct {
+	sink *SortingSink
+	runs []*sortRun
+}
+
+func (h *runHeap) Len() int           { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool { return h.sink.less(h.runs[i].head, h.runs[j].head) }
+func (h *runHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*sortRun)) }
+
+func (h *runHeap) Pop() interface{} {
+	run := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return run
+}
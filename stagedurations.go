@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// StageDuration is how long candidates of a posting who entered a stage in
+// a month stayed in it before moving on. Candidates still in the stage are
+// not counted.
+type StageDuration struct {
+	PostingID   string             `json:"postingId"`
+	PostingText string             `json:"postingText"`
+	Month       string             `json:"month"`
+	Stage       string             `json:"stage"`
+	StageText   string             `json:"stageText"`
+	Count       int                `json:"count"`
+	MedianHours float64            `json:"medianHours"`
+	Percentiles map[string]float64 `json:"percentileHours"`
+}
+
+// stageDwell is one stay in a stage.
+type stageDwell struct {
+	posting     string
+	postingText string
+	stage       string
+	stageText   string
+	enteredAt   int
+	hours       float64
+}
+
+func init() {
+	RegisterAnalysis(Command{
+		Name:        "stage-durations",
+		Description: "Median and percentile time candidates spend in each stage by posting and month",
+		Run:         runStageDurations,
+	})
+}
+
+func runStageDurations(args []string) error {
+	flags := NewCommandFlags("stage-durations")
+	candidatesPath := flags.String("candidates", "", "Candidates export to read stage changes from instead of lever")
+	applicationsPath := flags.String("applications", "", "Applications export giving each candidate of --candidates their posting")
+	createdAtStart := flags.String("createdAtStart", "", "Only include candidates created after this epoch millisecond timestamp when reading lever")
+	percentileList := flags.String("percentiles", "75,90", "Percentiles to report alongside the median")
+	flags.Parse(args)
+
+	percentiles, err := ParsePercentiles(*percentileList)
+	if err != nil {
+		return err
+	}
+
+	var dwells []stageDwell
+	if *candidatesPath != "" {
+		dwells, err = localStageDwells(*candidatesPath, *applicationsPath)
+	} else {
+		RequireToken()
+		dwells, err = leverStageDwells(*createdAtStart)
+	}
+	if err != nil {
+		return err
+	}
+
+	rows := StageDurations(dwells, percentiles)
+	for _, row := range rows {
+		Output(row, enc)
+	}
+
+	logrus.WithFields(logrus.Fields{"stays": len(dwells), "rows": len(rows)}).Info("Computed stage durations")
+	return nil
+}
+
+func localStageDwells(candidatesPath, applicationsPath string) ([]stageDwell, error) {
+	applications := map[string][]Application{}
+	if applicationsPath != "" {
+		err := ReadRecords(applicationsPath, func(record json.RawMessage) error {
+			var application Application
+			if err := json.Unmarshal(record, &application); err != nil {
+				return err
+			}
+			applications[application.CandidateID] = append(applications[application.CandidateID], application)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var dwells []stageDwell
+	err := ReadRecords(candidatesPath, func(record json.RawMessage) error {
+		var candidate Candidate
+		if err := json.Unmarshal(record, &candidate); err != nil {
+			return err
+		}
+		dwells = append(dwells, candidateStageDwells(candidate, applications[candidate.ID])...)
+		return nil
+	})
+	return dwells, err
+}
+
+func leverStageDwells(createdAtStart string) ([]stageDwell, error) {
+	var err error
+	if resolver, err = NewResolver("stages,postings"); err != nil {
+		return nil, err
+	}
+
+	candidates := Endpoint{Method: "GET", SprintfPath: "/candidates"}
+	if createdAtStart != "" {
+		candidates.QueryParams = append(candidates.QueryParams, QueryParam{Field: "created_at_start", Value: createdAtStart})
+	}
+
+	applications := registeredEndpoints["downloadApplications"]
+
+	var dwells []stageDwell
+	for {
+		var leverData LeverData
+		if err := ExecuteLeverRequest(&candidates, &leverData); err != nil {
+			return nil, err
+		}
+
+		var page []Candidate
+		if err := json.Unmarshal(leverData.Data, &page); err != nil {
+			return nil, err
+		}
+		resolver.AnnotateCandidates(page)
+
+		for _, candidate := range page {
+			var apps []Application
+			applications.Arguments = []interface{}{candidate.ID}
+			applications.Cursor = ""
+			if err := FetchAllFrom(applications, &apps); err != nil {
+				return nil, fmt.Errorf("fetching applications for %s: %v", candidate.ID, err)
+			}
+			resolver.AnnotateApplications(apps)
+
+			dwells = append(dwells, candidateStageDwells(candidate, apps)...)
+		}
+
+		if !candidates.HasNext {
+			break
+		}
+	}
+	return dwells, nil
+}
+
+// candidateStageDwells lists the stays of a candidate in each stage they have
+// left, attributed to the posting of their latest application.
+func candidateStageDwells(candidate Candidate, applications []Application) []stageDwell {
+	var latest Application
+	for _, application := range applications {
+		if application.CreatedAt >= latest.CreatedAt {
+			latest = application
+		}
+	}
+
+	changes := append([]StageChange(nil), candidate.StageChanges...)
+	sort.SliceStable(changes, func(a, b int) bool {
+		return changes[a].UpdatedAt < changes[b].UpdatedAt
+	})
+
+	var dwells []stageDwell
+	for i := 0; i+1 < len(changes); i++ {
+		entered, left := changes[i], changes[i+1]
+		dwells = append(dwells, stageDwell{
+			posting:     latest.Posting,
+			postingText: latest.PostingText,
+			stage:       entered.ToStageID,
+			stageText:   entered.ToStageText,
+			enteredAt:   entered.UpdatedAt,
+			hours:       float64(left.UpdatedAt-entered.UpdatedAt) / float64(time.Hour/time.Millisecond),
+		})
+	}
+	return dwells
+}
+
+// StageDurations groups stays by posting, month entered and stage.
+func StageDurations(dwells []stageDwell, percentiles []float64) []StageDuration {
+	type key struct{ posting, month, stage string }
+	groups := map[key][]float64{}
+	rows := map[key]*StageDuration{}
+	for _, dwell := range dwells {
+		month := time.Unix(0, int64(dwell.enteredAt)*int64(time.Millisecond)).UTC().Format("2006-01")
+		k := key{dwell.posting, month, dwell.stage}
+		if rows[k] == nil {
+			rows[k] = &StageDuration{PostingID: dwell.posting, PostingText: dwell.postingText, Month: month, Stage: dwell.stage, StageText: dwell.stageText}
+		}
+		groups[k] = append(groups[k], dwell.hours)
+	}
+
+	keys := make([]key, 0, len(rows))
+	for k := range rows {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].posting != keys[b].posting {
+			return keys[a].posting < keys[b].posting
+		}
+		if keys[a].month != keys[b].month {
+			return keys[a].month < keys[b].month
+		}
+		return keys[a].stage < keys[b].stage
+	})
+
+	result := make([]StageDuration, 0, len(keys))
+	for _, k := range keys {
+		hours := groups[k]
+		sort.Float64s(hours)
+
+		row := rows[k]
+		row.Count = len(hours)
+		row.MedianHours = roundHours(Percentile(hours, 50))
+		row.Percentiles = map[string]float64{}
+		for _, p := range percentiles {
+			row.Percentiles[fmt.Sprintf("p%g", p)] = roundHours(Percentile(hours, p))
+		}
+		result = append(result, *row)
+	}
+	return result
+}
+
+func roundHours(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
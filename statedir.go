@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// stateDir holds what fulcrum keeps between runs such as checkpoints and run
+// locks. It can be moved with --state-dir.
+var stateDir = DefaultStateDir()
+
+// DefaultStateDir follows the XDG base directory spec, using
+// $XDG_STATE_HOME/fulcrum or ~/.local/state/fulcrum, and %LOCALAPPDATA% on
+// windows.
+func DefaultStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "fulcrum")
+	}
+
+	if runtime.GOOS == "windows" {
+		for _, env := range []string{"LOCALAPPDATA", "APPDATA"} {
+			if dir := os.Getenv(env); dir != "" {
+				return filepath.Join(dir, "fulcrum")
+			}
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "fulcrum")
+	}
+	return filepath.Join(home, ".local", "state", "fulcrum")
+}
+
+// StatePath returns the path of a file in the state directory, creating the
+// directory if needed. It is private to the user as checkpoints may hold
+// candidate ids.
+func StatePath(name string) string {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		logrus.Fatal("Unable to create state directory: ", err)
+	}
+	return filepath.Join(stateDir, name)
+}
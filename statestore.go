@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// StateStore holds what a run keeps for the next one: checkpoints,
+// watermarks, seen record versions, failures and run history. Values are
+// grouped into buckets and keyed within them.
+type StateStore interface {
+	// Get returns nil when the key has no value.
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	// Batch writes every put in one transaction, so all of them land or
+	// none do.
+	Batch(puts []StatePut) error
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	Close() error
+}
+
+// StatePut is a value to write with Batch.
+type StatePut struct {
+	Bucket string
+	Key    string
+	Value  []byte
+}
+
+// Buckets used in the state store.
+const (
+	checkpointBucket = "checkpoints"
+	watermarkBucket  = "watermarks"
+	versionBucket    = "versions"
+	failureBucket    = "failures"
+	runBucket        = "runs"
+	sessionBucket    = "sessions"
+)
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "state",
+		Description: "Print what a state store holds, or list the stores when not given one",
+		Run:         runState,
+	})
+}
+
+// Buckets printed by the state command.
+var stateBuckets = []string{checkpointBucket, watermarkBucket, failureBucket, runBucket, sessionBucket, versionBucket}
+
+func runState(args []string) error {
+	flags := NewCommandFlags("state")
+	bucket := flags.String("bucket", "", "Only print this bucket: "+strings.Join(stateBuckets, ", "))
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		paths, err := filepath.Glob(filepath.Join(stateDir, "*.db"))
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			fmt.Println(strings.TrimSuffix(filepath.Base(path), ".db"))
+		}
+		return nil
+	}
+
+	path := filepath.Join(stateDir, flags.Arg(0)+".db")
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	store, err := OpenStateStore(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, name := range stateBuckets {
+		if *bucket != "" && *bucket != name {
+			continue
+		}
+
+		err := store.ForEach(name, func(key string, value []byte) error {
+			return enc.Encode(struct {
+				Bucket string          `json:"bucket"`
+				Key    string          `json:"key"`
+				Value  json.RawMessage `json:"value"`
+			}{name, key, value})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateStores are the open stores by name, so a checkpoint and the
+// watermark of the same run share one database.
+var (
+	stateStoresMu sync.Mutex
+	stateStores   = map[string]StateStore{}
+)
+
+// OpenStateStore opens the named store in the state directory. Every
+// endpoint has its own so runs of different endpoints don't wait on each
+// other's database lock.
+func OpenStateStore(name string) (StateStore, error) {
+	stateStoresMu.Lock()
+	defer stateStoresMu.Unlock()
+
+	if store, ok := stateStores[name]; ok {
+		return store, nil
+	}
+
+	store, err := OpenBoltStore(StatePath(name + ".db"))
+	if err != nil {
+		return nil, err
+	}
+	stateStores[name] = store
+	return store, nil
+}
+
+// CloseStateStores closes every open store.
+func CloseStateStores() {
+	stateStoresMu.Lock()
+	defer stateStoresMu.Unlock()
+
+	for name, store := range stateStores {
+		store.Close()
+		delete(stateStores, name)
+	}
+}
+
+// BoltStore is a StateStore in a local bolt database.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("state database %s is in use by another run", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("opening state database %s: %v", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		// Bolt's values are only valid during the transaction
+		if v := b.Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s *BoltStore) Put(bucket, key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+func (s *BoltStore) Batch(puts []StatePut) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, put := range puts {
+			b, err := tx.CreateBucketIfNotExists([]byte(put.Bucket))
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put([]byte(put.Key), put.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// ForEach calls fn for every key of the bucket in key order.
+func (s *BoltStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), append([]byte{}, v...))
+		})
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// syncColumns are the columns sync reads from the header of its input. Only
+// candidateId is required, an empty value leaves that part of lever alone.
+// A tags value of noTags means the candidate should have none.
+var syncColumns = map[string]bool{
+	"candidateId":   true,
+	"tags":          true,
+	"stage":         true,
+	"archiveReason": true,
+}
+
+const noTags = "-"
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "sync",
+		Description: "Make lever's tags, stages and archive status match an authoritative csv, changing only what differs",
+		Run:         runSync,
+	})
+}
+
+func runSync(args []string) error {
+	flags := NewCommandFlags("sync")
+	input := flags.String("input", "", "Authoritative csv with a header of candidateId and any of tags, stage and archiveReason")
+	performAs := flags.String("performAs", "", "Lever user id the changes are made on behalf of")
+	separator := flags.String("tag-separator", ";", "Separates the tags in the tags column")
+	removeTags := flags.Bool("remove-tags", false, "Also remove tags lever has that the input does not list, a tags value of - removes them all")
+	dryRun := flags.Bool("dry-run", false, "Only report the differences, don't change lever")
+	planPath := flags.String("plan", "", "Also write the differences to this plan file, which `fulcrum apply` can make later")
+	flags.IntVar(&uploadBatchSize, "batch-size", 0, "Pause after this many candidates have been changed")
+	flags.DurationVar(&uploadBatchDelay, "batch-delay", 0, "How long to pause between batches, e.g. 30s")
+	flags.Parse(args)
+	RequireToken()
+
+	if *performAs == "" && !*dryRun {
+		return fmt.Errorf("lever requires --performAs to record who made the changes")
+	}
+
+	performAsParam := []QueryParam{{Field: "perform_as", Value: *performAs}}
+	tags := registeredEndpoints["uploadTags"]
+	tags.QueryParams = performAsParam
+	untag := Endpoint{Name: "Remove Tags", Type: "tags", Method: "POST", SprintfPath: "/candidates/%s/removeTags", QueryParams: performAsParam}
+	stages := registeredEndpoints["uploadStages"]
+	stages.QueryParams = performAsParam
+	archives := registeredEndpoints["uploadArchives"]
+	archives.QueryParams = performAsParam
+
+	f, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csvDialect.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("reading the input header: %v", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if !syncColumns[name] {
+			return fmt.Errorf("sync doesn't know the %q column", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["candidateId"]; !ok {
+		return fmt.Errorf("the input needs a candidateId column")
+	}
+
+	plan := Plan{Endpoint: "sync", Input: *input, CreatedAt: time.Now().UTC(), Mutations: []Mutation{}}
+	missing := 0
+	for row := 2; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("row %d: %v", row, err)
+		}
+
+		value := func(column string) string {
+			if i, ok := columns[column]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		candidateID := value("candidateId")
+		if !shard.Contains(candidateID) {
+			continue
+		}
+
+		candidate, err := FetchCandidate(candidateID)
+		if err != nil {
+			return fmt.Errorf("row %d: %v", row, err)
+		}
+
+		if candidate == nil {
+			logrus.WithFields(logrus.Fields{"row": row, "candidateId": candidateID}).Warn("Candidate not in lever")
+			missing++
+			continue
+		}
+
+		var mutations []Mutation
+		if listed := value("tags"); listed != "" {
+			want := []string{}
+			if listed == noTags {
+				listed = ""
+			}
+			for _, tag := range strings.Split(listed, *separator) {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					want = append(want, tag)
+				}
+			}
+
+			mutations = append(mutations, DiffTags(tags, candidate, want)...)
+			if *removeTags {
+				mutations = append(mutations, DiffRemovedTags(untag, candidate, want)...)
+			}
+		}
+
+		if stage := value("stage"); stage != "" {
+			mutations = append(mutations, DiffStage(stages, candidate, stage)...)
+		}
+
+		if reason := value("archiveReason"); reason != "" {
+			mutations = append(mutations, DiffArchive(archives, candidate, reason)...)
+		}
+
+		for i := range mutations {
+			mutations[i].Row = row
+			LogMutation(mutations[i])
+		}
+		plan.Mutations = append(plan.Mutations, mutations...)
+	}
+
+	logrus.WithFields(logrus.Fields{"changes": len(plan.Mutations), "missing": missing}).Info("Compared input with lever")
+
+	if *planPath != "" {
+		if err := WritePlan(*planPath, &plan); err != nil {
+			return err
+		}
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	var pacer Pacer
+	for i, mutation := range plan.Mutations {
+		if err := ApplyMutation(mutation); err != nil {
+			return fmt.Errorf("applied %d of %d changes, row %d failed: %v", i, len(plan.Mutations), mutation.Row, err)
+		}
+
+		if i+1 == len(plan.Mutations) || plan.Mutations[i+1].Row != mutation.Row {
+			pacer.Done()
+		}
+	}
+
+	logrus.Infof("Applied %d changes", len(plan.Mutations))
+	return nil
+}
@@ -0,0 +1,696 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// extractResumeText is set with --extract-text.
+var extractResumeText = false
+
+// maxResumeTextSize caps the bytes read for a resume's text, both the file
+// downloaded and each pdf stream inflated from it. A resume runs to a few
+// pages, anything much larger isn't worth holding in memory and is left
+// without text. --max-file-size lowers the cap.
+var maxResumeTextSize int64 = 32 << 20
+
+// textExtractors turn a file of each extension into plain text.
+var textExtractors = map[string]func(data []byte) (string, error){
+	"pdf":  ExtractPDFText,
+	"docx": ExtractDOCXText,
+	"txt":  extractPlainText,
+}
+
+// AttachResumeText downloads each resume file lever has and sets its text.
+// Files that can't be read are logged and left without text, it is the
+// download failing that fails the run.
+func AttachResumeText(resumes []Resume) error {
+	maxSize := maxResumeTextSize
+	if fileFilter != nil && fileFilter.MaxSize > 0 && fileFilter.MaxSize < maxSize {
+		maxSize = fileFilter.MaxSize
+	}
+
+	for i := range resumes {
+		resume := &resumes[i]
+		fields := logrus.Fields{"candidateId": resume.CandidateID, "resumeId": resume.ID}
+
+		ext := fileExt(CandidateFile{Name: resume.File.Name, Ext: resume.File.Ext})
+		extract, ok := textExtractors[ext]
+		if !ok {
+			logrus.WithFields(fields).Debug("No text extraction for ", ext, " resumes")
+			continue
+		}
+
+		url := resume.File.DownloadURL
+		if url == "" {
+			endpoint := Endpoint{SprintfPath: "/candidates/%s/resumes/%s/download", Arguments: []interface{}{resume.CandidateID, resume.ID}}
+			url = endpoint.URLString()
+		}
+
+		data, err := DownloadFile(url, maxSize, fields)
+		if err == errFileTooLarge {
+			logrus.WithFields(fields).Info("Skipping text of resume over ", maxSize, " bytes")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		text, err := extract(data)
+		if err != nil {
+			logrus.WithFields(fields).Warn("Unable to extract resume text: ", err)
+			continue
+		}
+		resume.Text = text
+	}
+	return nil
+}
+
+func extractPlainText(data []byte) (string, error) {
+	if !utf8.Valid(data) {
+		return "", errors.New("text is not utf-8")
+	}
+	return normalizeText(string(data)), nil
+}
+
+// ExtractDOCXText reads the paragraphs of a Word document's body.
+func ExtractDOCXText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("reading docx: %v", err)
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+
+		var b strings.Builder
+		inText := false
+		decoder := xml.NewDecoder(r)
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				return normalizeText(b.String()), nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("reading docx: %v", err)
+			}
+
+			switch t := token.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "t":
+					inText = true
+				case "tab":
+					b.WriteByte('\t')
+				case "br", "cr":
+					b.WriteByte('\n')
+				}
+			case xml.EndElement:
+				switch t.Name.Local {
+				case "t":
+					inText = false
+				case "p":
+					b.WriteByte('\n')
+				}
+			case xml.CharData:
+				if inText {
+					b.Write(t)
+				}
+			}
+		}
+	}
+	return "", errors.New("docx has no word/document.xml")
+}
+
+// ExtractPDFText pulls the text shown by a pdf's content streams. It is not
+// a layout engine, text comes out in the order it is drawn, which for the
+// word processors resumes come from is reading order. Fonts with a
+// ToUnicode map are decoded through it.
+func ExtractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", errors.New("not a pdf")
+	}
+
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", errors.New("pdf is encrypted")
+	}
+
+	cmap := pdfCMap{codes: map[string]string{}}
+	var contents [][]byte
+	for _, stream := range pdfStreams(data) {
+		if bytes.Contains(stream, []byte("begincmap")) {
+			cmap.parse(stream)
+		} else {
+			contents = append(contents, stream)
+		}
+	}
+
+	var b strings.Builder
+	for _, content := range contents {
+		pdfContentText(content, &cmap, &b)
+	}
+	return normalizeText(b.String()), nil
+}
+
+// pdfStreams returns the decoded streams that may hold text or character
+// maps, skipping images, fonts and streams in filters other than flate.
+func pdfStreams(data []byte) [][]byte {
+	var streams [][]byte
+	pos := 0
+	for {
+		i := bytes.Index(data[pos:], []byte("stream"))
+		if i < 0 {
+			return streams
+		}
+
+		start := pos + i
+		if start >= 3 && string(data[start-3:start]) == "end" {
+			pos = start + len("stream")
+			continue
+		}
+
+		body := start + len("stream")
+		if body < len(data) && data[body] == '\r' {
+			body++
+		}
+		if body < len(data) && data[body] == '\n' {
+			body++
+		}
+
+		end := bytes.Index(data[body:], []byte("endstream"))
+		if end < 0 {
+			return streams
+		}
+
+		dict := data[pos:start]
+		if obj := bytes.LastIndex(dict, []byte("obj")); obj >= 0 {
+			dict = dict[obj:]
+		}
+		raw := data[body : body+end]
+		pos = body + end + len("endstream")
+
+		if skipPDFStream(dict) {
+			continue
+		}
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			inflated, err := inflate(raw)
+			if err != nil {
+				continue
+			}
+			raw = inflated
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+		streams = append(streams, raw)
+	}
+}
+
+var skippedPDFStreams = [][]byte{
+	[]byte("/Image"), []byte("/XRef"), []byte("/ObjStm"), []byte("/Metadata"),
+	[]byte("/Length1"), []byte("/Length2"), []byte("/FontFile"), []byte("/Type1C"),
+	[]byte("/CIDFontType0C"), []byte("/OpenType"), []byte("/Alternate"),
+}
+
+func skipPDFStream(dict []byte) bool {
+	for _, marker := range skippedPDFStreams {
+		if bytes.Contains(dict, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func inflate(raw []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Streams are often followed by an end of line that isn't part of them
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxResumeTextSize+1))
+	if err == io.ErrUnexpectedEOF && len(data) > 0 {
+		err = nil
+	}
+	if int64(len(data)) > maxResumeTextSize {
+		return nil, fmt.Errorf("stream inflates to over %d bytes", maxResumeTextSize)
+	}
+	return data, err
+}
+
+// pdfCMap maps character codes to text, merged across every ToUnicode map
+// in the document. Codes are kept as hex so their width is part of the key.
+type pdfCMap struct {
+	codes  map[string]string
+	widths []int
+}
+
+func (m *pdfCMap) parse(stream []byte) {
+	lex := pdfLexer{data: stream}
+	var operands []pdfToken
+	mode := ""
+	for {
+		token, ok := lex.next()
+		if !ok {
+			return
+		}
+
+		switch {
+		case token.kind == pdfOperator && (token.text == "beginbfchar" || token.text == "beginbfrange"):
+			mode = token.text
+			operands = nil
+		case token.kind == pdfOperator && (token.text == "endbfchar" || token.text == "endbfrange"):
+			mode = ""
+		case mode == "beginbfchar" && token.kind == pdfString:
+			operands = append(operands, token)
+			if len(operands) == 2 {
+				m.add(operands[0].value, utf16Text(operands[1].value))
+				operands = nil
+			}
+		case mode == "beginbfrange" && (token.kind == pdfString || token.kind == pdfArray):
+			operands = append(operands, token)
+			if len(operands) == 3 {
+				m.addRange(operands[0].value, operands[1].value, operands[2])
+				operands = nil
+			}
+		}
+	}
+}
+
+func (m *pdfCMap) add(code []byte, text string) {
+	known := false
+	for _, width := range m.widths {
+		known = known || width == len(code)
+	}
+	if !known {
+		// Longer codes are tried first
+		m.widths = append(m.widths, len(code))
+		sort.Sort(sort.Reverse(sort.IntSlice(m.widths)))
+	}
+	m.codes[fmt.Sprintf("%X", code)] = text
+}
+
+func (m *pdfCMap) addRange(lo, hi []byte, dst pdfToken) {
+	if len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+		return
+	}
+
+	start, end := codeValue(lo), codeValue(hi)
+	if end < start || end-start > 0xFFFF {
+		return
+	}
+
+	for code := start; code <= end; code++ {
+		offset := int(code - start)
+		var text string
+		if dst.kind == pdfArray {
+			if offset >= len(dst.array) {
+				break
+			}
+			text = utf16Text(dst.array[offset].value)
+		} else {
+			// The destination's last code unit counts up through the range
+			units := utf16Units(dst.value)
+			if len(units) == 0 {
+				return
+			}
+			units[len(units)-1] += uint16(offset)
+			text = string(utf16.Decode(units))
+		}
+		m.add(codeBytes(code, len(lo)), text)
+	}
+}
+
+// decode maps a shown string through the map, falling back to reading
+// bytes as latin-1 for fonts the map doesn't cover.
+func (m *pdfCMap) decode(s []byte) string {
+	if len(m.codes) == 0 {
+		return latin1(s)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		matched := false
+		for _, width := range m.widths {
+			if i+width > len(s) {
+				continue
+			}
+			if text, ok := m.codes[fmt.Sprintf("%X", s[i:i+width])]; ok {
+				b.WriteString(text)
+				i += width
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			b.WriteRune(rune(s[i]))
+			i++
+		}
+	}
+	return b.String()
+}
+
+func codeValue(code []byte) uint32 {
+	var v uint32
+	for _, c := range code {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+func codeBytes(v uint32, width int) []byte {
+	code := make([]byte, width)
+	for i := width - 1; i >= 0; i-- {
+		code[i] = byte(v)
+		v >>= 8
+	}
+	return code
+}
+
+func utf16Units(b []byte) []uint16 {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return units
+}
+
+func utf16Text(b []byte) string {
+	return string(utf16.Decode(utf16Units(b)))
+}
+
+func latin1(s []byte) string {
+	runes := make([]rune, len(s))
+	for i, c := range s {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// pdfContentText writes the text shown by a content stream's text operators.
+func pdfContentText(content []byte, cmap *pdfCMap, b *strings.Builder) {
+	lex := pdfLexer{data: content}
+	var operands []pdfToken
+	for {
+		token, ok := lex.next()
+		if !ok {
+			return
+		}
+
+		if token.kind != pdfOperator {
+			operands = append(operands, token)
+			continue
+		}
+
+		switch token.text {
+		case "Tj":
+			writeShown(operands, cmap, b)
+		case "'", "\"":
+			b.WriteByte('\n')
+			writeShown(operands, cmap, b)
+		case "TJ":
+			if len(operands) > 0 && operands[len(operands)-1].kind == pdfArray {
+				for _, element := range operands[len(operands)-1].array {
+					switch {
+					case element.kind == pdfString:
+						b.WriteString(cmap.decode(element.value))
+					case element.kind == pdfNumber && element.number < -250:
+						// A large kerning gap is a space between words
+						b.WriteByte(' ')
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 && operands[len(operands)-1].number != 0 {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(' ')
+			}
+		case "T*", "ET":
+			b.WriteByte('\n')
+		case "Tm":
+			b.WriteByte(' ')
+		}
+		operands = nil
+	}
+}
+
+func writeShown(operands []pdfToken, cmap *pdfCMap, b *strings.Builder) {
+	if len(operands) > 0 && operands[len(operands)-1].kind == pdfString {
+		b.WriteString(cmap.decode(operands[len(operands)-1].value))
+	}
+}
+
+const (
+	pdfOperator = iota
+	pdfString
+	pdfNumber
+	pdfName
+	pdfArray
+	pdfOther
+)
+
+type pdfToken struct {
+	kind   int
+	text   string
+	value  []byte
+	number float64
+	array  []pdfToken
+}
+
+// pdfLexer reads the tokens of a content stream or character map.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func (l *pdfLexer) next() (pdfToken, bool) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return pdfToken{}, false
+	}
+
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		return pdfToken{kind: pdfString, value: l.literal()}, true
+	case c == '<' && l.peek(1) == '<', c == '>' && l.peek(1) == '>':
+		l.pos += 2
+		return pdfToken{kind: pdfOther}, true
+	case c == '<':
+		return pdfToken{kind: pdfString, value: l.hex()}, true
+	case c == '[':
+		l.pos++
+		array := pdfToken{kind: pdfArray}
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.data) {
+				return array, true
+			}
+			if l.data[l.pos] == ']' {
+				l.pos++
+				return array, true
+			}
+
+			element, ok := l.next()
+			if !ok {
+				return array, true
+			}
+			array.array = append(array.array, element)
+		}
+	case c == '/':
+		l.pos++
+		return pdfToken{kind: pdfName, text: l.regular()}, true
+	case c == ']' || c == '{' || c == '}' || c == ')' || c == '>':
+		l.pos++
+		return pdfToken{kind: pdfOther}, true
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		text := l.regular()
+		var number float64
+		fmt.Sscanf(text, "%g", &number)
+		return pdfToken{kind: pdfNumber, text: text, number: number}, true
+	}
+
+	text := l.regular()
+	if text == "ID" {
+		l.skipInlineImage()
+	}
+	return pdfToken{kind: pdfOperator, text: text}, true
+}
+
+func (l *pdfLexer) peek(offset int) byte {
+	if l.pos+offset < len(l.data) {
+		return l.data[l.pos+offset]
+	}
+	return 0
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		switch c := l.data[l.pos]; {
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case isPDFSpace(c):
+			l.pos++
+		default:
+			return
+		}
+	}
+}
+
+// regular reads a run of regular characters, at least one so the lexer
+// always moves forward.
+func (l *pdfLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	if l.pos == start {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+func (l *pdfLexer) literal() []byte {
+	var s []byte
+	depth := 0
+	l.pos++
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return s
+			}
+			depth--
+		case '\\':
+			if l.pos >= len(l.data) {
+				return s
+			}
+
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// An escaped end of line continues the string
+				if e == '\r' && l.peek(0) == '\n' {
+					l.pos++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for n := 0; n < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; n++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+func (l *pdfLexer) hex() []byte {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	s := make([]byte, len(digits)/2)
+	for i := range s {
+		fmt.Sscanf(string(digits[2*i:2*i+2]), "%02x", &s[i])
+	}
+	return s
+}
+
+// skipInlineImage moves past the binary data of an inline image to its EI.
+func (l *pdfLexer) skipInlineImage() {
+	for l.pos+2 < len(l.data) {
+		if isPDFSpace(l.data[l.pos]) && l.data[l.pos+1] == 'E' && l.data[l.pos+2] == 'I' &&
+			(l.pos+3 == len(l.data) || isPDFSpace(l.data[l.pos+3])) {
+			l.pos += 3
+			return
+		}
+		l.pos++
+	}
+	l.pos = len(l.data)
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// normalizeText trims lines and collapses runs of spaces and blank lines.
+func normalizeText(text string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+)
+
+// testPDF lays out a pdf with a stream object for each of streams, given as
+// their dictionary and contents.
+func testPDF(streams ...[2]string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, stream := range streams {
+		fmt.Fprintf(&b, "%d 0 obj\n<< %s /Length %d >>\nstream\n%s\nendstream\nendobj\n", i+1, stream[0], len(stream[1]), stream[1])
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func deflated(s string) string {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.String()
+}
+
+func TestExtractPDFText(t *testing.T) {
+	cmap := "/CIDInit /ProcSet findresource begin begincmap\n" +
+		"1 beginbfchar <01> <0048> endbfchar\n" +
+		"1 beginbfrange <02> <03> <0069> endbfrange\n" +
+		"endcmap end"
+
+	tests := []struct {
+		name string
+		pdf  []byte
+		want string
+		err  bool
+	}{
+		{
+			name: "Tj",
+			pdf:  testPDF([2]string{"", "BT /F1 12 Tf 72 720 Td (Jane Doe) Tj 0 -14 Td (Software Engineer) Tj ET"}),
+			want: "Jane Doe\nSoftware Engineer",
+		},
+		{
+			name: "TJ with kerning",
+			pdf:  testPDF([2]string{"", "BT [(Go)-20(pher)-400(Inc)] TJ ET"}),
+			want: "Gopher Inc",
+		},
+		{
+			name: "escapes",
+			pdf:  testPDF([2]string{"", `BT (R\351sum\351 \(2024\)) Tj ET`}),
+			want: "Résumé (2024)",
+		},
+		{
+			name: "flate",
+			pdf:  testPDF([2]string{"/Filter /FlateDecode", deflated("BT (Compressed text) Tj ET")}),
+			want: "Compressed text",
+		},
+		{
+			name: "to unicode",
+			pdf:  testPDF([2]string{"", cmap}, [2]string{"", "BT <010203> Tj ET"}),
+			want: "Hij",
+		},
+		{
+			name: "images skipped",
+			pdf:  testPDF([2]string{"/Subtype /Image", "BT (not text) Tj ET"}, [2]string{"", "BT (text) Tj ET"}),
+			want: "text",
+		},
+		{
+			name: "not a pdf",
+			pdf:  []byte("PK\x03\x04"),
+			err:  true,
+		},
+		{
+			name: "encrypted",
+			pdf:  append(testPDF([2]string{"", "BT (secret) Tj ET"}), "trailer << /Encrypt 9 0 R >>"...),
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := ExtractPDFText(test.pdf)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", test.name, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// testDOCX zips files, named by their path, into a docx.
+func testDOCX(t *testing.T, files map[string]string) []byte {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, contents := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(contents))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestExtractDOCXText(t *testing.T) {
+	document := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:body>
+<w:p><w:r><w:t>Jane</w:t></w:r><w:r><w:t xml:space="preserve"> Doe</w:t></w:r></w:p>
+<w:p><w:r><w:t>Skills</w:t><w:tab/><w:t>Go &amp; SQL</w:t></w:r></w:p>
+<w:p><w:r><w:t>Line one</w:t><w:br/><w:t>Line two</w:t></w:r></w:p>
+<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr></w:p>
+<w:p><w:r><w:instrText>PAGE</w:instrText><w:t>Last</w:t></w:r></w:p>
+</w:body>
+</w:document>`
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+		err   bool
+	}{
+		{
+			name:  "paragraphs",
+			files: map[string]string{"[Content_Types].xml": "<Types/>", "word/document.xml": document},
+			want:  "Jane Doe\nSkills Go & SQL\nLine one\nLine two\n\nLast",
+		},
+		{
+			name:  "no document",
+			files: map[string]string{"word/styles.xml": "<w:styles/>"},
+			err:   true,
+		},
+		{
+			name:  "bad xml",
+			files: map[string]string{"word/document.xml": "<w:document><w:p>"},
+			err:   true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := ExtractDOCXText(testDOCX(t, test.files))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", test.name, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	if _, err := ExtractDOCXText([]byte("not a zip")); err == nil {
+		t.Error("a file that isn't a zip should fail")
+	}
+}
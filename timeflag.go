@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTime reads the time given to --since or --until: an RFC3339
+// timestamp, a YYYY-MM-DD date taken as midnight UTC, or an age before now
+// such as -7d or -36h.
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+
+	if at, err := time.Parse("2006-01-02", value); err == nil {
+		return at, nil
+	}
+
+	if age, err := ParseAge(strings.TrimPrefix(value, "-")); err == nil {
+		return now.Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("unable to parse time %q, expected RFC3339, YYYY-MM-DD or an age such as -7d", value)
+}
+
+// EpochMillis formats a time the way lever's created_at params expect.
+func EpochMillis(at time.Time) string {
+	return strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10)
+}
+
+// ResolveTimeWindow turns --since and --until into the createdAtStart and
+// createdAtEnd epoch milliseconds sent to lever.
+func ResolveTimeWindow(config *Config, now time.Time) error {
+	if config.Since != "" {
+		if config.CreatedAtStart != "" {
+			return fmt.Errorf("--since and --createdAtStart both set the start of the window, use one")
+		}
+
+		at, err := ParseTime(config.Since, now)
+		if err != nil {
+			return err
+		}
+		config.CreatedAtStart = EpochMillis(at)
+	}
+
+	if config.Until != "" {
+		at, err := ParseTime(config.Until, now)
+		if err != nil {
+			return err
+		}
+		config.CreatedAtEnd = EpochMillis(at)
+
+		if start, err := strconv.ParseInt(config.CreatedAtStart, 10, 64); err == nil && start > at.UnixNano()/int64(time.Millisecond) {
+			return fmt.Errorf("--until %s is before the start of the window", config.Until)
+		}
+	}
+	return nil
+}
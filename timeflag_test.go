@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+		err   bool
+	}{
+		{value: "2024-01-02T03:04:05Z", want: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{value: "2024-01-02T03:04:05+02:00", want: time.Date(2024, 1, 2, 1, 4, 5, 0, time.UTC)},
+		{value: "2024-01-02", want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{value: " 2024-01-02 ", want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{value: "-7d", want: time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)},
+		{value: "-36h", want: time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{value: "2w", want: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{value: "", err: true},
+		{value: "yesterday", err: true},
+		{value: "2024-13-01", err: true},
+		{value: "-0d", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseTime(test.value, now)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseTime(%q) = %v, want an error", test.value, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseTime(%q) failed: %v", test.value, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Mutation is one concrete change to make in lever, worked out by comparing
+// an input row with the candidate's current state.
+type Mutation struct {
+	Row         int             `json:"row"`
+	CandidateID string          `json:"candidateId"`
+	Op          string          `json:"op"`
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	Before      interface{}     `json:"before,omitempty"`
+	After       interface{}     `json:"after,omitempty"`
+
+	// Key identifies the change so it is made at most once, it is sent as
+	// the Idempotency-Key header.
+	Key string `json:"key"`
+
+	// UpdatedAt is the candidate's updatedAt when the mutation was planned.
+	// The mutation is skipped if the candidate has changed since.
+	UpdatedAt int `json:"updatedAt,omitempty"`
+}
+
+// MutationResult is written for every mutation sent to lever.
+type MutationResult struct {
+	Mutation
+	AppliedAt time.Time `json:"appliedAt"`
+	Status    int       `json:"status"`
+	Error     string    `json:"error,omitempty"`
+
+	// AlreadyApplied is set when an attempt failed but lever turned out to
+	// have made the change, so it was not sent again.
+	AlreadyApplied bool `json:"alreadyApplied,omitempty"`
+
+	// Conflict is set when the candidate changed after the mutation was
+	// planned, e.g. a recruiter edited them in lever, and it was skipped.
+	Conflict bool `json:"conflict,omitempty"`
+}
+
+// UploadColumn describes a column of an upload endpoint's input csv. Kind is
+// id for lever ids, text for free text, or the --resolve reference data the
+// value must be an id of, e.g. stages.
+type UploadColumn struct {
+	Name string
+	Kind string
+	// Repeated columns take every remaining value of the row
+	Repeated bool
+}
+
+// Planner works out the mutations needed for one input row, the candidate
+// id followed by the endpoint's columns. Rows already reflected in lever
+// need no mutations.
+type Planner func(endpoint Endpoint, row []string) ([]Mutation, error)
+
+// NewMutation builds a mutation against the endpoint for a candidate.
+func NewMutation(endpoint Endpoint, op, candidateID string, body, before, after interface{}) Mutation {
+	endpoint.Arguments = []interface{}{candidateID}
+	mutation := Mutation{
+		CandidateID: candidateID,
+		Op:          op,
+		Method:      endpoint.Method,
+		URL:         endpoint.URLString(),
+		Before:      before,
+		After:       after,
+	}
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		mutation.Body = data
+	}
+
+	// The same row against the same state always gets the same key
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%s", op, candidateID, endpoint.Method, endpoint.URL(), mutation.Body)
+	mutation.Key = hex.EncodeToString(h.Sum(nil))[:32]
+	return mutation
+}
+
+// CandidateMutation is NewMutation for a change worked out from the
+// candidate's current state, which is remembered to detect conflicts.
+func CandidateMutation(endpoint Endpoint, op string, candidate *Candidate, body, before, after interface{}) Mutation {
+	mutation := NewMutation(endpoint, op, candidate.ID, body, before, after)
+	mutation.UpdatedAt = candidate.UpdatedAt
+	return mutation
+}
+
+// verifiedCandidates are candidates that had not changed since planning when
+// their first mutation was applied. Later mutations of theirs in the same run
+// are expected to find them changed.
+var verifiedCandidates = map[string]bool{}
+
+// CheckConflict reports if the candidate changed since the mutation was
+// planned. A candidate that already reflects the mutation, e.g. as a resumed
+// apply finds it, was changed by the mutation itself and is reported applied
+// instead.
+func CheckConflict(mutation Mutation) (conflict, applied bool, err error) {
+	if mutation.UpdatedAt == 0 || verifiedCandidates[mutation.CandidateID] {
+		return false, false, nil
+	}
+
+	candidate, err := FetchCandidate(mutation.CandidateID)
+	if err != nil {
+		return false, false, err
+	}
+
+	if candidate != nil && candidate.UpdatedAt != mutation.UpdatedAt {
+		if applied, err = MutationAppliedTo(candidate, mutation); err != nil || !applied {
+			return !applied, false, err
+		}
+	}
+	verifiedCandidates[mutation.CandidateID] = true
+	return false, applied, nil
+}
+
+// FetchCandidate returns the candidate's current state, or nil when lever
+// doesn't know the candidate.
+func FetchCandidate(candidateID string) (*Candidate, error) {
+	endpoint := Endpoint{Method: "GET", SprintfPath: "/candidates/%s", Arguments: []interface{}{candidateID}}
+	req, err := http.NewRequest(endpoint.Method, endpoint.URLString(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = WithRequestFields(req, logrus.Fields{"candidateId": candidateID})
+
+	resp, body, err := SendLeverRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewLeverError(resp, body)
+	}
+
+	var page struct {
+		Data Candidate `json:"data"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, err
+	}
+
+	if page.Data.ID == "" {
+		page.Data.ID = candidateID
+	}
+	return &page.Data, nil
+}
+
+// uploadBatchSize and uploadBatchDelay pace uploads, after every batch of
+// rows that changed something the upload pauses for the delay.
+var (
+	uploadBatchSize  = 0
+	uploadBatchDelay time.Duration
+)
+
+// Pacer counts rows sent to lever and pauses between batches.
+type Pacer struct {
+	rows int
+}
+
+// Done records a row that was written to lever.
+func (p *Pacer) Done() {
+	p.rows++
+	if uploadBatchSize <= 0 || p.rows%uploadBatchSize != 0 {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"rows": p.rows, "delay": uploadBatchDelay.String()}).Info("Finished upload batch")
+	time.Sleep(uploadBatchDelay)
+}
+
+// PlanRows reads the input csv and plans the mutations for every row in this
+// process' shard, calling fn with each row's mutations. Rows for which skip
+// returns true are not planned.
+func PlanRows(endpoint Endpoint, input string, skip func(row int) bool, fn func(row int, mutations []Mutation) error) error {
+	if !endpoint.HasQueryParam("perform_as") {
+		return fmt.Errorf("lever requires --performAs to record who made the changes")
+	}
+
+	r, firstRow, f, err := OpenUploadRows(endpoint, input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for row := firstRow; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("row %d: %v", row, err)
+		}
+
+		candidateID := strings.TrimSpace(record[0])
+		if !shard.Contains(candidateID) {
+			continue
+		}
+		record[0] = candidateID
+
+		if skip != nil && skip(row) {
+			continue
+		}
+
+		mutations, err := endpoint.Planner(endpoint, record)
+		if err != nil {
+			return fmt.Errorf("planning row %d for %s: %v", row, candidateID, err)
+		}
+
+		for i := range mutations {
+			mutations[i].Row = row
+		}
+
+		if err := fn(row, mutations); err != nil {
+			return err
+		}
+	}
+}
+
+// Upload plans and applies every row of the input straight away, use the
+// plan and apply commands to review the changes first. An interrupted upload
+// resumes after the last row it finished when given the same input again,
+// another input starts over from its first row.
+func Upload(endpoint Endpoint, input string, state *Checkpoint) error {
+	source, err := UploadSource(input)
+	if err != nil {
+		return err
+	}
+
+	resumeRow := state.ResumeRow()
+	if resumeRow > 0 && state.ResumeSource() != source {
+		// Another input's rows would be skipped by number
+		logrus.Warnf("Discarding the progress of an interrupted upload of %s, which stopped after row %d", state.ResumeSource(), resumeRow)
+		resumeRow = 0
+		state.UpdateLastRow(0)
+	}
+	state.UpdateSource(source)
+
+	if resumeRow > 0 {
+		logrus.Info("Resuming upload after row ", resumeRow)
+	}
+
+	skip := func(row int) bool {
+		return row <= resumeRow
+	}
+
+	var pacer Pacer
+	err = PlanRows(endpoint, input, skip, func(row int, mutations []Mutation) error {
+		for _, mutation := range mutations {
+			// Planned a moment ago, there is no window for a conflict
+			verifiedCandidates[mutation.CandidateID] = true
+
+			if err := ApplyMutation(mutation); err != nil {
+				return err
+			}
+		}
+
+		state.UpdateLastRow(row)
+		state.CheckPoint()
+
+		if len(mutations) > 0 {
+			pacer.Done()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The next upload is likely a different input, don't skip its rows
+	state.Remove()
+	return nil
+}
+
+// UploadSource identifies the rows an upload reads, the input file the same
+// way PlanSource does or the ids given with --ids.
+func UploadSource(input string) (string, error) {
+	if len(candidateIDs) > 0 {
+		sum := sha256.Sum256([]byte(strings.Join(candidateIDs, "\n")))
+		return "--ids@" + hex.EncodeToString(sum[:8]), nil
+	}
+	return PlanSource(input)
+}
+
+// ApplyMutation sends a mutation to lever and outputs the result. Erasures
+// are confirmed the same way the anonymize endpoint does.
+func ApplyMutation(mutation Mutation) error {
+	if mutation.Op == "erase" {
+		erasure := requestErasure(mutation.Method, mutation.URL, mutation.CandidateID)
+		audit.Write(AuditEntry{Event: "erasure", URL: mutation.URL, Status: erasure.Status, Error: erasure.Error})
+		Output(erasure, enc)
+
+		// Not marking the row done means the erasure is retried on resume
+		if erasure.Error != "" {
+			return fmt.Errorf("erasing candidate %s: %s", mutation.CandidateID, erasure.Error)
+		}
+		return nil
+	}
+
+	result := MutationResult{Mutation: mutation, AppliedAt: time.Now().UTC()}
+
+	conflict, applied, err := CheckConflict(mutation)
+	if err != nil {
+		return err
+	}
+
+	if applied {
+		result.AlreadyApplied = true
+		logrus.WithFields(logrus.Fields{"row": mutation.Row, "candidateId": mutation.CandidateID, "op": mutation.Op}).Info("Skipping change, lever already reflects it")
+		audit.Write(AuditEntry{Event: mutation.Op, Method: mutation.Method, URL: mutation.URL})
+		Output(result, enc)
+		return nil
+	}
+
+	if conflict {
+		result.Conflict = true
+		result.Error = "candidate changed in lever after the change was planned"
+		logrus.WithFields(logrus.Fields{"row": mutation.Row, "candidateId": mutation.CandidateID, "op": mutation.Op}).Warn("Skipping change, the candidate was changed since it was planned")
+		audit.Write(AuditEntry{Event: mutation.Op, Method: mutation.Method, URL: mutation.URL, Error: result.Error})
+		Output(result, enc)
+		return nil
+	}
+
+	var body io.Reader
+	if mutation.Body != nil {
+		body = bytes.NewReader(mutation.Body)
+	}
+
+	req, err := http.NewRequest(mutation.Method, mutation.URL, body)
+	if err != nil {
+		return err
+	}
+	if mutation.Body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if mutation.Key != "" {
+		req.Header.Set("Idempotency-Key", mutation.Key)
+	}
+	req = WithRequestFields(req, logrus.Fields{"candidateId": mutation.CandidateID, "row": mutation.Row, "key": mutation.Key})
+
+	failure := sendMutation(req, mutation, &result)
+	if failure != nil {
+		result.Error = failure.Error()
+	}
+
+	audit.Write(AuditEntry{Event: mutation.Op, Method: mutation.Method, URL: mutation.URL, Status: result.Status, Error: result.Error})
+	Output(result, enc)
+	return failure
+}
+
+// errMutationApplied stops the retries of a mutation lever turned out to
+// have made.
+var errMutationApplied = errors.New("mutation already applied")
+
+// sendMutation sends a mutation through SendLeverRequest. A timeout or server
+// error doesn't say if lever made the change, so before sending it again the
+// candidate is checked and a change already made is not repeated.
+func sendMutation(req *http.Request, mutation Mutation, result *MutationResult) error {
+	req = WithRetryCheck(req, func(failure error) error {
+		applied, err := MutationApplied(mutation)
+		if err != nil {
+			return fmt.Errorf("%v, and checking if it was applied failed: %v", failure, err)
+		}
+
+		if applied {
+			result.AlreadyApplied = true
+			return errMutationApplied
+		}
+		return nil
+	})
+
+	resp, body, err := SendLeverRequest(req)
+	if resp != nil {
+		result.Status = resp.StatusCode
+	}
+
+	switch {
+	case err == errMutationApplied:
+		return nil
+	case err != nil:
+		return err
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return NewLeverError(resp, body)
+	}
+	return nil
+}
+
+// MutationApplied reports if the candidate already reflects the mutation.
+func MutationApplied(mutation Mutation) (bool, error) {
+	candidate, err := FetchCandidate(mutation.CandidateID)
+	if err != nil {
+		return false, err
+	}
+	return MutationAppliedTo(candidate, mutation)
+}
+
+// MutationAppliedTo reports if the candidate's current state, nil when lever
+// doesn't know them, already has the mutation's after state.
+func MutationAppliedTo(candidate *Candidate, mutation Mutation) (bool, error) {
+	if candidate == nil {
+		return mutation.Op == "erase", nil
+	}
+
+	switch mutation.Op {
+	case "addTags":
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(mutation.Body, &body); err != nil {
+			return false, err
+		}
+
+		has := map[string]bool{}
+		for _, tag := range candidate.Tags {
+			has[tag] = true
+		}
+		for _, tag := range body.Tags {
+			if !has[tag] {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "removeTags":
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(mutation.Body, &body); err != nil {
+			return false, err
+		}
+
+		remove := map[string]bool{}
+		for _, tag := range body.Tags {
+			remove[tag] = true
+		}
+		for _, tag := range candidate.Tags {
+			if remove[tag] {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "moveStage":
+		return candidate.Stage == mutation.After, nil
+	case "archive":
+		return candidate.Archived.Reason == mutation.After || candidate.Archived.ArchivedReason == mutation.After, nil
+	}
+	return false, nil
+}
+
+// fetchPlanCandidate fetches the candidate an upload row is for.
+func fetchPlanCandidate(candidateID string) (*Candidate, error) {
+	candidate, err := FetchCandidate(candidateID)
+	if err == nil && candidate == nil {
+		err = fmt.Errorf("candidate not found")
+	}
+	return candidate, err
+}
+
+// planTags plans adding the tags in the row's remaining columns that the
+// candidate doesn't have yet.
+func planTags(endpoint Endpoint, row []string) ([]Mutation, error) {
+	candidate, err := fetchPlanCandidate(row[0])
+	if err != nil {
+		return nil, err
+	}
+	return DiffTags(endpoint, candidate, row[1:]), nil
+}
+
+// planStage plans moving the candidate to the stage id in the second column.
+func planStage(endpoint Endpoint, row []string) ([]Mutation, error) {
+	if len(row) < 2 || strings.TrimSpace(row[1]) == "" {
+		return nil, fmt.Errorf("expected a stage id after the candidate id")
+	}
+
+	candidate, err := fetchPlanCandidate(row[0])
+	if err != nil {
+		return nil, err
+	}
+	return DiffStage(endpoint, candidate, strings.TrimSpace(row[1])), nil
+}
+
+// planArchive plans archiving the candidate with the archive reason id in the
+// second column.
+func planArchive(endpoint Endpoint, row []string) ([]Mutation, error) {
+	if len(row) < 2 || strings.TrimSpace(row[1]) == "" {
+		return nil, fmt.Errorf("expected an archive reason id after the candidate id")
+	}
+
+	candidate, err := fetchPlanCandidate(row[0])
+	if err != nil {
+		return nil, err
+	}
+	return DiffArchive(endpoint, candidate, strings.TrimSpace(row[1])), nil
+}
+
+// DiffTags returns the mutation adding the tags the candidate doesn't have.
+func DiffTags(endpoint Endpoint, candidate *Candidate, tags []string) []Mutation {
+	has := map[string]bool{}
+	for _, tag := range candidate.Tags {
+		has[tag] = true
+	}
+
+	var add []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !has[tag] {
+			has[tag] = true
+			add = append(add, tag)
+		}
+	}
+
+	if len(add) == 0 {
+		return nil
+	}
+
+	after := append(append([]string{}, candidate.Tags...), add...)
+	body := map[string][]string{"tags": add}
+	return []Mutation{CandidateMutation(endpoint, "addTags", candidate, body, candidate.Tags, after)}
+}
+
+// DiffRemovedTags returns the mutation removing the candidate's tags that are
+// not in keep.
+func DiffRemovedTags(endpoint Endpoint, candidate *Candidate, keep []string) []Mutation {
+	kept := map[string]bool{}
+	for _, tag := range keep {
+		kept[strings.TrimSpace(tag)] = true
+	}
+
+	var remove, after []string
+	for _, tag := range candidate.Tags {
+		if kept[tag] {
+			after = append(after, tag)
+		} else {
+			remove = append(remove, tag)
+		}
+	}
+
+	if len(remove) == 0 {
+		return nil
+	}
+
+	body := map[string][]string{"tags": remove}
+	return []Mutation{CandidateMutation(endpoint, "removeTags", candidate, body, candidate.Tags, after)}
+}
+
+// DiffStage returns the mutation moving the candidate to stage, if needed.
+func DiffStage(endpoint Endpoint, candidate *Candidate, stage string) []Mutation {
+	if candidate.Stage == stage {
+		return nil
+	}
+
+	body := map[string]string{"stage": stage}
+	return []Mutation{CandidateMutation(endpoint, "moveStage", candidate, body, candidate.Stage, stage)}
+}
+
+// DiffArchive returns the mutation archiving the candidate with reason, if
+// it isn't archived with that reason already.
+func DiffArchive(endpoint Endpoint, candidate *Candidate, reason string) []Mutation {
+	current := candidate.Archived.Reason
+	if current == "" {
+		current = candidate.Archived.ArchivedReason
+	}
+	if current == reason {
+		return nil
+	}
+
+	var before interface{}
+	if current != "" {
+		before = current
+	}
+
+	body := map[string]string{"reason": reason}
+	return []Mutation{CandidateMutation(endpoint, "archive", candidate, body, before, reason)}
+}
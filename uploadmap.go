@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// UploadMapping maps the columns of an arbitrary csv with a header row onto
+// the columns an upload endpoint expects, e.g.
+//
+//	columns:
+//	  - source: Candidate ID
+//	    field: candidateId
+//	  - source: Skill
+//	    field: tag
+//	    coerce: lower
+//	  - field: tag
+//	    default: hr-import
+//	  - source: Stage
+//	    field: stage
+//	    coerce: stageName
+type UploadMapping struct {
+	Columns []ColumnMapping `yaml:"columns"`
+
+	lookups *Resolver
+}
+
+// ColumnMapping fills an upload field from a source column. Default is used
+// when the source is empty or there is no source column at all.
+type ColumnMapping struct {
+	Source  string `yaml:"source"`
+	Field   string `yaml:"field"`
+	Coerce  string `yaml:"coerce"`
+	Default string `yaml:"default"`
+}
+
+// coercions convert a source value into what lever expects. Name coercions
+// look the id up in the reference data named by the value.
+var coercions = map[string]string{
+	"lower":             "",
+	"upper":             "",
+	"stageName":         "stages",
+	"archiveReasonName": "archiveReasons",
+}
+
+// uploadMapping is set with --upload-map and applies to every upload.
+var uploadMapping *UploadMapping
+
+func LoadUploadMapping(path string) (*UploadMapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping UploadMapping
+	if err := yaml.UnmarshalStrict(data, &mapping); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var kinds []string
+	for _, column := range mapping.Columns {
+		if column.Field == "" {
+			return nil, fmt.Errorf("%s: every column needs a field", path)
+		}
+
+		if column.Source == "" && column.Default == "" {
+			return nil, fmt.Errorf("%s: %s needs a source column or a default", path, column.Field)
+		}
+
+		kind, ok := coercions[column.Coerce]
+		if column.Coerce != "" && !ok {
+			return nil, fmt.Errorf("%s: unknown coercion %q for %s", path, column.Coerce, column.Field)
+		}
+		if kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	if len(kinds) > 0 {
+		RequireToken()
+		if mapping.lookups, err = NewResolver(strings.Join(kinds, ",")); err != nil {
+			return nil, err
+		}
+	}
+	return &mapping, nil
+}
+
+// RowReader reads the rows of an upload input, the candidate id first
+// followed by the endpoint's columns.
+type RowReader interface {
+	Read() ([]string, error)
+}
+
+// OpenUploadRows opens the input for an upload endpoint, mapping its columns
+// when an upload mapping is set. It returns the file row of the first data
+// row so problems can be reported against the file.
+func OpenUploadRows(endpoint Endpoint, input string) (RowReader, int, io.Closer, error) {
+	r, f, err := OpenCandidateList(input)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	// Rows have as many columns as the upload needs
+	r.FieldsPerRecord = -1
+
+	if uploadMapping == nil {
+		return r, 1, f, nil
+	}
+
+	mapped, err := uploadMapping.Reader(endpoint.Columns, r)
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+	return mapped, 2, f, nil
+}
+
+// MappedReader reads rows of a source csv as the rows an upload expects.
+type MappedReader struct {
+	mapping *UploadMapping
+	columns []UploadColumn
+	r       *csv.Reader
+	header  map[string]int
+}
+
+// Reader reads the header of the source csv and checks every mapped column
+// is present.
+func (m *UploadMapping) Reader(columns []UploadColumn, r *csv.Reader) (*MappedReader, error) {
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the input header: %v", err)
+	}
+
+	mr := &MappedReader{mapping: m, columns: columns, r: r, header: map[string]int{}}
+	for i, name := range header {
+		mr.header[strings.TrimSpace(name)] = i
+	}
+
+	for _, column := range m.Columns {
+		if _, ok := mr.header[column.Source]; column.Source != "" && !ok {
+			return nil, fmt.Errorf("the input has no %q column", column.Source)
+		}
+
+		known := false
+		for _, c := range columns {
+			known = known || c.Name == column.Field
+		}
+		if !known {
+			return nil, fmt.Errorf("the upload has no %s field", column.Field)
+		}
+	}
+	return mr, nil
+}
+
+func (mr *MappedReader) Read() ([]string, error) {
+	record, err := mr.r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var row []string
+	for _, column := range mr.columns {
+		values := []string{}
+		for _, mapping := range mr.mapping.Columns {
+			if mapping.Field != column.Name {
+				continue
+			}
+
+			value, err := mr.value(mapping, record)
+			if err != nil {
+				return nil, err
+			}
+
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+
+		if column.Repeated {
+			row = append(row, values...)
+		} else if len(values) > 0 {
+			row = append(row, values[0])
+		} else {
+			row = append(row, "")
+		}
+	}
+	return row, nil
+}
+
+func (mr *MappedReader) value(mapping ColumnMapping, record []string) (string, error) {
+	value := ""
+	if i, ok := mr.header[mapping.Source]; ok && i < len(record) {
+		value = strings.TrimSpace(record[i])
+	}
+
+	if value == "" {
+		return mapping.Default, nil
+	}
+
+	lookups := mr.mapping.lookups
+	switch mapping.Coerce {
+	case "lower":
+		return strings.ToLower(value), nil
+	case "upper":
+		return strings.ToUpper(value), nil
+	case "stageName":
+		return lookupID(lookups.Stages, value, "stage")
+	case "archiveReasonName":
+		return lookupID(lookups.ArchiveReasons, value, "archive reason")
+	}
+	return value, nil
+}
+
+// MappingError is a row whose values could not be mapped, the rest of the
+// input can still be read.
+type MappingError struct {
+	Message string
+}
+
+func (e *MappingError) Error() string {
+	return e.Message
+}
+
+// lookupID finds the id for a name in reference data, ignoring case.
+func lookupID(names map[string]string, name, kind string) (string, error) {
+	var ids []string
+	for id, text := range names {
+		if strings.EqualFold(text, name) {
+			ids = append(ids, id)
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", &MappingError{fmt.Sprintf("no %s named %q", kind, name)}
+	case 1:
+		return ids[0], nil
+	}
+
+	// Map iteration order would pick a different one each run
+	sort.Strings(ids)
+	return "", &MappingError{fmt.Sprintf("%d %ss are named %q (%s), map the id instead", len(ids), kind, name, strings.Join(ids, ", "))}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Watermark tracks the latest updatedAt written so --watch polls only ask
+// lever for records changed since. Lever's updated_at_start is inclusive,
+// so the ids already written at the watermark are remembered and skipped.
+type Watermark struct {
+	store StateStore
+	key   string
+
+	// Since is where the current poll starts, Max the latest seen by the
+	// last poll that got through.
+	Since       int `json:"-"`
+	seenAtSince map[string]bool
+	Max         int             `json:"updatedAt"`
+	SeenAtMax   map[string]bool `json:"ids"`
+
+	// The latest written by the current poll, which only becomes Max once
+	// the poll succeeds.
+	next       int
+	seenAtNext map[string]bool
+}
+
+// watermark is nil unless --watch is used.
+var watermark *Watermark
+
+// LoadWatermark reads the watermark saved by an earlier run, if any,
+// falling back to the file watermarks were kept in before the state store.
+func LoadWatermark(store StateStore, key string) (*Watermark, error) {
+	w := &Watermark{store: store, key: key, SeenAtMax: map[string]bool{}}
+
+	data, err := store.Get(watermarkBucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		data, err = ioutil.ReadFile(StatePath(key + "_watermark.json"))
+		if os.IsNotExist(err) {
+			return w, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := json.Unmarshal(data, w); err != nil {
+		return nil, err
+	}
+	if w.SeenAtMax == nil {
+		w.SeenAtMax = map[string]bool{}
+	}
+	return w, nil
+}
+
+// StartPoll returns the query params for a poll of records changed since the
+// watermark.
+func (w *Watermark) StartPoll(params []QueryParam) []QueryParam {
+	w.Since = w.Max
+	w.seenAtSince = w.SeenAtMax
+
+	// What a failed poll wrote is fetched again, so it starts from Max too
+	w.next = w.Max
+	w.seenAtNext = map[string]bool{}
+	for id := range w.SeenAtMax {
+		w.seenAtNext[id] = true
+	}
+	if w.Since == 0 {
+		return params
+	}
+	return append(append([]QueryParam{}, params...), QueryParam{Field: "updated_at_start", Value: strconv.Itoa(w.Since)})
+}
+
+// Changed reports if obj is new or changed since it was last written, going
+// by the watermark the poll started from.
+func (w *Watermark) Changed(obj interface{}) bool {
+	if w == nil {
+		return true
+	}
+
+	at, key, ok := watermarkKey(obj)
+	return !ok || at != w.Since || !w.seenAtSince[key]
+}
+
+// Observe stages moving the watermark on to obj once it has been written,
+// see Commit.
+func (w *Watermark) Observe(obj interface{}) {
+	if w == nil {
+		return
+	}
+
+	at, key, ok := watermarkKey(obj)
+	switch {
+	case !ok:
+	case at > w.next:
+		w.next = at
+		w.seenAtNext = map[string]bool{key: true}
+	case at == w.next:
+		if w.seenAtNext == nil {
+			w.seenAtNext = map[string]bool{}
+		}
+		w.seenAtNext[key] = true
+	}
+}
+
+// Commit moves the watermark on to the latest record written by the poll
+// that just got through and saves it.
+func (w *Watermark) Commit() error {
+	w.Max, w.SeenAtMax = w.next, w.seenAtNext
+	if w.SeenAtMax == nil {
+		w.SeenAtMax = map[string]bool{}
+	}
+	return w.Save()
+}
+
+// watermarkKey returns the updatedAt and id a record is tracked by.
+func watermarkKey(obj interface{}) (int, string, bool) {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return 0, "", false
+	}
+
+	updatedAt, id := v.FieldByName("UpdatedAt"), v.FieldByName("ID")
+	if !updatedAt.IsValid() || !id.IsValid() {
+		return 0, "", false
+	}
+	return int(updatedAt.Int()), id.String(), true
+}
+
+func (w *Watermark) Save() error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return versions.Save(w.store, StatePut{Bucket: watermarkBucket, Key: w.key, Value: data})
+}
+
+// SupportsWatch reports if an endpoint's records carry the updatedAt a
+// watch needs, lever only filters top level lists by it.
+func SupportsWatch(endpoint Endpoint) bool {
+	record, ok := recordTypes[endpoint.Type]
+	if !ok || endpoint.Planner != nil || strings.Contains(endpoint.SprintfPath, "%s") {
+		return false
+	}
+
+	_, ok = reflect.TypeOf(record).FieldByName("UpdatedAt")
+	return ok
+}
+
+// Watch runs poll every interval until the process is told to stop, the
+// run before the first poll having just got through. A failed poll is logged
+// and the next one starts from the same watermark, only polls that succeed
+// move it on.
+func Watch(interval time.Duration, poll func() error) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	for succeeded := true; ; {
+		FlushOutput()
+		if succeeded {
+			if err := watermark.Commit(); err != nil {
+				logrus.Error("Unable to save watermark: ", err)
+			}
+		}
+
+		logrus.WithFields(logrus.Fields{"interval": interval.String(), "updatedAt": watermark.Max}).Info("Waiting for the next poll")
+		select {
+		case <-stop:
+			logrus.Info("Stopping watch")
+			return
+		case <-time.After(interval):
+		}
+
+		err := poll()
+		if succeeded = err == nil; !succeeded {
+			if leverErr, ok := err.(*LeverError); ok {
+				logrus.WithFields(leverErr.Fields()).Error(leverErr)
+			} else {
+				logrus.Error(err)
+			}
+		}
+	}
+}
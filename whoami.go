@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "whoami",
+		Description: "Look up a lever user by email, useful for building --performAs values",
+		Run:         runWhoami,
+	})
+}
+
+func runWhoami(args []string) error {
+	flags := NewCommandFlags("whoami")
+	email := flags.String("email", "", "Email address of the lever user to look up")
+	flags.Parse(args)
+	RequireToken()
+
+	if *email == "" {
+		return errors.New("whoami needs an --email to look up")
+	}
+
+	user, err := FindUserByEmail(*email)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("id:         %s\n", user.ID)
+	fmt.Printf("name:       %s\n", user.Name)
+	fmt.Printf("email:      %s\n", user.Email)
+	fmt.Printf("accessRole: %s\n", user.AccessRole)
+	fmt.Printf("\nuse --performAs=%s to act as this user\n", user.ID)
+	return nil
+}
+
+// FindUserByEmail returns the lever user with the given email address.
+func FindUserByEmail(email string) (*User, error) {
+	endpoint := Endpoint{
+		Method:      "GET",
+		SprintfPath: "/users",
+		QueryParams: []QueryParam{{Field: "email", Value: email}},
+	}
+
+	req, err := http.NewRequest(endpoint.Method, endpoint.URLString(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, body, err := SendLeverRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewLeverError(resp, body)
+	}
+
+	var page LeverData
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, err
+	}
+
+	var users []User
+	if err := json.Unmarshal(page.Data, &users); err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("no lever user found with email %s", email)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// WorkdayMapping describes the fixed set of columns the HRIS team's inbound
+// integration expects and where each is taken from in the export.
+//
+//	resource: offers
+//	dateFormat: "2006-01-02"
+//	columns:
+//	  - name: Applicant_ID
+//	    field: candidateId
+//	  - name: Hire_Date
+//	    field: field.anticipated_start_date
+//	    type: date
+//	  - name: Country_ISO_Code
+//	    default: USA
+//
+// Offer form values are available as field.<identifier>.
+type WorkdayMapping struct {
+	Resource   string          `yaml:"resource"`
+	DateFormat string          `yaml:"dateFormat"`
+	Columns    []WorkdayColumn `yaml:"columns"`
+}
+
+type WorkdayColumn struct {
+	Name    string `yaml:"name"`
+	Field   string `yaml:"field"`
+	Type    string `yaml:"type"`
+	Default string `yaml:"default"`
+}
+
+func init() {
+	registeredConverters["workday"] = Converter{
+		Name:        "workday",
+		Description: "Workday inbound integration csv driven by a --mapping file",
+		Convert:     convertWorkday,
+	}
+}
+
+func LoadWorkdayMapping(path string) (*WorkdayMapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := &WorkdayMapping{DateFormat: "2006-01-02"}
+	if err := yaml.Unmarshal(data, mapping); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	if len(mapping.Columns) == 0 {
+		return nil, fmt.Errorf("%s does not define any columns", path)
+	}
+
+	for _, column := range mapping.Columns {
+		if column.Name == "" {
+			return nil, fmt.Errorf("%s has a column without a name", path)
+		}
+
+		if column.Type != "" && column.Type != "string" && column.Type != "date" {
+			return nil, fmt.Errorf("column %s has unknown type %q, expected string or date", column.Name, column.Type)
+		}
+	}
+	return mapping, nil
+}
+
+func convertWorkday(options ConvertOptions, report *MappingReport) error {
+	if options.Mapping == "" {
+		return errors.New("the workday converter needs a --mapping file")
+	}
+
+	mapping, err := LoadWorkdayMapping(options.Mapping)
+	if err != nil {
+		return err
+	}
+
+	var input string
+	switch mapping.Resource {
+	case "candidates":
+		input = options.Candidates
+	case "offers":
+		input = options.Offers
+	default:
+		return fmt.Errorf("workday mapping resource must be candidates or offers, not %q", mapping.Resource)
+	}
+
+	if input == "" {
+		return fmt.Errorf("the workday mapping converts %s, pass the export with --%s", mapping.Resource, mapping.Resource)
+	}
+
+	header := make([]string, len(mapping.Columns))
+	mapped := map[string]bool{}
+	for i, column := range mapping.Columns {
+		header[i] = column.Name
+		mapped[strings.Split(column.Field, ".")[0]] = true
+	}
+
+	out, err := CreateCSV(filepath.Join(options.OutDir, "workday_"+mapping.Resource+".csv"), header)
+	if err != nil {
+		return err
+	}
+
+	err = ReadRecords(input, func(record json.RawMessage) error {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(record, &fields); err != nil {
+			return err
+		}
+		report.Track(mapping.Resource, record, mapped)
+
+		if mapping.Resource == "offers" {
+			fields["field"] = offerFieldsByIdentifier(fields["fields"])
+		}
+
+		row := make([]string, len(mapping.Columns))
+		for i, column := range mapping.Columns {
+			row[i] = mapping.Format(column, fields)
+		}
+		return out.Write(row)
+	})
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Format renders a single column value for a record.
+func (mapping *WorkdayMapping) Format(column WorkdayColumn, record map[string]interface{}) string {
+	value, ok := interface{}(nil), false
+	if column.Field != "" {
+		value, ok = LookupField(record, strings.Split(column.Field, "."))
+	}
+
+	if !ok || isEmpty(value) {
+		return column.Default
+	}
+
+	if column.Type == "date" {
+		switch v := value.(type) {
+		case float64:
+			return FormatEpoch(int(v), mapping.DateFormat)
+		case string:
+			for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t.Format(mapping.DateFormat)
+				}
+			}
+		}
+	}
+
+	if list, ok := value.([]interface{}); ok {
+		values := make([]string, len(list))
+		for i, item := range list {
+			values[i] = toString(item)
+		}
+		return strings.Join(values, ";")
+	}
+	return toString(value)
+}
+
+func offerFieldsByIdentifier(fields interface{}) map[string]interface{} {
+	byIdentifier := map[string]interface{}{}
+
+	list, _ := fields.([]interface{})
+	for _, item := range list {
+		field, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if identifier, ok := field["identifier"].(string); ok && identifier != "" {
+			byIdentifier[identifier] = field["value"]
+		}
+	}
+	return byIdentifier
+}
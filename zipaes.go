@@ -0,0 +1,150 @@
+package main
+
+import (
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"io"
+)
+
+// WinZip AES encryption, which 7-Zip, WinZip, macOS' Archive Utility and
+// libarchive open given the password. Entries are deflated, then encrypted
+// with AES-256 in WinZip's little endian counter mode and authenticated with
+// HMAC-SHA1, keys coming from PBKDF2 over a random salt per entry.
+const (
+	zipMethodAES     = 99
+	zipAESSaltSize   = 16
+	zipAESKeySize    = 32
+	zipAESAuthSize   = 10
+	zipAESIterations = 1000
+)
+
+// zipAESExtra is the extra field marking an AE-1 entry, whose CRC is kept,
+// encrypted with AES-256 over deflate.
+var zipAESExtra = []byte{0x01, 0x99, 0x07, 0x00, 0x01, 0x00, 'A', 'E', 0x03, 0x08, 0x00}
+
+// zipAESCompressor returns a compressor for zipMethodAES entries encrypting
+// with password.
+func zipAESCompressor(password string) func(io.Writer) (io.WriteCloser, error) {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		salt := make([]byte, zipAESSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+
+		keys := pbkdf2SHA1([]byte(password), salt, zipAESIterations, 2*zipAESKeySize+2)
+		block, err := aes.NewCipher(keys[:zipAESKeySize])
+		if err != nil {
+			return nil, err
+		}
+
+		// The salt and password verifier come before the encrypted data. The
+		// zip writer only writes the entry's header after making its
+		// compressor, so they wait for the first write.
+		enc := &zipAESWriter{
+			w:      w,
+			block:  block,
+			mac:    hmac.New(sha1.New, keys[zipAESKeySize:2*zipAESKeySize]),
+			prefix: append(salt, keys[2*zipAESKeySize:]...),
+		}
+		deflate, err := flate.NewWriter(enc, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		return &zipAESEntry{Writer: deflate, deflate: deflate, enc: enc}, nil
+	}
+}
+
+type zipAESEntry struct {
+	io.Writer
+	deflate *flate.Writer
+	enc     *zipAESWriter
+}
+
+// Close flushes the compressed data and appends the authentication code.
+func (e *zipAESEntry) Close() error {
+	if err := e.deflate.Close(); err != nil {
+		return err
+	}
+	if err := e.enc.writePrefix(); err != nil {
+		return err
+	}
+	_, err := e.enc.w.Write(e.enc.mac.Sum(nil)[:zipAESAuthSize])
+	return err
+}
+
+// zipAESWriter encrypts with AES in counter mode counting from 1 as a little
+// endian integer, which is where WinZip differs from crypto/cipher's CTR.
+type zipAESWriter struct {
+	w       io.Writer
+	block   cipher.Block
+	mac     hash.Hash
+	prefix  []byte
+	counter uint64
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func (z *zipAESWriter) writePrefix() error {
+	if z.prefix == nil {
+		return nil
+	}
+	_, err := z.w.Write(z.prefix)
+	z.prefix = nil
+	return err
+}
+
+func (z *zipAESWriter) Write(p []byte) (int, error) {
+	if err := z.writePrefix(); err != nil {
+		return 0, err
+	}
+
+	out := make([]byte, len(p))
+	for i, b := range p {
+		if z.counter == 0 || z.used == aes.BlockSize {
+			z.counter++
+			var nonce [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(nonce[:], z.counter)
+			z.block.Encrypt(z.stream[:], nonce[:])
+			z.used = 0
+		}
+		out[i] = b ^ z.stream[z.used]
+		z.used++
+	}
+
+	z.mac.Write(out)
+	if _, err := z.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// pbkdf2SHA1 derives a key of keyLen bytes from password as RFC 2898
+// describes.
+func pbkdf2SHA1(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
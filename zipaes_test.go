@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestPBKDF2SHA1(t *testing.T) {
+	// RFC 6070's test vectors
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"password", "salt", 1, 20, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, 20, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, 20, "4b007901b765489abead49d926f721d065a429c1"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 25, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+	}
+
+	for _, test := range tests {
+		got := hex.EncodeToString(pbkdf2SHA1([]byte(test.password), []byte(test.salt), test.iterations, test.keyLen))
+		if got != test.want {
+			t.Errorf("pbkdf2SHA1(%q, %q, %d) = %s, want %s", test.password, test.salt, test.iterations, got, test.want)
+		}
+	}
+}
+
+// testPackage writes an encrypted package of files into dir and returns its
+// path.
+func testPackage(t *testing.T, dir, password string, files map[string][]byte) string {
+	p := &Package{Dir: filepath.Join(dir, "out"), Password: password}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+		p.Add(path)
+	}
+
+	path, err := p.Write("candidates", RunSummary{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testPackageFiles returns a large file, taking several deflate blocks and
+// AES counters, a small one and an empty one.
+func testPackageFiles() map[string][]byte {
+	var large bytes.Buffer
+	for i := 0; large.Len() < 256<<10; i++ {
+		fmt.Fprintf(&large, `{"id":"candidate-%d","name":"Candidate %d"}`+"\n", i, i*7919)
+	}
+
+	return map[string][]byte{
+		"candidates.json": large.Bytes(),
+		"users.json":      []byte(`{"id":"user-1"}` + "\n"),
+		"empty.json":      nil,
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// zipAESDecompressor decrypts zipMethodAES entries as WinZip's AE-1
+// specification describes, checking the password verifier and the
+// authentication code before inflating.
+func zipAESDecompressor(password string) func(io.Reader) io.ReadCloser {
+	return func(r io.Reader) io.ReadCloser {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return ioutil.NopCloser(errReader{err})
+		}
+		if len(data) < zipAESSaltSize+2+zipAESAuthSize {
+			return ioutil.NopCloser(errReader{errors.New("entry too short")})
+		}
+
+		salt, data := data[:zipAESSaltSize], data[zipAESSaltSize:]
+		verifier, data := data[:2], data[2:]
+		encrypted, code := data[:len(data)-zipAESAuthSize], data[len(data)-zipAESAuthSize:]
+
+		keys := pbkdf2SHA1([]byte(password), salt, zipAESIterations, 2*zipAESKeySize+2)
+		if !bytes.Equal(verifier, keys[2*zipAESKeySize:]) {
+			return ioutil.NopCloser(errReader{errors.New("wrong password")})
+		}
+
+		mac := hmac.New(sha1.New, keys[zipAESKeySize:2*zipAESKeySize])
+		mac.Write(encrypted)
+		if !hmac.Equal(code, mac.Sum(nil)[:zipAESAuthSize]) {
+			return ioutil.NopCloser(errReader{errors.New("authentication code doesn't match")})
+		}
+
+		block, err := aes.NewCipher(keys[:zipAESKeySize])
+		if err != nil {
+			return ioutil.NopCloser(errReader{err})
+		}
+
+		plain := make([]byte, len(encrypted))
+		var nonce, stream [aes.BlockSize]byte
+		for i := 0; i < len(encrypted); i += aes.BlockSize {
+			binary.LittleEndian.PutUint64(nonce[:], uint64(i/aes.BlockSize+1))
+			block.Encrypt(stream[:], nonce[:])
+			for j := i; j < len(encrypted) && j < i+aes.BlockSize; j++ {
+				plain[j] = encrypted[j] ^ stream[j-i]
+			}
+		}
+		return flate.NewReader(bytes.NewReader(plain))
+	}
+}
+
+// readPackage extracts every entry of the package with password.
+func readPackage(path, password string) (map[string][]byte, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	zr.RegisterDecompressor(zipMethodAES, zipAESDecompressor(password))
+
+	entries := map[string][]byte{}
+	for _, f := range zr.File {
+		if f.Method != zipMethodAES || f.Flags&0x1 == 0 {
+			return nil, fmt.Errorf("%s isn't marked as AES encrypted", f.Name)
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		entries[f.Name] = data
+	}
+	return entries, nil
+}
+
+func TestPackageRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fulcrum-package-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := testPackageFiles()
+	path := testPackage(t, dir, "correct horse", files)
+
+	entries, err := readPackage(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range files {
+		entry := entryName(filepath.Join(dir, name))
+		if got, ok := entries[entry]; !ok {
+			t.Errorf("%s is missing from the package", entry)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s: read %d bytes that don't match the %d written", entry, len(got), len(want))
+		}
+	}
+	if _, ok := entries["summary.json"]; !ok {
+		t.Error("summary.json is missing from the package")
+	}
+
+	if _, err := readPackage(path, "wrong horse"); err == nil {
+		t.Error("the package opened with the wrong password")
+	}
+}
+
+// TestEncryptedPackage opens an encrypted package with libarchive's bsdtar,
+// which reads WinZip AES, so the format is checked against a reader other
+// than our own.
+func TestEncryptedPackage(t *testing.T) {
+	bsdtar, err := exec.LookPath("bsdtar")
+	if err != nil {
+		t.Skip("bsdtar is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "fulcrum-package-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := testPackageFiles()
+	path := testPackage(t, dir, "correct horse", files)
+
+	for name, want := range files {
+		entry := entryName(filepath.Join(dir, name))
+		got, err := exec.Command(bsdtar, "-xOf", path, "--passphrase", "correct horse", entry).Output()
+		if err != nil {
+			t.Errorf("bsdtar couldn't extract %s: %v", entry, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s: extracted %d bytes that don't match the %d written", entry, len(got), len(want))
+		}
+	}
+
+	if err := exec.Command(bsdtar, "-xOf", path, "--passphrase", "wrong horse").Run(); err == nil {
+		t.Error("bsdtar extracted the package with the wrong password")
+	}
+}